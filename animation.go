@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// animationPaletteSize defines the max number of opaque colours of the GIF frames palette.
+	animationPaletteSize = 255
+	// animationPaletteSamples defines the max number of pixels sampled to compute the GIF frames palette.
+	animationPaletteSamples = 1 << 16
+)
+
+// AnimationLimits defines the max number of frames and the max total number
+// of pixels, across all the frames, allowed for animated images.
+type AnimationLimits struct {
+	MaxFrames int
+	MaxPixels float64
+}
+
+// animationLimits defines the limits used when processing animated images.
+var animationLimits = AnimationLimits{MaxFrames: 300, MaxPixels: 18e6}
+
+// ErrAnimationTooBig is returned when an animated image exceeds the animation limits.
+var ErrAnimationTooBig = NewError("Animated image exceeds the maximum allowed frames or resolution", http.StatusUnprocessableEntity)
+
+// Animation represents the frames of an animated image, each one composed onto the whole canvas.
+type Animation struct {
+	Frames []image.Image
+	// Delays defines the display time of each frame in milliseconds.
+	Delays []int
+	// LoopCount defines the number of times the animation is played. 0 means infinite.
+	LoopCount int
+}
+
+// SetAnimationLimits defines the animated images limits based on the server options.
+func SetAnimationLimits(o ServerOptions) {
+	animationLimits = AnimationLimits{
+		MaxFrames: o.MaxAnimationFrames,
+		MaxPixels: o.MaxAnimationPixels * 1e6,
+	}
+}
+
+// processAnimation applies the given options to every frame of an animated GIF or WebP image,
+// preserving its animation if the output format supports it. The frames are encoded with the given encoder options.
+// It returns false if the image is not animated or the output format is not animated.
+func processAnimation(buf []byte, opts bimg.Options, encoder EncoderOptions) (Image, bool, error) {
+	imageType := bimg.DetermineImageType(buf)
+	if imageType != bimg.GIF && imageType != bimg.WEBP {
+		return Image{}, false, nil
+	}
+
+	outputType := opts.Type
+	if outputType == bimg.UNKNOWN {
+		outputType = imageType
+	}
+	if outputType != bimg.GIF && outputType != bimg.WEBP {
+		return Image{}, false, nil
+	}
+
+	anim, err := decodeAnimation(buf, imageType, 0)
+	if err == ErrAnimationTooBig {
+		return Image{}, true, err
+	}
+	if err != nil || anim == nil {
+		// Images that can't be decoded as animations, such as truncated ones, are left to bimg
+		return Image{}, false, nil
+	}
+
+	frameOpts := opts
+	frameOpts.Type = bimg.PNG
+	if outputType == bimg.WEBP {
+		frameOpts.Type = bimg.WEBP
+	}
+	frameOpts.StripMetadata = true
+	frameOpts.NoProfile = true
+	if frameOpts.Gravity == bimg.GravitySmart {
+		// Keep the frames aligned
+		frameOpts.Gravity = bimg.GravityCentre
+	}
+
+	// Encoder options not supported by bimg require encoding lossless TIFF frames instead
+	encode := outputType == bimg.WEBP && !encoder.IsNative()
+	if encode {
+		frameOpts.Type = bimg.TIFF
+	}
+
+	frames := make([][]byte, len(anim.Frames))
+	for i, frame := range anim.Frames {
+		var in bytes.Buffer
+		if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&in, frame); err != nil {
+			return Image{}, true, err
+		}
+		if frames[i], err = bimg.Resize(in.Bytes(), frameOpts); err != nil {
+			return Image{}, true, err
+		}
+		if encode {
+			if frames[i], err = encodeImage(frames[i], bimg.WEBP, encoder, frameOpts); err != nil {
+				return Image{}, true, err
+			}
+		}
+	}
+
+	if outputType == bimg.WEBP {
+		size, err := bimg.Size(frames[0])
+		if err != nil {
+			return Image{}, true, err
+		}
+		body, err := muxWebPAnimation(frames, size.Width, size.Height, anim.Delays, anim.LoopCount)
+		return Image{Body: body, Mime: "image/webp"}, true, err
+	}
+
+	out := &Animation{Delays: anim.Delays, LoopCount: anim.LoopCount}
+	for _, frame := range frames {
+		img, err := png.Decode(bytes.NewReader(frame))
+		if err != nil {
+			return Image{}, true, err
+		}
+		out.Frames = append(out.Frames, img)
+	}
+
+	body, err := encodeGIFAnimation(out)
+	return Image{Body: body, Mime: "image/gif"}, true, err
+}
+
+// decodeAnimation decodes the given number of first frames of an animated GIF or WebP image,
+// or all of them if zero. It returns nil if the image is not animated.
+func decodeAnimation(buf []byte, imageType bimg.ImageType, frames int) (*Animation, error) {
+	if imageType == bimg.WEBP {
+		return decodeWebPAnimation(buf, frames)
+	}
+	return decodeGIFAnimation(buf, frames)
+}
+
+func decodeGIFAnimation(buf []byte, frames int) (*Animation, error) {
+	config, err := gif.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the frames before decoding them
+	ends, err := gifFrameEnds(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(ends) < 2 {
+		return nil, nil
+	}
+	if frames > 0 && frames < len(ends) {
+		buf = truncateGIF(buf, ends[frames-1])
+		ends = ends[:frames]
+	}
+	if err := checkAnimationLimits(config.Width, config.Height, len(ends)); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &Animation{LoopCount: gifToLoopCount(g.LoopCount)}
+	canvas := image.NewNRGBA(image.Rect(0, 0, config.Width, config.Height))
+
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneNRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, cloneNRGBA(canvas))
+		anim.Delays = append(anim.Delays, g.Delay[i]*10)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	return anim, nil
+}
+
+func decodeWebPAnimation(buf []byte, frames int) (*Animation, error) {
+	webp, err := parseWebPAnimation(buf)
+	if err != nil || webp == nil {
+		return nil, err
+	}
+	if frames > 0 && frames < len(webp.Frames) {
+		webp.Frames = webp.Frames[:frames]
+	}
+	if err := checkAnimationLimits(webp.Width, webp.Height, len(webp.Frames)); err != nil {
+		return nil, err
+	}
+
+	anim := &Animation{LoopCount: webp.LoopCount}
+	canvas := image.NewNRGBA(image.Rect(0, 0, webp.Width, webp.Height))
+
+	for _, frame := range webp.Frames {
+		img, err := rasterize(frame.stillWebP(), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		op := draw.Src
+		if frame.Blend {
+			op = draw.Over
+		}
+
+		r := image.Rect(frame.X, frame.Y, frame.X+frame.Width, frame.Y+frame.Height)
+		draw.Draw(canvas, r, img, img.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, cloneNRGBA(canvas))
+		anim.Delays = append(anim.Delays, frame.Duration)
+
+		if frame.Dispose {
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+
+	return anim, nil
+}
+
+func checkAnimationLimits(width, height, frames int) error {
+	if animationLimits.MaxFrames > 0 && frames > animationLimits.MaxFrames {
+		return ErrAnimationTooBig
+	}
+	if animationLimits.MaxPixels > 0 && float64(width)*float64(height)*float64(frames) > animationLimits.MaxPixels {
+		return ErrAnimationTooBig
+	}
+	return nil
+}
+
+// encodeGIFAnimation encodes the animation frames as GIF, quantising each frame to its own palette.
+func encodeGIFAnimation(anim *Animation) ([]byte, error) {
+	g := &gif.GIF{LoopCount: loopCountToGIF(anim.LoopCount)}
+	for i, frame := range anim.Frames {
+		g.Image = append(g.Image, quantizeFrame(frame))
+		g.Delay = append(g.Delay, (anim.Delays[i]+5)/10)
+		// Every frame covers the whole canvas
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quantizeFrame converts the frame to a paletted image via median cut quantisation.
+// Pixels below the alpha threshold are mapped to a transparent palette entry.
+func quantizeFrame(frame image.Image) *image.Paletted {
+	pixels := opaquePixels(frame)
+
+	samples := pixels
+	if len(pixels) > animationPaletteSamples {
+		step := len(pixels) / animationPaletteSamples
+		samples = make(colorBox, 0, animationPaletteSamples+1)
+		for i := 0; i < len(pixels); i += step {
+			samples = append(samples, pixels[i])
+		}
+	}
+
+	var palette color.Palette
+	if len(samples) > 0 {
+		for _, box := range medianCut(samples, animationPaletteSize) {
+			palette = append(palette, box.mean())
+		}
+	}
+	opaque := palette
+	transparent := len(palette)
+	palette = append(palette, color.NRGBA{})
+
+	bounds := frame.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette)
+
+	// Cache the palette lookups by RGB555 colour
+	var cache [1 << 15]int16
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(frame.At(x, y)).(color.NRGBA)
+			index := transparent
+			if c.A >= paletteAlphaThreshold && len(opaque) > 0 {
+				key := int(c.R>>3)<<10 | int(c.G>>3)<<5 | int(c.B>>3)
+				if cache[key] == 0 {
+					c.A = 255
+					cache[key] = int16(opaque.Index(c)) + 1
+				}
+				index = int(cache[key]) - 1
+			}
+			out.Pix[(y-bounds.Min.Y)*out.Stride+(x-bounds.Min.X)] = uint8(index)
+		}
+	}
+
+	return out
+}
+
+// gifToLoopCount converts the GIF loop count, being -1 no repetition, to a number of plays.
+func gifToLoopCount(loopCount int) int {
+	if loopCount < 0 {
+		return 1
+	}
+	if loopCount == 0 {
+		return 0
+	}
+	return loopCount + 1
+}
+
+func loopCountToGIF(loopCount int) int {
+	if loopCount == 1 {
+		return -1
+	}
+	if loopCount == 0 {
+		return 0
+	}
+	return loopCount - 1
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	out := image.NewNRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+	return out
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func newTestGIF(loopCount int) []byte {
+	palette := color.Palette{color.Transparent, color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}}
+
+	g := &gif.GIF{LoopCount: loopCount, Config: image.Config{Width: 10, Height: 10, ColorModel: palette}}
+	for i, r := range []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(5, 5, 10, 10), image.Rect(0, 0, 5, 5)} {
+		frame := image.NewPaletted(r, palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(1 + i%2)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, (i+1)*10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+
+	var buf bytes.Buffer
+	_ = gif.EncodeAll(&buf, g)
+	return buf.Bytes()
+}
+
+func TestGIFAnimation(t *testing.T) {
+	anim, err := decodeGIFAnimation(newTestGIF(2), 0)
+	if err != nil {
+		t.Fatalf("Cannot decode animation: %s", err)
+	}
+	if len(anim.Frames) != 3 {
+		t.Fatalf("Invalid number of frames: %d", len(anim.Frames))
+	}
+	if anim.LoopCount != 3 {
+		t.Errorf("Invalid loop count: %d", anim.LoopCount)
+	}
+	for i, delay := range []int{100, 200, 300} {
+		if anim.Delays[i] != delay {
+			t.Errorf("Invalid frame %d delay: %d", i, anim.Delays[i])
+		}
+	}
+
+	// Frames must be composed onto the canvas
+	second := anim.Frames[1]
+	if second.Bounds() != image.Rect(0, 0, 10, 10) {
+		t.Fatalf("Invalid frame bounds: %v", second.Bounds())
+	}
+	if c := color.NRGBAModel.Convert(second.At(0, 0)); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("Expected the previous frame to be kept: %#v", c)
+	}
+	if c := color.NRGBAModel.Convert(second.At(9, 9)); c != (color.NRGBA{B: 255, A: 255}) {
+		t.Errorf("Expected the frame to be drawn: %#v", c)
+	}
+
+	buf, err := encodeGIFAnimation(anim)
+	if err != nil {
+		t.Fatalf("Cannot encode animation: %s", err)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("Invalid GIF image: %s", err)
+	}
+	if len(g.Image) != 3 || g.LoopCount != 2 {
+		t.Errorf("Invalid encoded animation: %d frames, %d loops", len(g.Image), g.LoopCount)
+	}
+	if g.Delay[2] != 30 {
+		t.Errorf("Invalid encoded frame delay: %d", g.Delay[2])
+	}
+	if c := color.NRGBAModel.Convert(g.Image[1].At(9, 9)); c != (color.NRGBA{B: 255, A: 255}) {
+		t.Errorf("Invalid encoded frame color: %#v", c)
+	}
+}
+
+func TestGIFAnimationStatic(t *testing.T) {
+	var buf bytes.Buffer
+	_ = gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black}), nil)
+
+	anim, err := decodeGIFAnimation(buf.Bytes(), 0)
+	if err != nil || anim != nil {
+		t.Errorf("Expected no animation for a single frame image: %v", err)
+	}
+}
+
+func TestProcessAnimationTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	_ = gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black}), nil)
+
+	// GIF images without trailer must be left to bimg
+	for _, truncated := range [][]byte{buf.Bytes()[:buf.Len()-1], newTestGIF(0)[:len(newTestGIF(0))-1]} {
+		if _, ok, err := processAnimation(truncated, bimg.Options{Type: bimg.GIF}, nil); ok || err != nil {
+			t.Errorf("Expected no animation for a truncated image: %v, %v", ok, err)
+		}
+	}
+}
+
+func TestAnimationEncoderParams(t *testing.T) {
+	opts, _ := buildParamsFromQuery(map[string][]string{"type": {"webp"}, "webp.effort": {"6"}, "webp.nearlossless": {"true"}})
+	img, err := process(newTestGIF(0), BimgOptions(opts), opts)
+	if err != nil {
+		t.Fatalf("Cannot process animation: %s", err)
+	}
+
+	anim, err := parseWebPAnimation(img.Body)
+	if err != nil || anim == nil || len(anim.Frames) != 3 {
+		t.Fatalf("Invalid WebP animation: %#v, %v", anim, err)
+	}
+	if frame := anim.Frames[0].stillWebP(); bimg.DetermineImageType(frame) != bimg.WEBP {
+		t.Error("Invalid WebP animation frame")
+	}
+}
+
+func TestAnimationLimits(t *testing.T) {
+	defer func(limits AnimationLimits) { animationLimits = limits }(animationLimits)
+
+	animationLimits = AnimationLimits{MaxFrames: 2}
+	if _, err := decodeGIFAnimation(newTestGIF(0), 0); err != ErrAnimationTooBig {
+		t.Errorf("Expected a max frames error, got: %v", err)
+	}
+
+	animationLimits = AnimationLimits{MaxPixels: 299}
+	if _, err := decodeGIFAnimation(newTestGIF(0), 0); err != ErrAnimationTooBig {
+		t.Errorf("Expected a max pixels error, got: %v", err)
+	}
+
+	// The limits must be enforced before decoding the frames
+	animationLimits = AnimationLimits{MaxFrames: 1000}
+	if _, err := decodeGIFAnimation(newTestGIFBlocks(1001), 0); err != ErrAnimationTooBig {
+		t.Errorf("Expected a max frames error before decoding, got: %v", err)
+	}
+}
+
+// newTestGIFBlocks returns a GIF image with the given number of frames, whose data is not valid LZW.
+func newTestGIFBlocks(frames int) []byte {
+	buf := []byte("GIF89a\x0a\x00\x0a\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff")
+	for i := 0; i < frames; i++ {
+		// Graphic control extension, followed by the image descriptor and its data
+		buf = append(buf, "\x21\xf9\x04\x00\x0a\x00\x00\x00"...)
+		buf = append(buf, "\x2c\x00\x00\x00\x00\x0a\x00\x0a\x00\x00\x02\x02\xff\xff\x00"...)
+	}
+	return append(buf, 0x3b)
+}
+
+func TestGIFFrameEnds(t *testing.T) {
+	buf := newTestGIF(0)
+	ends, err := gifFrameEnds(buf)
+	if err != nil {
+		t.Fatalf("Cannot scan GIF frames: %s", err)
+	}
+	if len(ends) != 3 || ends[2] != len(buf)-1 {
+		t.Errorf("Invalid GIF frame ends: %v", ends)
+	}
+
+	ends, err = gifFrameEnds(newTestGIFBlocks(5))
+	if err != nil || len(ends) != 5 {
+		t.Errorf("Invalid number of GIF frames: %d, %v", len(ends), err)
+	}
+
+	if _, err := gifFrameEnds(buf[:len(buf)-10]); err != errInvalidGIF {
+		t.Errorf("Expected an invalid GIF error, got: %v", err)
+	}
+}
+
+func TestWebPAnimationMux(t *testing.T) {
+	frame := func(data string) []byte {
+		return writeWebPChunks([]riffChunk{
+			{ID: "VP8X", Data: webpHeader(webpAlphaFlag, 20, 10)},
+			{ID: "ALPH", Data: []byte("alpha")},
+			{ID: "VP8 ", Data: []byte(data)},
+		})
+	}
+
+	buf, err := muxWebPAnimation([][]byte{frame("one"), frame("two")}, 20, 10, []int{40, 80}, 0)
+	if err != nil {
+		t.Fatalf("Cannot mux animation: %s", err)
+	}
+
+	anim, err := parseWebPAnimation(buf)
+	if err != nil || anim == nil {
+		t.Fatalf("Cannot parse animation: %v", err)
+	}
+	if anim.Width != 20 || anim.Height != 10 || anim.LoopCount != 0 {
+		t.Errorf("Invalid animation canvas: %#v", anim)
+	}
+	if len(anim.Frames) != 2 {
+		t.Fatalf("Invalid number of frames: %d", len(anim.Frames))
+	}
+
+	second := anim.Frames[1]
+	if second.Duration != 80 || second.Width != 20 || second.Height != 10 || second.Blend || second.Dispose {
+		t.Errorf("Invalid frame: %#v", second)
+	}
+
+	chunks, err := readWebPChunks(second.stillWebP())
+	if err != nil {
+		t.Fatalf("Invalid still frame: %s", err)
+	}
+	if len(chunks) != 3 || chunks[0].ID != "VP8X" || string(chunks[2].Data) != "two" {
+		t.Errorf("Invalid still frame chunks: %#v", chunks)
+	}
+}
+
+func TestWebPAnimationStatic(t *testing.T) {
+	buf := writeWebPChunks([]riffChunk{{ID: "VP8L", Data: []byte("image")}})
+
+	anim, err := parseWebPAnimation(buf)
+	if err != nil || anim != nil {
+		t.Errorf("Expected no animation for a still image: %v", err)
+	}
+	if _, err := parseWebPAnimation([]byte("RIFF")); err == nil {
+		t.Error("Expected an invalid container error")
+	}
+}
+
+func TestQuantizeFrame(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{G: 255, A: 255})
+	img.SetNRGBA(2, 0, color.NRGBA{B: 255, A: 255})
+
+	out := quantizeFrame(img)
+	for x, expected := range []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}, {}} {
+		if c := color.NRGBAModel.Convert(out.At(x, 0)); c != expected {
+			t.Errorf("Invalid pixel %d color: %#v", x, c)
+		}
+	}
+}
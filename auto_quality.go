@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+// QualityAuto defines the quality param value enabling the perceptual auto quality.
+const QualityAuto = "auto"
+
+// Auto quality targets, defining how similar the encoded image must be to the processed image.
+const (
+	QualityTargetLow    = "low"
+	QualityTargetMedium = "medium"
+	QualityTargetHigh   = "high"
+)
+
+// qualityTargets defines the min SSIM of each auto quality target.
+var qualityTargets = map[string]float64{
+	QualityTargetLow:    0.95,
+	QualityTargetMedium: 0.98,
+	QualityTargetHigh:   0.99,
+}
+
+// Auto quality search bounds.
+const (
+	autoQualityMin           = 30
+	autoQualityMax           = 95
+	autoQualityMaxIterations = 6
+	autoQualityTimeout       = 2 * time.Second
+	// autoQualityRasterSize defines the max width and height of the images compared.
+	autoQualityRasterSize = 1024
+)
+
+// qualityTypes defines the output image types whose encoder supports a quality.
+var qualityTypes = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.WEBP: true,
+	bimg.AVIF: true,
+}
+
+// scoreFunc returns the similarity of the image encoded with the given quality to the processed image.
+type scoreFunc func(quality int) (float64, error)
+
+// autoQuality returns the lowest encoder quality whose output image is similar enough to the processed image,
+// by SSIM, for the quality target of the image options. The encoded images are compared to a lossless version
+// of the processed image, up to the requested quality.
+func autoQuality(buf []byte, opts bimg.Options, o ImageOptions) (int, error) {
+	outputType := opts.Type
+	if outputType == bimg.UNKNOWN {
+		outputType = bimg.DetermineImageType(buf)
+	}
+	if !qualityTypes[outputType] {
+		return 0, NewError("Auto quality is only supported for JPEG, WebP and AVIF output images", http.StatusBadRequest)
+	}
+
+	target, ok := qualityTargets[o.QualityTarget]
+	if !ok {
+		target = qualityTargets[QualityTargetMedium]
+	}
+
+	maxQuality := autoQualityMax
+	if opts.Quality > 0 && opts.Quality < maxQuality {
+		maxQuality = opts.Quality
+	}
+	minQuality := autoQualityMin
+	if minQuality > maxQuality {
+		minQuality = maxQuality
+	}
+
+	// The reference image is encoded as PNG, so the encoder params of the output type don't apply
+	referenceOptions := opts
+	referenceOptions.Type = bimg.PNG
+	referenceOptions.Compression = 1
+	referenceImageOptions := o
+	referenceImageOptions.Encoder = nil
+	reference, err := process(buf, referenceOptions, referenceImageOptions)
+	if err != nil {
+		return 0, err
+	}
+	raster, err := rasterize(reference.Body, autoQualityRasterSize)
+	if err != nil {
+		return 0, err
+	}
+	referenceImage := newRGBImage(raster)
+
+	score := func(quality int) (float64, error) {
+		encoded, err := bimg.Resize(reference.Body, bimg.Options{Type: outputType, Quality: quality, NoAutoRotate: true})
+		if err != nil {
+			return 0, err
+		}
+		raster, err := rasterize(encoded, autoQualityRasterSize)
+		if err != nil {
+			return 0, err
+		}
+		if raster, err = resizeRaster(raster, referenceImage.Width, referenceImage.Height); err != nil {
+			return 0, err
+		}
+		return ssim(referenceImage, newRGBImage(raster)), nil
+	}
+
+	quality, err := searchAutoQuality(score, target, minQuality, maxQuality, time.Now().Add(autoQualityTimeout))
+	if err != nil {
+		return 0, NewError(fmt.Sprintf("Cannot compute the auto quality: %s", err), http.StatusBadRequest)
+	}
+	return quality, nil
+}
+
+// searchAutoQuality returns the lowest quality between the given bounds meeting the target similarity,
+// via binary search. The search is bounded by a max number of iterations and the given deadline,
+// returning the lowest quality known to meet the target so far, or the max quality.
+func searchAutoQuality(score scoreFunc, target float64, minQuality, maxQuality int, deadline time.Time) (int, error) {
+	// The lower quality never meets the target, the upper one always does
+	lower, upper := minQuality-1, maxQuality
+	for i := 0; upper-lower > 1 && i < autoQualityMaxIterations && time.Now().Before(deadline); i++ {
+		quality := (lower + upper + 1) / 2
+		similarity, err := score(quality)
+		if err != nil {
+			return 0, err
+		}
+		if similarity >= target {
+			upper = quality
+		} else {
+			lower = quality
+		}
+	}
+	return upper, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+func TestSearchAutoQuality(t *testing.T) {
+	calls := 0
+	score := func(quality int) (float64, error) {
+		calls++
+		return 0.9 + float64(quality)/1000, nil
+	}
+
+	quality, err := searchAutoQuality(score, qualityTargets[QualityTargetMedium], autoQualityMin, autoQualityMax, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if quality < 80 || quality > 82 {
+		t.Errorf("Invalid auto quality: %d", quality)
+	}
+	if calls > autoQualityMaxIterations {
+		t.Errorf("Too many iterations: %d", calls)
+	}
+
+	quality, _ = searchAutoQuality(score, qualityTargets[QualityTargetMedium], autoQualityMin, autoQualityMax, time.Now())
+	if quality != autoQualityMax {
+		t.Errorf("Expected the max quality once the deadline is exceeded: %d", quality)
+	}
+
+	quality, _ = searchAutoQuality(score, 0.9, autoQualityMin, 40, time.Now().Add(time.Minute))
+	if quality != autoQualityMin {
+		t.Errorf("Expected the min quality for any image meeting the target: %d", quality)
+	}
+}
+
+func TestAutoQualityParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{"quality": {"auto"}, "qualitytarget": {"High"}})
+	if err != nil || !opts.AutoQuality || opts.QualityTarget != QualityTargetHigh {
+		t.Errorf("Invalid auto quality params: %#v, %v", opts, err)
+	}
+
+	opts, err = buildParamsFromOperation(PipelineOperation{Params: map[string]interface{}{"quality": "auto"}})
+	if err != nil || !opts.AutoQuality {
+		t.Errorf("Invalid auto quality operation params: %#v, %v", opts, err)
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"qualitytarget": {"best"}}); err == nil {
+		t.Error("Expected an error for an unsupported quality target")
+	}
+}
+
+func TestAutoQualityEncoderParams(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	opts, err := buildParamsFromQuery(map[string][]string{"quality": {"auto"}, "type": {"jpeg"}, "jpeg.trellis": {"true"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+
+	img, err := Process(buf, BimgOptions(opts), opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Quality < autoQualityMin || img.Quality > autoQualityMax || bimg.DetermineImageType(img.Body) != bimg.JPEG {
+		t.Errorf("Invalid auto quality image: %d, %s", img.Quality, img.Mime)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+
+	"github.com/h2non/bimg"
+)
+
+// ExtendBlur extends the image with a blurred copy of itself, scaled to cover the output image.
+// It's not a libvips extend mode, so it's only supported by the resize and fit operations.
+const ExtendBlur bimg.Extend = -1
+
+// blurFill fits the image within the width x height output image, filling the empty bars with
+// a blurred copy of the image scaled to cover the output image, optionally darkened.
+func blurFill(buf []byte, o ImageOptions) (Image, error) {
+	opts := BimgOptions(o)
+	width, height := opts.Width, opts.Height
+
+	// The layers are rasterised at the output image size
+	if err := checkRasterResolution(width, height); err != nil {
+		return Image{}, err
+	}
+
+	// Auto rotate the image losslessly, leaving the encoding to the final pass
+	base, err := bimg.Resize(buf, bimg.Options{Type: bimg.PNG, Compression: 1, NoAutoRotate: o.NoRotation})
+	if err != nil {
+		return Image{}, err
+	}
+	size, err := bimg.Size(base)
+	if err != nil {
+		return Image{}, err
+	}
+
+	fitWidth, fitHeight := calculateDestinationFitDimension(size.Width, size.Height, width, height)
+	foreground, err := blurFillLayer(base, bimg.Options{Width: fitWidth, Height: fitHeight})
+	if err != nil {
+		return Image{}, err
+	}
+
+	// The sigma defaults to a fortieth of the largest output image dimension, and is at least 10
+	sigma := o.Sigma
+	if sigma == 0 {
+		sigma = math.Max(10, math.Max(float64(width), float64(height))/40)
+	}
+	background, err := blurFillLayer(base, bimg.Options{
+		Width:        width,
+		Height:       height,
+		Crop:         true,
+		Enlarge:      true,
+		GaussianBlur: bimg.GaussianBlur{Sigma: sigma, MinAmpl: o.MinAmpl},
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	opts.Width, opts.Height = 0, 0
+	opts.Embed = false
+	opts.GaussianBlur = bimg.GaussianBlur{}
+	opts.Type = rasterOutputType(buf, o)
+	return processRaster(base, blurFillComposite(background, foreground, o.Darken), opts, o)
+}
+
+// blurFillLayer resizes the auto rotated PNG image with the given options into a raster.
+func blurFillLayer(base []byte, opts bimg.Options) (*image.NRGBA, error) {
+	opts.Type = bimg.PNG
+	opts.Compression = 1
+	out, err := bimg.Resize(base, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	return img, nil
+}
+
+// blurFillComposite darkens the background by the given amount, between 0 and 1,
+// and draws the foreground centred over it.
+func blurFillComposite(background, foreground *image.NRGBA, darken float64) *image.NRGBA {
+	if darken > 0 {
+		factor := 1 - darken
+		for i := 0; i < len(background.Pix); i += 4 {
+			for j := 0; j < 3; j++ {
+				background.Pix[i+j] = uint8(math.Round(float64(background.Pix[i+j]) * factor))
+			}
+		}
+	}
+
+	x := (background.Bounds().Dx() - foreground.Bounds().Dx()) / 2
+	y := (background.Bounds().Dy() - foreground.Bounds().Dy()) / 2
+	rect := image.Rect(x, y, x+foreground.Bounds().Dx(), y+foreground.Bounds().Dy())
+	draw.Draw(background, rect, foreground, foreground.Bounds().Min, draw.Over)
+	return background
+}
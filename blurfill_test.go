@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestBlurFill(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"width": {"600"}, "height": {"200"}, "extend": {"blur"}, "darken": {"0.3"}})
+	img, err := Fit(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	size, _ := bimg.Size(img.Body)
+	if size.Width != 600 || size.Height != 200 {
+		t.Errorf("Invalid letterboxed size: %dx%d", size.Width, size.Height)
+	}
+}
+
+func TestBlurFillMaxResolution(t *testing.T) {
+	for _, operation := range []Operation{Resize, Fit} {
+		opts, _ := buildParamsFromQuery(map[string][]string{"width": {"50000"}, "height": {"50000"}, "extend": {"blur"}})
+		if _, err := operation([]byte("not an image"), opts); err != ErrResolutionTooBig {
+			t.Errorf("Expected a resolution too big error, got: %v", err)
+		}
+	}
+}
+
+func TestBlurFillComposite(t *testing.T) {
+	background := image.NewNRGBA(image.Rect(0, 0, 10, 4))
+	for i := range background.Pix {
+		background.Pix[i] = 200
+	}
+	foreground := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(foreground.Pix); i += 4 {
+		foreground.Pix[i], foreground.Pix[i+3] = 255, 255
+	}
+
+	out := blurFillComposite(background, foreground, 0.5)
+	if out.Bounds().Dx() != 10 || out.Bounds().Dy() != 4 {
+		t.Fatalf("Invalid composite size: %v", out.Bounds())
+	}
+	for x, expected := range []color.NRGBA{{100, 100, 100, 200}, {100, 100, 100, 200}, {255, 0, 0, 255}, {255, 0, 0, 255}, {100, 100, 100, 200}} {
+		if c := out.NRGBAAt(x*2, 1); c != expected {
+			t.Errorf("Invalid composite colour at %d: %v != %v", x*2, c, expected)
+		}
+	}
+}
+
+func TestBlurFillParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{"extend": {"blur"}, "darken": {"0.25"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+	if opts.Extend != ExtendBlur || opts.Darken != 0.25 {
+		t.Errorf("Invalid blur extend params: %v, %v", opts.Extend, opts.Darken)
+	}
+	if BimgOptions(opts).Extend != bimg.ExtendCopy {
+		t.Error("The blur extend mode must not be passed to libvips")
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"darken": {"1.5"}}); err == nil {
+		t.Error("Expected an error for darken=1.5")
+	}
+}
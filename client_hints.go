@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Client hints request headers.
+// See: https://developer.mozilla.org/en-US/docs/Web/HTTP/Client_hints
+const (
+	headerDPR                 = "Sec-CH-DPR"
+	headerWidth               = "Sec-CH-Width"
+	headerViewportWidth       = "Sec-CH-Viewport-Width"
+	headerLegacyViewportWidth = "Viewport-Width"
+	headerSaveData            = "Save-Data"
+)
+
+// acceptClientHints defines the client hints requested to the browsers via the Accept-CH header.
+var acceptClientHints = strings.Join([]string{headerDPR, headerWidth, headerViewportWidth}, ", ")
+
+// clientHintsVary defines the request headers the responses vary on if the client hints are enabled.
+var clientHintsVary = []string{headerDPR, headerSaveData}
+
+// clientHintsWidthVary defines the request headers the responses of the width hints endpoints also vary on.
+var clientHintsWidthVary = []string{headerWidth, headerViewportWidth, headerLegacyViewportWidth}
+
+// clientHintsWidthEndpoints defines the resizing endpoints whose output width is defined by the width client hints.
+var clientHintsWidthEndpoints = map[string]bool{"resize": true, "fit": true, "thumbnail": true, "smartcrop": true}
+
+// clientHintsJSONEndpoints defines the endpoints replying JSON, whose responses never vary on the client hints.
+var clientHintsJSONEndpoints = map[string]bool{"info": true, "palette": true, "stats": true, "hash": true}
+
+// saveDataQuality defines the max quality of the images requested with the Save-Data client hint.
+const saveDataQuality = 50
+
+// applyClientHints scales the requested dimensions by the device pixel ratio, defined by the dpr param
+// or, if the client hints are enabled, by the DPR client hint. Requests with no dimensions use the width
+// or viewport width client hints on the resizing endpoints, and the quality is lowered if the client prefers
+// to save data. The client hints are ignored by the endpoints replying JSON.
+// The device pixel ratio is capped by the server max DPR and never scales the output image beyond the
+// max allowed resolution. It returns the request headers the response varies on.
+func applyClientHints(r *http.Request, opts *ImageOptions, size bimg.ImageSize, o ServerOptions) []string {
+	endpoint := endpointName(r)
+	hints := o.EnableClientHints && !clientHintsJSONEndpoints[endpoint]
+	widthHints := hints && clientHintsWidthEndpoints[endpoint]
+
+	var vary []string
+	dpr := opts.DPR
+	if hints {
+		vary = append([]string{}, clientHintsVary...)
+		if widthHints {
+			vary = append(vary, clientHintsWidthVary...)
+		}
+		if dpr == 0 {
+			dpr = parseClientHint(r.Header.Get(headerDPR))
+		}
+	}
+	if o.MaxDPR > 0 {
+		dpr = math.Min(dpr, o.MaxDPR)
+	}
+
+	width, height := opts.Width, opts.Height
+	if dpr > 0 && dpr != 1 {
+		scaleDimensions(opts, dpr)
+	}
+
+	if widthHints && width == 0 && height == 0 {
+		// The width hint is already defined in physical pixels
+		if hint := parseClientHint(r.Header.Get(headerWidth)); hint > 0 {
+			opts.Width = int(hint)
+		} else if hint := parseClientHint(viewportWidthHint(r)); hint > 0 {
+			opts.Width = int(math.Round(hint * math.Max(dpr, 1)))
+		}
+	}
+
+	if hints && strings.EqualFold(r.Header.Get(headerSaveData), "on") && (opts.Quality == 0 || opts.Quality > saveDataQuality) {
+		opts.Quality = saveDataQuality
+	}
+
+	// Scaled dimensions are reduced to the max allowed resolution, but never below the requested ones
+	pixels := outputPixels(opts.Width, opts.Height, size)
+	if (opts.Width != width || opts.Height != height) && o.MaxAllowedPixels > 0 && pixels > o.MaxAllowedPixels*1e6 {
+		factor := math.Sqrt(o.MaxAllowedPixels * 1e6 / pixels)
+		opts.Width = maxInt(int(float64(opts.Width)*factor), width)
+		opts.Height = maxInt(int(float64(opts.Height)*factor), height)
+	}
+
+	return vary
+}
+
+// scaleDimensions multiplies the requested dimensions, including the pipeline operations ones, by the given factor.
+func scaleDimensions(opts *ImageOptions, factor float64) {
+	opts.Width = int(math.Round(float64(opts.Width) * factor))
+	opts.Height = int(math.Round(float64(opts.Height) * factor))
+	for i := range opts.Operations {
+		scaleDimensions(&opts.Operations[i].ImageOptions, factor)
+	}
+}
+
+// outputPixels returns the resolution of the output image with the requested dimensions,
+// based on the aspect ratio of the source image if only one dimension is requested.
+func outputPixels(width, height int, size bimg.ImageSize) float64 {
+	switch {
+	case width > 0 && height > 0:
+		return float64(width) * float64(height)
+	case width > 0 && size.Width > 0:
+		return float64(width) * float64(width) * float64(size.Height) / float64(size.Width)
+	case height > 0 && size.Height > 0:
+		return float64(height) * float64(height) * float64(size.Width) / float64(size.Height)
+	}
+	return 0
+}
+
+func viewportWidthHint(r *http.Request) string {
+	if hint := r.Header.Get(headerViewportWidth); hint != "" {
+		return hint
+	}
+	return r.Header.Get(headerLegacyViewportWidth)
+}
+
+// parseClientHint returns the numeric value of a client hint, or zero if it's invalid.
+func parseClientHint(value string) float64 {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number <= 0 || math.IsInf(number, 0) {
+		return 0
+	}
+	return number
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
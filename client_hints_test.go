@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestClientHints(t *testing.T) {
+	size := bimg.ImageSize{Width: 2000, Height: 1000}
+	hints := ServerOptions{EnableClientHints: true, MaxDPR: 3, MaxAllowedPixels: 18}
+
+	cases := []struct {
+		name    string
+		query   string
+		headers map[string]string
+		server  ServerOptions
+		width   int
+		height  int
+		quality int
+	}{
+		{"dpr param", "width=300&height=200&dpr=2", nil, ServerOptions{MaxDPR: 3}, 600, 400, 0},
+		{"max dpr", "width=300&dpr=4", nil, ServerOptions{MaxDPR: 3}, 900, 0, 0},
+		{"hints disabled", "width=300", map[string]string{headerDPR: "2", headerSaveData: "on"}, ServerOptions{MaxDPR: 3}, 300, 0, 0},
+		{"dpr hint", "width=300", map[string]string{headerDPR: "2"}, hints, 600, 0, 0},
+		{"dpr param over hint", "width=300&dpr=1.5", map[string]string{headerDPR: "2"}, hints, 450, 0, 0},
+		{"width hint", "", map[string]string{headerDPR: "2", headerWidth: "640"}, hints, 640, 0, 0},
+		{"viewport width hint", "", map[string]string{headerDPR: "2", headerLegacyViewportWidth: "400"}, hints, 800, 0, 0},
+		{"save data", "width=300&quality=90", map[string]string{headerSaveData: "on"}, hints, 300, 0, saveDataQuality},
+		{"max resolution", "width=2000&dpr=3", nil, ServerOptions{MaxDPR: 3, MaxAllowedPixels: 8}, 4000, 0, 0},
+		{"requested resolution", "width=6000", nil, ServerOptions{MaxDPR: 3, MaxAllowedPixels: 8}, 6000, 0, 0},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/resize?"+c.query, nil)
+		for name, value := range c.headers {
+			r.Header.Set(name, value)
+		}
+
+		opts, err := buildParamsFromQuery(r.URL.Query())
+		if err != nil {
+			t.Fatalf("Failed reading params for %s: %s", c.name, err)
+		}
+
+		vary := applyClientHints(r, &opts, size, c.server)
+		if opts.Width != c.width || opts.Height != c.height || opts.Quality != c.quality && c.quality != 0 {
+			t.Errorf("Invalid %s dimensions: %dx%d, quality %d", c.name, opts.Width, opts.Height, opts.Quality)
+		}
+		if (len(vary) > 0) != c.server.EnableClientHints {
+			t.Errorf("Invalid %s vary headers: %v", c.name, vary)
+		}
+	}
+}
+
+func TestClientHintsEndpoints(t *testing.T) {
+	hints := ServerOptions{EnableClientHints: true, MaxDPR: 3}
+	headers := map[string]string{headerDPR: "2", headerWidth: "640"}
+
+	cases := []struct {
+		path  string
+		width int
+		vary  int
+	}{
+		{"/resize", 640, 5},
+		{"/prefix/thumbnail", 640, 5},
+		{"/crop", 0, 2},
+		{"/info", 0, 0},
+		{"/hash", 0, 0},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+
+		var opts ImageOptions
+		vary := applyClientHints(r, &opts, bimg.ImageSize{Width: 2000, Height: 1000}, hints)
+		if opts.Width != c.width || len(vary) != c.vary {
+			t.Errorf("Invalid %s client hints: width %d, vary %v", c.path, opts.Width, vary)
+		}
+	}
+}
+
+func TestClientHintsPipeline(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/pipeline", nil)
+	opts := ImageOptions{
+		DPR: 2,
+		Operations: PipelineOperations{
+			{Name: "crop", ImageOptions: ImageOptions{Width: 300, Height: 100}},
+		},
+	}
+
+	applyClientHints(r, &opts, bimg.ImageSize{Width: 1000, Height: 1000}, ServerOptions{MaxDPR: 3})
+	if op := opts.Operations[0].ImageOptions; op.Width != 600 || op.Height != 200 {
+		t.Errorf("Invalid pipeline operation dimensions: %dx%d", op.Width, op.Height)
+	}
+}
+
+func TestDPRParam(t *testing.T) {
+	for _, value := range []string{"0", "abc"} {
+		if _, err := buildParamsFromQuery(map[string][]string{"dpr": {value}}); err == nil {
+			t.Errorf("Expected an error for dpr %s", value)
+		}
+	}
+	if !strings.Contains(acceptClientHints, headerDPR) {
+		t.Errorf("Missing DPR client hint: %s", acceptClientHints)
+	}
+}
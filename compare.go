@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// compareMaxPSNR defines the PSNR reported for identical images, whose PSNR is infinite.
+	compareMaxPSNR = 100
+	// compareWindowSize defines the width and height of the windows SSIM is computed over.
+	compareWindowSize = 8
+	// compareWindowStep defines the distance between two consecutive SSIM windows.
+	compareWindowStep = 4
+	// compareDiffGain defines how much the difference of the pixels is amplified in the diff image.
+	compareDiffGain = 4
+	// compareRasterSize defines the max width and height of the images compared.
+	compareRasterSize = 1024
+)
+
+// Supported outputs of the comparison.
+const (
+	CompareOutputJSON = "json"
+	CompareOutputDiff = "diff"
+)
+
+// SSIM stabilisation constants for 8 bits images.
+var (
+	ssimC1 = math.Pow(0.01*255, 2)
+	ssimC2 = math.Pow(0.03*255, 2)
+)
+
+// CompareInfo represents the similarity metrics of two images.
+type CompareInfo struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	SSIM   float64 `json:"ssim"`
+	PSNR   float64 `json:"psnr"`
+	MAE    float64 `json:"mae"`
+}
+
+// rgbImage stores the RGB values of an image flattened over a white background.
+type rgbImage struct {
+	Width, Height int
+	Pix           []float64
+}
+
+// Compare compares two images, resized to common dimensions, returning their
+// similarity metrics as JSON or an image highlighting their differences.
+func Compare(a, b []byte, o ImageOptions) (Image, error) {
+	first, second, err := rasterizePair(a, b)
+	if err != nil {
+		return Image{}, NewError("Cannot compare images: "+err.Error(), http.StatusBadRequest)
+	}
+
+	if o.Output == CompareOutputDiff {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, diffImage(first, second)); err != nil {
+			return Image{}, err
+		}
+
+		imageType := ImageType(o.Type)
+		if imageType == bimg.UNKNOWN {
+			imageType = bimg.PNG
+		}
+
+		return Process(buf.Bytes(), bimg.Options{
+			Type:        imageType,
+			Quality:     o.Quality,
+			Compression: o.Compression,
+			Speed:       o.Speed,
+		}, o)
+	}
+
+	mse, mae := meanErrors(first, second)
+
+	psnr := float64(compareMaxPSNR)
+	if mse > 0 {
+		psnr = math.Min(10*math.Log10(255*255/mse), compareMaxPSNR)
+	}
+
+	info := CompareInfo{
+		Width:  first.Width,
+		Height: first.Height,
+		SSIM:   toFixed(ssim(first, second), 6),
+		PSNR:   toFixed(psnr, 4),
+		MAE:    toFixed(mae/255, 6),
+	}
+
+	body, _ := json.Marshal(info)
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+// rasterizePair decodes both images, auto-rotated and downsized to the max compared size,
+// downsizing the biggest one to match the smallest width and height of both images if they differ.
+func rasterizePair(a, b []byte) (rgbImage, rgbImage, error) {
+	first, err := rasterize(a, compareRasterSize)
+	if err != nil {
+		return rgbImage{}, rgbImage{}, err
+	}
+	second, err := rasterize(b, compareRasterSize)
+	if err != nil {
+		return rgbImage{}, rgbImage{}, err
+	}
+
+	width := int(math.Min(float64(first.Bounds().Dx()), float64(second.Bounds().Dx())))
+	height := int(math.Min(float64(first.Bounds().Dy()), float64(second.Bounds().Dy())))
+	if width == 0 || height == 0 {
+		return rgbImage{}, rgbImage{}, NewError("Width or height of requested image is zero", http.StatusNotAcceptable)
+	}
+
+	if first, err = resizeRaster(first, width, height); err != nil {
+		return rgbImage{}, rgbImage{}, err
+	}
+	if second, err = resizeRaster(second, width, height); err != nil {
+		return rgbImage{}, rgbImage{}, err
+	}
+
+	return newRGBImage(first), newRGBImage(second), nil
+}
+
+func newRGBImage(img image.Image) rgbImage {
+	bounds := img.Bounds()
+	out := rgbImage{Width: bounds.Dx(), Height: bounds.Dy(), Pix: make([]float64, 0, bounds.Dx()*bounds.Dy()*3)}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			white := float64(0xffff - a)
+			out.Pix = append(out.Pix, (float64(r)+white)/0x101, (float64(g)+white)/0x101, (float64(b)+white)/0x101)
+		}
+	}
+	return out
+}
+
+// luma returns the luma of the pixel at the given index.
+func (img rgbImage) luma(i int) float64 {
+	return 0.299*img.Pix[i*3] + 0.587*img.Pix[i*3+1] + 0.114*img.Pix[i*3+2]
+}
+
+// meanErrors returns the mean squared error and the mean absolute error of the images channels.
+func meanErrors(a, b rgbImage) (mse, mae float64) {
+	for i := range a.Pix {
+		diff := a.Pix[i] - b.Pix[i]
+		mse += diff * diff
+		mae += math.Abs(diff)
+	}
+	n := float64(len(a.Pix))
+	return mse / n, mae / n
+}
+
+// ssim returns the mean structural similarity index of the images luma,
+// computed over overlapping windows.
+func ssim(a, b rgbImage) float64 {
+	windowWidth := int(math.Min(compareWindowSize, float64(a.Width)))
+	windowHeight := int(math.Min(compareWindowSize, float64(a.Height)))
+
+	var total float64
+	var windows int
+	for top := 0; top+windowHeight <= a.Height; top += compareWindowStep {
+		for left := 0; left+windowWidth <= a.Width; left += compareWindowStep {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := top; y < top+windowHeight; y++ {
+				for x := left; x < left+windowWidth; x++ {
+					la, lb := a.luma(y*a.Width+x), b.luma(y*b.Width+x)
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+				}
+			}
+
+			n := float64(windowWidth * windowHeight)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+
+			total += (2*meanA*meanB + ssimC1) * (2*covariance + ssimC2) /
+				((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// diffImage returns a faded grayscale version of the first image,
+// highlighting in red the pixels that differ in the second one.
+func diffImage(a, b rgbImage) *image.NRGBA {
+	out := image.NewNRGBA(image.Rect(0, 0, a.Width, a.Height))
+	for i := 0; i < a.Width*a.Height; i++ {
+		diff := 0.0
+		for c := 0; c < 3; c++ {
+			diff = math.Max(diff, math.Abs(a.Pix[i*3+c]-b.Pix[i*3+c]))
+		}
+
+		background := 191 + a.luma(i)/4
+		weight := math.Min(diff*compareDiffGain/255, 1)
+		if diff > 0 {
+			// Ensure any difference is visible
+			weight = math.Max(weight, 0.25)
+		}
+
+		out.SetNRGBA(i%a.Width, i/a.Width, color.NRGBA{
+			R: uint8(background + (255-background)*weight),
+			G: uint8(background * (1 - weight)),
+			B: uint8(background * (1 - weight)),
+			A: 255,
+		})
+	}
+	return out
+}
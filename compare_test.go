@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"testing"
+)
+
+func TestCompareMetrics(t *testing.T) {
+	waves := func(x, y float64) float64 { return 0.5 + 0.25*math.Sin(9*x)*math.Cos(7*y) + 0.2*y }
+	original := newRGBImage(newTestPattern(64, 48, waves))
+
+	if mse, mae := meanErrors(original, original); mse != 0 || mae != 0 {
+		t.Errorf("Invalid errors of identical images: %f, %f", mse, mae)
+	}
+	if v := ssim(original, original); math.Abs(v-1) > epsilon {
+		t.Errorf("Invalid SSIM of identical images: %f", v)
+	}
+
+	// Uniform brightness shift
+	shifted := newRGBImage(newTestPattern(64, 48, func(x, y float64) float64 { return waves(x, y) - 0.04 }))
+	mse, mae := meanErrors(original, shifted)
+	if mae < 9 || mae > 11 || math.Abs(mse-mae*mae) > 5 {
+		t.Errorf("Invalid errors of shifted images: %f, %f", mse, mae)
+	}
+	if v := ssim(original, shifted); v < 0.9 || v >= 1 {
+		t.Errorf("Invalid SSIM of shifted images: %f", v)
+	}
+
+	// Structural changes must lower the similarity more than brightness changes
+	different := newRGBImage(newTestPattern(64, 48, func(x, y float64) float64 { return 1 - waves(x, y) }))
+	if v := ssim(original, different); v > 0 {
+		t.Errorf("Invalid SSIM of inverted images: %f", v)
+	}
+}
+
+func TestCompareTransparency(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	white := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	for x := 0; x < 2; x++ {
+		white.SetNRGBA(x, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	}
+
+	if mse, _ := meanErrors(newRGBImage(transparent), newRGBImage(white)); mse != 0 {
+		t.Errorf("Transparent pixels must be flattened over white: %f", mse)
+	}
+}
+
+func TestDiffImage(t *testing.T) {
+	a := rgbImage{Width: 2, Height: 1, Pix: []float64{0, 0, 0, 255, 255, 255}}
+	b := rgbImage{Width: 2, Height: 1, Pix: []float64{0, 0, 0, 155, 255, 255}}
+
+	diff := diffImage(a, b)
+	if c := diff.NRGBAAt(0, 0); c.R != c.G || c.G != c.B {
+		t.Errorf("Identical pixels must be gray: %v", c)
+	}
+	if c := diff.NRGBAAt(1, 0); c.R != 255 || c.G != 0 || c.B != 0 {
+		t.Errorf("Different pixels must be red: %v", c)
+	}
+}
+
+func TestCompareParams(t *testing.T) {
+	io, err := buildParamsFromQuery(url.Values{"output": []string{"diff"}})
+	if err != nil {
+		t.Fatalf("Failed reading params, %s", err)
+	}
+	if io.Output != CompareOutputDiff {
+		t.Errorf("Invalid output param: %s", io.Output)
+	}
+
+	if _, err := buildParamsFromQuery(url.Values{"output": []string{"html"}}); err == nil {
+		t.Error("Expected an error for an unsupported output")
+	}
+}
+
+func TestCompareRasterSize(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := Compare(buf, buf, ImageOptions{})
+	if err != nil {
+		t.Fatalf("Cannot compare images: %s", err)
+	}
+
+	var info CompareInfo
+	if err := json.Unmarshal(img.Body, &info); err != nil {
+		t.Fatalf("Invalid comparison JSON: %s", err)
+	}
+	if info.Width != compareRasterSize || info.Height != 576 {
+		t.Errorf("Images must be downsized before comparing them: %dx%d", info.Width, info.Height)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// composeMaxImages defines the max number of images, or pages, allowed in a composition.
+	composeMaxImages = 100
+	// composeMaxGridSize defines the max number of columns or rows of the composition layout.
+	composeMaxGridSize = 100
+	// composeDefaultCellSize defines the default cell width and height in pixels.
+	composeDefaultCellSize = 256
+)
+
+// composeCell represents the area of an image in the composition layout, in grid units.
+type composeCell struct {
+	X, Y          int
+	Width, Height int
+	Fit           string
+}
+
+// Compose lays out the given images, or their pages, in a single image
+// as a grid or following the requested layout.
+func Compose(images [][]byte, o ImageOptions, maxAllowedPixels float64) (Image, error) {
+	pages, err := composePages(images, maxAllowedPixels)
+	if err != nil {
+		return Image{}, err
+	}
+
+	cells, err := composeLayout(o, len(pages))
+	if err != nil {
+		return Image{}, err
+	}
+
+	cellWidth, cellHeight := o.Width, o.Height
+	if cellWidth == 0 && cellHeight == 0 {
+		cellWidth, cellHeight = composeDefaultCellSize, composeDefaultCellSize
+	} else if cellWidth == 0 {
+		cellWidth = cellHeight
+	} else if cellHeight == 0 {
+		cellHeight = cellWidth
+	}
+
+	columns, rows := 0, 0
+	for _, cell := range cells {
+		columns = int(math.Max(float64(columns), float64(cell.X+cell.Width)))
+		rows = int(math.Max(float64(rows), float64(cell.Y+cell.Height)))
+	}
+
+	spacing := o.Spacing
+	width := columns*cellWidth + (columns+1)*spacing
+	height := rows*cellHeight + (rows+1)*spacing
+	if float64(width)*float64(height)/1000000 > maxAllowedPixels {
+		return Image{}, ErrResolutionTooBig
+	}
+
+	background := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	if len(o.Background) > 2 {
+		background = color.NRGBA{R: o.Background[0], G: o.Background[1], B: o.Background[2], A: 255}
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	for i, cell := range cells {
+		area := image.Rect(0, 0, cell.Width*cellWidth+(cell.Width-1)*spacing, cell.Height*cellHeight+(cell.Height-1)*spacing)
+		area = area.Add(image.Pt(spacing+cell.X*(cellWidth+spacing), spacing+cell.Y*(cellHeight+spacing)))
+
+		img, err := fitImage(pages[i], area.Dx(), area.Dy(), cell.Fit)
+		if err != nil {
+			return Image{}, err
+		}
+
+		// Centre the image within its area
+		offset := image.Pt((area.Dx()-img.Bounds().Dx())/2, (area.Dy()-img.Bounds().Dy())/2)
+		draw.Draw(canvas, img.Bounds().Sub(img.Bounds().Min).Add(area.Min).Add(offset), img, img.Bounds().Min, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return Image{}, err
+	}
+
+	imageType := ImageType(o.Type)
+	if imageType == bimg.UNKNOWN {
+		imageType = composeDefaultType(pages[0])
+	}
+
+	return Process(buf.Bytes(), bimg.Options{
+		Type:        imageType,
+		Quality:     o.Quality,
+		Compression: o.Compression,
+		Interlace:   o.Interlace,
+		Speed:       o.Speed,
+	}, o)
+}
+
+// composePages expands the pages of multi-page TIFF and PDF images and the frames of animated images.
+func composePages(images [][]byte, maxAllowedPixels float64) ([][]byte, error) {
+	var pages [][]byte
+	for _, buf := range images {
+		count := countPages(buf)
+		if count == 0 {
+			return nil, NewError("Cannot read the pages of the PDF image", http.StatusBadRequest)
+		}
+		if count == 1 {
+			pages = append(pages, buf)
+			continue
+		}
+
+		for page := 0; page < count && len(pages) <= composeMaxImages; page++ {
+			o := ImageOptions{Page: page, IsDefinedField: IsDefinedField{Page: true}}
+			pageBuf, err := preparePages(buf, o, maxAllowedPixels)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, pageBuf)
+		}
+	}
+
+	if len(pages) > composeMaxImages {
+		return nil, NewError(fmt.Sprintf("Maximum allowed images exceeded: %d", composeMaxImages), http.StatusBadRequest)
+	}
+
+	return pages, nil
+}
+
+// composeLayout returns the area of every image, defined either by the layout param
+// or by a grid with the requested number of columns.
+func composeLayout(o ImageOptions, images int) ([]composeCell, error) {
+	fit := o.Fit
+	if fit == "" {
+		fit = FitCover
+	}
+	if !isValidComposeFit(fit) {
+		return nil, NewError("Invalid fit param: unsupported fit mode "+fit, http.StatusBadRequest)
+	}
+
+	if o.Layout != "" {
+		cells, err := parseLayout(o.Layout, fit)
+		if err != nil {
+			return nil, err
+		}
+		if len(cells) < images {
+			return nil, NewError(fmt.Sprintf("Invalid layout param: %d cells defined for %d images", len(cells), images), http.StatusBadRequest)
+		}
+		return cells[:images], nil
+	}
+
+	columns := o.Columns
+	if columns == 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(images))))
+	}
+	if columns > composeMaxGridSize {
+		return nil, NewError(fmt.Sprintf("Invalid columns param: must be between 1 and %d", composeMaxGridSize), http.StatusBadRequest)
+	}
+
+	cells := make([]composeCell, images)
+	for i := range cells {
+		cells[i] = composeCell{X: i % columns, Y: i / columns, Width: 1, Height: 1, Fit: fit}
+	}
+	return cells, nil
+}
+
+// parseLayout parses the layout param, being a semicolon separated list of cells
+// defined as x,y,width,height in grid units, with an optional fit mode.
+// Example: 0,0,2,2;2,0,1,1,contain;2,1,1,1
+func parseLayout(layout string, fit string) ([]composeCell, error) {
+	var cells []composeCell
+	for _, def := range strings.Split(layout, ";") {
+		parts := strings.Split(strings.TrimSpace(def), ",")
+		if len(parts) != 4 && len(parts) != 5 {
+			return nil, NewError("Invalid layout param: cells must be defined as x,y,width,height[,fit]", http.StatusBadRequest)
+		}
+
+		var values [4]int
+		for i := range values {
+			v, err := strconv.Atoi(strings.TrimSpace(parts[i]))
+			if err != nil || v < 0 || v > composeMaxGridSize {
+				return nil, NewError(fmt.Sprintf("Invalid layout param: cell values must be between 0 and %d", composeMaxGridSize), http.StatusBadRequest)
+			}
+			values[i] = v
+		}
+		if values[2] == 0 || values[3] == 0 || values[0]+values[2] > composeMaxGridSize || values[1]+values[3] > composeMaxGridSize {
+			return nil, NewError("Invalid layout param: cells must be within the grid and not empty", http.StatusBadRequest)
+		}
+
+		cell := composeCell{X: values[0], Y: values[1], Width: values[2], Height: values[3], Fit: fit}
+		if len(parts) == 5 {
+			cell.Fit = strings.TrimSpace(parts[4])
+			if !isValidComposeFit(cell.Fit) {
+				return nil, NewError("Invalid layout param: unsupported fit mode "+cell.Fit, http.StatusBadRequest)
+			}
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// fitImage resizes the image to the given area according to the fit mode.
+func fitImage(buf []byte, width, height int, fit string) (image.Image, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// libvips auto rotates the image before resizing it
+	imageWidth, imageHeight := orientedSize(meta, bimg.D0, false)
+	if imageWidth == 0 || imageHeight == 0 {
+		return nil, NewError("Width or height of requested image is zero", http.StatusNotAcceptable)
+	}
+
+	size := calculateFit(fit, imageWidth, imageHeight, width, height, false)
+	opts := bimg.Options{Type: bimg.PNG, Width: size.Width, Height: size.Height, Force: true}
+	if fit == FitCover {
+		opts = bimg.Options{Type: bimg.PNG, Width: size.OutWidth, Height: size.OutHeight, Crop: true, Enlarge: true}
+	}
+
+	out, err := bimg.Resize(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(out))
+}
+
+// composeDefaultType returns the output type used by default, being the
+// type of the first image if it can be encoded, or PNG otherwise.
+func composeDefaultType(buf []byte) bimg.ImageType {
+	switch imageType := bimg.DetermineImageType(buf); imageType {
+	case bimg.JPEG, bimg.PNG, bimg.WEBP:
+		return imageType
+	default:
+		return bimg.PNG
+	}
+}
+
+// isValidComposeFit returns true if the fit mode is supported by the composition cells,
+// which the images can't overflow.
+func isValidComposeFit(fit string) bool {
+	return fit == FitCover || fit == FitContain || fit == FitFill
+}
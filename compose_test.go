@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/h2non/imaginary/internal/vips"
+)
+
+func TestComposeLayout(t *testing.T) {
+	cells, err := composeLayout(ImageOptions{}, 5)
+	if err != nil {
+		t.Fatalf("Cannot build layout: %s", err)
+	}
+	if len(cells) != 5 {
+		t.Fatalf("Invalid number of cells: %d", len(cells))
+	}
+	if last := cells[4]; last.X != 1 || last.Y != 1 || last.Fit != FitCover {
+		t.Errorf("Invalid grid cell: %#v", last)
+	}
+
+	cells, _ = composeLayout(ImageOptions{Columns: 2, Fit: FitContain}, 3)
+	if last := cells[2]; last.X != 0 || last.Y != 1 || last.Fit != FitContain {
+		t.Errorf("Invalid grid cell: %#v", last)
+	}
+
+	cells, err = composeLayout(ImageOptions{Layout: "0,0,2,2; 2,0,1,1,fill ;2,1,1,1"}, 2)
+	if err != nil {
+		t.Fatalf("Cannot parse layout: %s", err)
+	}
+	if len(cells) != 2 {
+		t.Fatalf("Invalid number of cells: %d", len(cells))
+	}
+	if cells[0] != (composeCell{X: 0, Y: 0, Width: 2, Height: 2, Fit: FitCover}) {
+		t.Errorf("Invalid layout cell: %#v", cells[0])
+	}
+	if cells[1] != (composeCell{X: 2, Y: 0, Width: 1, Height: 1, Fit: FitFill}) {
+		t.Errorf("Invalid layout cell: %#v", cells[1])
+	}
+}
+
+func TestComposeLayoutErrors(t *testing.T) {
+	layouts := []string{
+		"0,0,1,1",
+		"0,0,1",
+		"0,0,0,1;0,1,1,1",
+		"a,0,1,1;0,1,1,1",
+		"0,0,1,1,stretch;0,1,1,1",
+		"0,0,101,1;0,1,1,1",
+	}
+
+	for _, layout := range layouts {
+		if _, err := composeLayout(ImageOptions{Layout: layout}, 2); err == nil {
+			t.Errorf("Expected an error for layout: %s", layout)
+		}
+	}
+}
+
+func TestComposePDFPages(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("pages.pdf"))
+	if bimg.DetermineImageType(buf) != bimg.PDF {
+		t.Skip("PDF images are not supported by libvips")
+	}
+
+	pages, err := composePages([][]byte{buf}, 100)
+	if _, infoErr := vips.PDFInfo(buf, 0, 1, vectorDensity); infoErr != nil {
+		// PDF pages can't be expanded without libvips
+		if err == nil {
+			t.Error("Expected an error for unreadable PDF pages")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Cannot expand PDF pages: %s", err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("Invalid number of PDF pages: %d", len(pages))
+	}
+}
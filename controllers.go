@@ -80,7 +80,8 @@ func determineAcceptMimeType(accept string) string {
 	return ""
 }
 
-func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation Operation, o ServerOptions) {
+// detectMimeType infers the image MIME type of the given buffer.
+func detectMimeType(buf []byte) string {
 	// Infer the body MIME type via mime sniff algorithm
 	mimeType := http.DetectContentType(buf)
 
@@ -99,8 +100,12 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation
 		}
 	}
 
+	return mimeType
+}
+
+func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation Operation, o ServerOptions) {
 	// Finally check if image MIME type is supported
-	if !IsImageMimeTypeSupported(mimeType) {
+	if !IsImageMimeTypeSupported(detectMimeType(buf)) {
 		ErrorReply(r, w, ErrUnsupportedMedia, o)
 		return
 	}
@@ -120,21 +125,39 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation
 		return
 	}
 
-	sizeInfo, err := bimg.Size(buf)
+	// Check the source image resolution before selecting its pages
+	if _, err := checkImageSize(buf, o); err != nil {
+		ErrorReply(r, w, err.(Error), o)
+		return
+	}
 
+	// Select the requested pages and rasterisation density, if required
+	buf, err = preparePages(buf, opts, o.MaxAllowedPixels)
+	if err == ErrResolutionTooBig {
+		ErrorReply(r, w, ErrResolutionTooBig, o)
+		return
+	}
 	if err != nil {
 		ErrorReply(r, w, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest), o)
 		return
 	}
 
-	// https://en.wikipedia.org/wiki/Image_resolution#Pixel_count
-	imgResolution := float64(sizeInfo.Width) * float64(sizeInfo.Height)
-
-	if (imgResolution / 1000000) > o.MaxAllowedPixels {
-		ErrorReply(r, w, ErrResolutionTooBig, o)
+	// The selected pages may differ in size from the first one
+	sizeInfo, err := checkImageSize(buf, o)
+	if err != nil {
+		ErrorReply(r, w, err.(Error), o)
 		return
 	}
 
+	// Apply the device pixel ratio and the client hints, if enabled
+	if hints := applyClientHints(r, &opts, sizeInfo, o); len(hints) > 0 {
+		if vary != "" {
+			hints = append([]string{vary}, hints...)
+		}
+		vary = strings.Join(hints, ", ")
+		w.Header().Set("Accept-CH", acceptClientHints)
+	}
+
 	image, err := operation.Run(buf, opts)
 	if err != nil {
 		// Ensure the Vary header is set when an error occurs
@@ -148,6 +171,9 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation
 	// Expose Content-Length response header
 	w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
 	w.Header().Set("Content-Type", image.Mime)
+	if disposition := contentDisposition(r, opts, image.Mime); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
+	}
 	if image.Mime != "application/json" && o.ReturnSize {
 		meta, err := bimg.Metadata(image.Body)
 		if err == nil {
@@ -155,12 +181,186 @@ func imageHandler(w http.ResponseWriter, r *http.Request, buf []byte, operation
 			w.Header().Set("Image-Height", strconv.Itoa(meta.Size.Height))
 		}
 	}
+	if image.Quality > 0 {
+		w.Header().Set("Image-Quality", strconv.Itoa(image.Quality))
+	}
 	if vary != "" {
 		w.Header().Set("Vary", vary)
 	}
 	_, _ = w.Write(image.Body)
 }
 
+// readImages reads every image of the request, validating their type and resolution.
+func readImages(req *http.Request, o ServerOptions) ([][]byte, error) {
+	imageSource := MatchSource(req)
+	if imageSource == nil {
+		return nil, ErrMissingImageSource
+	}
+
+	var images [][]byte
+	var err error
+	if source, ok := imageSource.(MultiImageSource); ok {
+		images, err = source.GetImages(req)
+	} else {
+		var buf []byte
+		buf, err = imageSource.GetImage(req)
+		images = [][]byte{buf}
+	}
+	if err != nil {
+		if xerr, ok := err.(Error); ok {
+			return nil, xerr
+		}
+		return nil, NewError(err.Error(), http.StatusBadRequest)
+	}
+
+	if len(images) == 0 {
+		return nil, ErrEmptyBody
+	}
+
+	for _, buf := range images {
+		if len(buf) == 0 {
+			return nil, ErrEmptyBody
+		}
+		if !IsImageMimeTypeSupported(detectMimeType(buf)) {
+			return nil, ErrUnsupportedMedia
+		}
+
+		if _, err := checkImageSize(buf, o); err != nil {
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// checkImageSize returns the size of the image, or an error if it exceeds the max allowed resolution.
+func checkImageSize(buf []byte, o ServerOptions) (bimg.ImageSize, error) {
+	size, err := bimg.Size(buf)
+	if err != nil {
+		return size, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest)
+	}
+
+	// https://en.wikipedia.org/wiki/Image_resolution#Pixel_count
+	if float64(size.Width)*float64(size.Height)/1000000 > o.MaxAllowedPixels {
+		return size, ErrResolutionTooBig
+	}
+	return size, nil
+}
+
+func composeController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		images, err := readImages(req, o)
+		if err != nil {
+			ErrorReply(req, w, err.(Error), o)
+			return
+		}
+
+		opts, err := buildParamsFromQuery(req.URL.Query())
+		if err != nil {
+			ErrorReply(req, w, NewError("Error while processing parameters, "+err.Error(), http.StatusBadRequest), o)
+			return
+		}
+
+		vary := ""
+		if opts.Type == "auto" {
+			opts.Type = determineAcceptMimeType(req.Header.Get("Accept"))
+			vary = "Accept"
+		} else if opts.Type != "" && ImageType(opts.Type) == 0 {
+			ErrorReply(req, w, ErrOutputFormat, o)
+			return
+		}
+		if vary != "" {
+			w.Header().Set("Vary", vary)
+		}
+
+		image, err := Compose(images, opts, o.MaxAllowedPixels)
+		if err != nil {
+			ErrorReply(req, w, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest), o)
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
+		w.Header().Set("Content-Type", image.Mime)
+		_, _ = w.Write(image.Body)
+	}
+}
+
+func hashCompareController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		images, err := readImages(req, o)
+		if err != nil {
+			ErrorReply(req, w, err.(Error), o)
+			return
+		}
+		if len(images) != 2 {
+			ErrorReply(req, w, NewError("Two images are required to compare their hashes", http.StatusBadRequest), o)
+			return
+		}
+
+		result, err := CompareHashes(images[0], images[1])
+		if err != nil {
+			if xerr, ok := err.(Error); ok {
+				ErrorReply(req, w, xerr, o)
+			} else {
+				ErrorReply(req, w, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest), o)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(result.Body)))
+		w.Header().Set("Content-Type", result.Mime)
+		_, _ = w.Write(result.Body)
+	}
+}
+
+func compareController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		images, err := readImages(req, o)
+		if err != nil {
+			ErrorReply(req, w, err.(Error), o)
+			return
+		}
+
+		opts, err := buildParamsFromQuery(req.URL.Query())
+		if err != nil {
+			ErrorReply(req, w, NewError("Error while processing parameters, "+err.Error(), http.StatusBadRequest), o)
+			return
+		}
+		if opts.Type != "" && ImageType(opts.Type) == 0 {
+			ErrorReply(req, w, ErrOutputFormat, o)
+			return
+		}
+
+		// Compare a single image with the output of the pipeline operations
+		if len(images) == 1 && len(opts.Operations) > 0 {
+			output, err := Pipeline(images[0], opts)
+			if err != nil {
+				ErrorReply(req, w, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest), o)
+				return
+			}
+			images = append(images, output.Body)
+		}
+		if len(images) != 2 {
+			ErrorReply(req, w, NewError("Two images, or an image and pipeline operations, are required to compare them", http.StatusBadRequest), o)
+			return
+		}
+
+		image, err := Compare(images[0], images[1], opts)
+		if err != nil {
+			if xerr, ok := err.(Error); ok {
+				ErrorReply(req, w, xerr, o)
+			} else {
+				ErrorReply(req, w, NewError("Error while processing the image: "+err.Error(), http.StatusBadRequest), o)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
+		w.Header().Set("Content-Type", image.Mime)
+		_, _ = w.Write(image.Body)
+	}
+}
+
 func formController(o ServerOptions) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		operations := []struct {
@@ -169,6 +369,8 @@ func formController(o ServerOptions) func(w http.ResponseWriter, r *http.Request
 			args   string
 		}{
 			{"Resize", "resize", "width=300&height=200&type=jpeg"},
+			{"Fit with blurred background", "fit", "width=600&height=315&extend=blur&darken=0.3"},
+			{"Resize (cover)", "resize", "width=300&height=200&fit=cover"},
 			{"Force resize", "resize", "width=300&height=200&force=true"},
 			{"Crop", "crop", "width=300&quality=95"},
 			{"SmartCrop", "crop", "width=300&height=260&quality=95&gravity=smart"},
@@ -184,7 +386,13 @@ func formController(o ServerOptions) func(w http.ResponseWriter, r *http.Request
 			{"Add watermark", "watermark", "textwidth=100&text=Hello&font=sans%2012&opacity=0.5&color=255,200,50"},
 			{"Convert format", "convert", "type=png"},
 			{"Image metadata", "info", ""},
+			{"Image color palette", "palette", "colors=5"},
+			{"Image perceptual hashes", "hash", ""},
 			{"Gaussian blur", "blur", "sigma=15.0&minampl=0.2"},
+			{"Color filter", "filter", "filter=sepia"},
+			{"Redact regions", "redact", "regions=0.25,0.25,0.5,0.5&relative=true"},
+			{"Pad", "pad", "padding=20,10%&background=255,255,255"},
+			{"Border", "border", "borderwidth=10&bordercolor=255,0,0"},
 			{"Pipeline (image reduction via multiple transformations)", "pipeline", "operations=%5B%7B%22operation%22:%20%22crop%22,%20%22params%22:%20%7B%22width%22:%20300,%20%22height%22:%20260%7D%7D,%20%7B%22operation%22:%20%22convert%22,%20%22params%22:%20%7B%22type%22:%20%22webp%22%7D%7D%5D"},
 		}
 
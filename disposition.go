@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultFilename defines the file name of the images whose source has no name.
+const defaultFilename = "image"
+
+// filenameExtensions defines the file extension of each output MIME type.
+var filenameExtensions = map[string]string{
+	"image/jpeg":       "jpg",
+	"image/png":        "png",
+	"image/webp":       "webp",
+	"image/tiff":       "tiff",
+	"image/gif":        "gif",
+	"image/svg+xml":    "svg",
+	"image/avif":       "avif",
+	"image/heif":       "heif",
+	"application/pdf":  "pdf",
+	"application/json": "json",
+}
+
+// contentDisposition returns the RFC 6266 Content-Disposition header of the response, if the download
+// or filename params are present. The file name defaults to the name of the source URL or file,
+// and its extension is always the one of the output MIME type.
+func contentDisposition(r *http.Request, o ImageOptions, mime string) string {
+	if !o.Download && o.Filename == "" {
+		return ""
+	}
+
+	name := o.Filename
+	if name == "" {
+		name = sourceFilename(r)
+	}
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" {
+		name = defaultFilename
+	}
+	if ext, ok := filenameExtensions[mime]; ok {
+		name += "." + ext
+	}
+
+	disposition := "inline"
+	if o.Download {
+		disposition = "attachment"
+	}
+
+	// Non-ASCII names are defined via the extended filename parameter, along with an ASCII fallback
+	disposition += `; filename="` + asciiFilename(name) + `"`
+	if !isASCII(name) {
+		disposition += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return disposition
+}
+
+// sourceFilename returns the base name of the image source URL or file, if any.
+func sourceFilename(r *http.Request) string {
+	query := r.URL.Query()
+	if source := query.Get(URLQueryKey); source != "" {
+		if u, err := url.Parse(source); err == nil {
+			return sanitizeFilename(u.Path)
+		}
+	}
+	if file, err := url.QueryUnescape(query.Get("file")); err == nil && file != "" {
+		return sanitizeFilename(file)
+	}
+	return ""
+}
+
+// sanitizeFilename returns the base name of the given file name, without control characters,
+// invalid UTF-8 sequences, nor leading and trailing dots and spaces.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Trim(name, ". ")
+}
+
+// asciiFilename replaces the non-ASCII characters, quotes and backslashes of a file name.
+func asciiFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 0x80 || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// encodeRFC5987 percent-encodes the UTF-8 bytes of a value, except the RFC 5987 attr-char characters.
+func encodeRFC5987(value string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
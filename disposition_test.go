@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	cases := []struct {
+		query    string
+		mime     string
+		expected string
+	}{
+		{"width=300", "image/jpeg", ""},
+		{"download=true&url=http%3A%2F%2Flocalhost%2Fphotos%2Fcat.png%3Fv%3D1", "image/webp", `attachment; filename="cat.webp"`},
+		{"filename=Report&file=photos/cat.png", "image/png", `inline; filename="Report.png"`},
+		{"download=true&file=photos%2Fcat.tiff", "image/jpeg", `attachment; filename="cat.jpg"`},
+		{"download=true&url=http%3A%2F%2Flocalhost%2F", "image/gif", `attachment; filename="image.gif"`},
+		{"download=true&filename=..%2F..%2Fetc%2Fpasswd", "image/jpeg", `attachment; filename="passwd.jpg"`},
+		{"download=true&filename=%22quoted%22", "image/jpeg", `attachment; filename="_quoted_.jpg"`},
+		{
+			"download=true&filename=caf%C3%A9%20cr%C3%A8me.jpeg", "image/avif",
+			`attachment; filename="caf_ cr_me.avif"; filename*=UTF-8''caf%C3%A9%20cr%C3%A8me.avif`,
+		},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest("GET", "/resize?"+tc.query, nil)
+		opts, err := buildParamsFromQuery(r.URL.Query())
+		if err != nil {
+			t.Fatalf("Failed reading params %s: %s", tc.query, err)
+		}
+		if disposition := contentDisposition(r, opts, tc.mime); disposition != tc.expected {
+			t.Errorf("Invalid Content-Disposition for %s: %s != %s", tc.query, disposition, tc.expected)
+		}
+	}
+}
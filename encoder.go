@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// JPEG chroma subsampling modes, as defined by libvips.
+var jpegSubsampleModes = map[string]int{"auto": 0, "on": 1, "off": 2}
+
+// PNG row filters, as defined by libvips.
+var pngFilters = map[string]int{"none": 0x08, "sub": 0x10, "up": 0x20, "avg": 0x40, "paeth": 0x80, "all": 0xf8}
+
+// encoderParam defines an advanced encoder param of an output image type.
+// Boolean params are stored as 0 or 1 and named values as their libvips value.
+type encoderParam struct {
+	Type     bimg.ImageType
+	Bool     bool
+	Values   map[string]int
+	Min, Max int
+	Default  int
+	// Native params are supported by bimg, any other requires re-encoding the image.
+	Native bool
+}
+
+// encoderParams defines the advanced encoder params, namespaced by output image type.
+var encoderParams = map[string]encoderParam{
+	"jpeg.subsample":      {Type: bimg.JPEG, Values: jpegSubsampleModes},
+	"jpeg.trellis":        {Type: bimg.JPEG, Bool: true},
+	"jpeg.overshoot":      {Type: bimg.JPEG, Bool: true},
+	"jpeg.optimizescans":  {Type: bimg.JPEG, Bool: true},
+	"jpeg.optimizecoding": {Type: bimg.JPEG, Bool: true, Default: 1},
+	"jpeg.quanttable":     {Type: bimg.JPEG, Min: 0, Max: 8},
+	"webp.lossless":       {Type: bimg.WEBP, Bool: true, Native: true},
+	"webp.nearlossless":   {Type: bimg.WEBP, Bool: true},
+	"webp.alphaquality":   {Type: bimg.WEBP, Min: 0, Max: 100, Default: 100},
+	"webp.effort":         {Type: bimg.WEBP, Min: 0, Max: 6, Default: 4},
+	"webp.smartsubsample": {Type: bimg.WEBP, Bool: true},
+	"png.effort":          {Type: bimg.PNG, Min: 1, Max: 10, Native: true},
+	"png.filter":          {Type: bimg.PNG, Values: pngFilters, Default: pngFilters["all"]},
+	"avif.lossless":       {Type: bimg.AVIF, Bool: true, Native: true},
+	"avif.speed":          {Type: bimg.AVIF, Min: 0, Max: 8, Native: true},
+}
+
+// encoderDefaults defines the server default encoder options, applied if the request doesn't define them.
+var encoderDefaults EncoderOptions
+
+// EncoderOptions stores the advanced encoder params, by namespaced param name, e.g. "jpeg.subsample".
+type EncoderOptions map[string]int
+
+// Get returns the value of the given param, or its default value if it's not defined.
+func (e EncoderOptions) Get(name string) int {
+	if value, ok := e[name]; ok {
+		return value
+	}
+	return encoderParams[name].Default
+}
+
+// Bool returns whether the given boolean param is enabled.
+func (e EncoderOptions) Bool(name string) bool {
+	return e.Get(name) != 0
+}
+
+// IsNative returns true if all the params are supported by bimg.
+func (e EncoderOptions) IsNative() bool {
+	for name := range e {
+		if !encoderParams[name].Native {
+			return false
+		}
+	}
+	return true
+}
+
+// SetEncoderDefaults defines the default encoder options based on the server options.
+func SetEncoderDefaults(o ServerOptions) {
+	encoderDefaults = o.EncoderDefaults
+}
+
+// ParseEncoderOptions parses encoder options defined as a query string,
+// e.g. "jpeg.subsample=off&png.effort=4".
+func ParseEncoderOptions(value string) (EncoderOptions, error) {
+	query, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var options ImageOptions
+	for key := range query {
+		coerce, ok := encoderParamCoercion(key)
+		if !ok {
+			return nil, fmt.Errorf("unsupported encoder option: %s", key)
+		}
+		if err := coerce(&options, query.Get(key)); err != nil {
+			return nil, fmt.Errorf("invalid encoder option %s: %s", key, err)
+		}
+	}
+	return options.Encoder, nil
+}
+
+// encoderParamCoercion returns the coercion function of the given encoder param, if it exists.
+func encoderParamCoercion(name string) (Coercion, bool) {
+	param, ok := encoderParams[name]
+	if !ok {
+		return nil, false
+	}
+
+	return func(io *ImageOptions, value interface{}) error {
+		var number int
+		var err error
+		switch {
+		case param.Bool:
+			var enabled bool
+			if enabled, err = coerceTypeBool(value); enabled {
+				number = 1
+			}
+		case param.Values != nil:
+			var label string
+			if label, err = coerceTypeString(value); err == nil {
+				var known bool
+				if number, known = param.Values[strings.ToLower(label)]; !known {
+					err = ErrUnsupportedValue
+				}
+			}
+		default:
+			number, err = coerceTypeInt(value)
+			if err == nil && (number < param.Min || number > param.Max) {
+				err = ErrUnsupportedValue
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if io.Encoder == nil {
+			io.Encoder = EncoderOptions{}
+		}
+		io.Encoder[name] = number
+		return nil
+	}, true
+}
+
+// resolveEncoderOptions returns the encoder options of the given output image type, merging the request params
+// over the server defaults. Request params of other image types are rejected.
+func resolveEncoderOptions(outputType bimg.ImageType, o ImageOptions) (EncoderOptions, error) {
+	options := EncoderOptions{}
+	for name, value := range encoderDefaults {
+		if encoderParams[name].Type == outputType {
+			options[name] = value
+		}
+	}
+
+	for name, value := range o.Encoder {
+		if encoderParams[name].Type != outputType {
+			message := fmt.Sprintf("Unsupported %s param for %s output images", name, strings.ToUpper(bimg.ImageTypeName(outputType)))
+			return nil, NewError(message, http.StatusBadRequest)
+		}
+		options[name] = value
+	}
+	return options, nil
+}
+
+// applyEncoderOptions maps the encoder options supported by bimg to the bimg options.
+func applyEncoderOptions(opts *bimg.Options, e EncoderOptions) {
+	if _, ok := e["webp.lossless"]; ok {
+		opts.Lossless = e.Bool("webp.lossless")
+	}
+	if _, ok := e["avif.lossless"]; ok {
+		opts.Lossless = e.Bool("avif.lossless")
+	}
+	if speed, ok := e["avif.speed"]; ok {
+		opts.Speed = speed
+	}
+	if effort, ok := e["png.effort"]; ok {
+		// bimg defines the PNG effort as 10 - speed
+		opts.Speed = 10 - effort
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/h2non/imaginary/internal/vips"
+)
+
+func TestEncoderParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{
+		"jpeg.subsample": {"OFF"},
+		"jpeg.trellis":   {"true"},
+		"png.filter":     {"paeth"},
+		"webp.effort":    {"6"},
+	})
+	if err != nil {
+		t.Fatalf("Failed reading encoder params: %s", err)
+	}
+	if opts.Encoder.Get("jpeg.subsample") != 2 || !opts.Encoder.Bool("jpeg.trellis") ||
+		opts.Encoder.Get("png.filter") != 0x80 || opts.Encoder.Get("webp.effort") != 6 {
+		t.Errorf("Invalid encoder options: %#v", opts.Encoder)
+	}
+	if opts.Encoder.Get("webp.alphaquality") != 100 || !opts.Encoder.Bool("jpeg.optimizecoding") {
+		t.Errorf("Invalid encoder defaults: %#v", opts.Encoder)
+	}
+
+	opts, err = buildParamsFromOperation(PipelineOperation{Params: map[string]interface{}{"webp.lossless": true}})
+	if err != nil || !opts.Encoder.Bool("webp.lossless") {
+		t.Errorf("Invalid encoder operation params: %#v, %v", opts.Encoder, err)
+	}
+
+	for key, value := range map[string]string{"jpeg.subsample": "420", "webp.effort": "7", "png.effort": "0", "jpeg.trellis": "maybe"} {
+		if _, err := buildParamsFromQuery(map[string][]string{key: {value}}); err == nil {
+			t.Errorf("Expected an error for %s=%s", key, value)
+		}
+	}
+}
+
+func TestResolveEncoderOptions(t *testing.T) {
+	defer func(defaults EncoderOptions) { encoderDefaults = defaults }(encoderDefaults)
+
+	var err error
+	encoderDefaults, err = ParseEncoderOptions("jpeg.subsample=off&jpeg.quanttable=3&png.effort=4")
+	if err != nil {
+		t.Fatalf("Cannot parse encoder defaults: %s", err)
+	}
+	if _, err := ParseEncoderOptions("jpeg.progressive=true"); err == nil {
+		t.Error("Expected an error for an unsupported encoder option")
+	}
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"jpeg.quanttable": {"5"}})
+	encoder, err := resolveEncoderOptions(bimg.JPEG, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(encoder) != 2 || encoder["jpeg.subsample"] != 2 || encoder["jpeg.quanttable"] != 5 {
+		t.Errorf("Invalid JPEG encoder options: %#v", encoder)
+	}
+	if encoder.IsNative() {
+		t.Error("Expected JPEG encoder options not supported by bimg")
+	}
+
+	encoder, err = resolveEncoderOptions(bimg.PNG, ImageOptions{})
+	if err != nil || !encoder.IsNative() {
+		t.Errorf("Invalid PNG encoder options: %#v, %v", encoder, err)
+	}
+	var bopts bimg.Options
+	applyEncoderOptions(&bopts, encoder)
+	if bopts.Speed != 6 {
+		t.Errorf("Invalid PNG encoder speed: %d", bopts.Speed)
+	}
+
+	if _, err := resolveEncoderOptions(bimg.PNG, opts); err == nil {
+		t.Error("Expected an error for JPEG encoder params with a PNG output image")
+	}
+}
+
+func TestEncoderSaveOptions(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{
+		"jpeg.subsample":      {"off"},
+		"jpeg.trellis":        {"true"},
+		"jpeg.overshoot":      {"true"},
+		"jpeg.optimizescans":  {"true"},
+		"jpeg.optimizecoding": {"false"},
+		"jpeg.quanttable":     {"3"},
+		"quality":             {"80"},
+		"interlace":           {"true"},
+		"stripmeta":           {"true"},
+	})
+	if err != nil {
+		t.Fatalf("Failed reading encoder params: %s", err)
+	}
+	encoder, _ := resolveEncoderOptions(bimg.JPEG, opts)
+	jpeg := jpegSaveOptions(encoder, BimgOptions(opts))
+	if jpeg != (vips.JPEGOptions{Strip: true, Quality: 80, Interlace: true, Subsample: 2, Trellis: true, Overshoot: true, OptimizeScans: true, QuantTable: 3}) {
+		t.Errorf("Invalid JPEG save options: %+v", jpeg)
+	}
+
+	opts, _ = buildParamsFromQuery(map[string][]string{"webp.nearlossless": {"true"}, "webp.alphaquality": {"50"}, "webp.effort": {"2"}, "webp.smartsubsample": {"true"}})
+	encoder, _ = resolveEncoderOptions(bimg.WEBP, opts)
+	webp := webpSaveOptions(encoder, BimgOptions(opts))
+	if webp != (vips.WebPOptions{Quality: bimg.Quality, NearLossless: true, AlphaQuality: 50, Effort: 2, SmartSubsample: true}) {
+		t.Errorf("Invalid WebP save options: %+v", webp)
+	}
+
+	opts, _ = buildParamsFromQuery(map[string][]string{"png.filter": {"up"}, "compression": {"9"}, "palette": {"true"}})
+	encoder, _ = resolveEncoderOptions(bimg.PNG, opts)
+	bopts := BimgOptions(opts)
+	applyEncoderOptions(&bopts, encoder)
+	png := pngSaveOptions(encoder, bopts)
+	if png.Filter != 0x20 || png.Compression != 9 || !png.Palette || png.Quality != bimg.Quality {
+		t.Errorf("Invalid PNG save options: %+v", png)
+	}
+}
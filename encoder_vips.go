@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/h2non/bimg"
+	"github.com/h2non/imaginary/internal/vips"
+)
+
+// encodeImage encodes the given lossless image to the output image type via libvips,
+// with the advanced encoder options not supported by bimg.
+func encodeImage(buf []byte, outputType bimg.ImageType, e EncoderOptions, opts bimg.Options) ([]byte, error) {
+	switch outputType {
+	case bimg.JPEG:
+		return vips.SaveJPEG(buf, jpegSaveOptions(e, opts))
+	case bimg.WEBP:
+		return vips.SaveWebP(buf, webpSaveOptions(e, opts))
+	case bimg.PNG:
+		return vips.SavePNG(buf, pngSaveOptions(e, opts))
+	}
+	return nil, fmt.Errorf("cannot encode %s images", bimg.ImageTypeName(outputType))
+}
+
+// encoderQuality returns the quality of the output image, or the bimg default quality.
+func encoderQuality(opts bimg.Options) int {
+	if opts.Quality == 0 {
+		return bimg.Quality
+	}
+	return opts.Quality
+}
+
+func jpegSaveOptions(e EncoderOptions, opts bimg.Options) vips.JPEGOptions {
+	return vips.JPEGOptions{
+		Strip:          opts.StripMetadata,
+		Quality:        encoderQuality(opts),
+		Interlace:      opts.Interlace,
+		Subsample:      e.Get("jpeg.subsample"),
+		Trellis:        e.Bool("jpeg.trellis"),
+		Overshoot:      e.Bool("jpeg.overshoot"),
+		OptimizeScans:  e.Bool("jpeg.optimizescans"),
+		OptimizeCoding: e.Bool("jpeg.optimizecoding"),
+		QuantTable:     e.Get("jpeg.quanttable"),
+	}
+}
+
+func webpSaveOptions(e EncoderOptions, opts bimg.Options) vips.WebPOptions {
+	return vips.WebPOptions{
+		Strip:          opts.StripMetadata,
+		Quality:        encoderQuality(opts),
+		Lossless:       opts.Lossless,
+		NearLossless:   e.Bool("webp.nearlossless"),
+		AlphaQuality:   e.Get("webp.alphaquality"),
+		Effort:         e.Get("webp.effort"),
+		SmartSubsample: e.Bool("webp.smartsubsample"),
+	}
+}
+
+func pngSaveOptions(e EncoderOptions, opts bimg.Options) vips.PNGOptions {
+	compression := opts.Compression
+	if compression == 0 {
+		compression = 6
+	}
+	return vips.PNGOptions{
+		Strip:       opts.StripMetadata,
+		Compression: compression,
+		Quality:     encoderQuality(opts),
+		Interlace:   opts.Interlace,
+		Palette:     opts.Palette,
+		Effort:      10 - opts.Speed,
+		Filter:      e.Get("png.filter"),
+	}
+}
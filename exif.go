@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Pointer tags to the EXIF sub-directories.
+const (
+	exifIFDPointer     = 0x8769
+	exifGPSPointer     = 0x8825
+	exifInteropPointer = 0xa005
+)
+
+// exifFieldGroups defines the metadata policy groups more specific than the EXIF group of some fields.
+var exifFieldGroups = map[uint16]string{
+	0x013b: MetadataCopyright,  // Artist
+	0x8298: MetadataCopyright,  // Copyright
+	0x9c9d: MetadataCopyright,  // XPAuthor
+	0xa430: MetadataCopyright,  // CameraOwnerName
+	0x927c: MetadataMakerNotes, // MakerNote
+	0xa431: MetadataSerials,    // BodySerialNumber
+	0xa435: MetadataSerials,    // LensSerialNumber
+	0xc62f: MetadataSerials,    // CameraSerialNumber
+}
+
+// exifFilteredTags defines the fields always removed when filtering the EXIF data,
+// as they point to data that is not relocated or duplicate the metadata of other groups.
+var exifFilteredTags = map[uint16]bool{
+	exifInteropPointer: true,
+	0x014a:             true, // SubIFDs
+	tiffXMPTag:         true,
+	tiffIPTCTag:        true,
+	tiffICCTag:         true,
+}
+
+// exifMaxValues defines the max number of values of a numeric EXIF field
+// to be returned, as longer fields are usually binary data.
+const exifMaxValues = 16
+
+// exifTagNames defines the names of the supported IFD0 and EXIF sub-directory tags.
+var exifTagNames = map[uint16]string{
+	0x0100: "ImageWidth",
+	0x0101: "ImageLength",
+	0x0102: "BitsPerSample",
+	0x0103: "Compression",
+	0x0106: "PhotometricInterpretation",
+	0x010e: "ImageDescription",
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0115: "SamplesPerPixel",
+	0x011a: "XResolution",
+	0x011b: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x013e: "WhitePoint",
+	0x013f: "PrimaryChromaticities",
+	0x0211: "YCbCrCoefficients",
+	0x0212: "YCbCrSubSampling",
+	0x0213: "YCbCrPositioning",
+	0x0214: "ReferenceBlackWhite",
+	0x4746: "Rating",
+	0x8298: "Copyright",
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8824: "SpectralSensitivity",
+	0x8827: "ISOSpeedRatings",
+	0x8830: "SensitivityType",
+	0x8832: "RecommendedExposureIndex",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9101: "ComponentsConfiguration",
+	0x9102: "CompressedBitsPerPixel",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9203: "BrightnessValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9206: "SubjectDistance",
+	0x9207: "MeteringMode",
+	0x9208: "LightSource",
+	0x9209: "Flash",
+	0x920a: "FocalLength",
+	0x9214: "SubjectArea",
+	0x9286: "UserComment",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xa000: "FlashpixVersion",
+	0xa001: "ColorSpace",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa004: "RelatedSoundFile",
+	0xa20b: "FlashEnergy",
+	0xa20e: "FocalPlaneXResolution",
+	0xa20f: "FocalPlaneYResolution",
+	0xa210: "FocalPlaneResolutionUnit",
+	0xa214: "SubjectLocation",
+	0xa215: "ExposureIndex",
+	0xa217: "SensingMethod",
+	0xa300: "FileSource",
+	0xa301: "SceneType",
+	0xa401: "CustomRendered",
+	0xa402: "ExposureMode",
+	0xa403: "WhiteBalance",
+	0xa404: "DigitalZoomRatio",
+	0xa405: "FocalLengthIn35mmFilm",
+	0xa406: "SceneCaptureType",
+	0xa407: "GainControl",
+	0xa408: "Contrast",
+	0xa409: "Saturation",
+	0xa40a: "Sharpness",
+	0xa40c: "SubjectDistanceRange",
+	0xa420: "ImageUniqueID",
+	0xa430: "CameraOwnerName",
+	0xa431: "BodySerialNumber",
+	0xa432: "LensSpecification",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+	0xa435: "LensSerialNumber",
+	0xa460: "CompositeImage",
+}
+
+// GPS sub-directory tags.
+const (
+	gpsLatitudeRef  = 0x01
+	gpsLatitude     = 0x02
+	gpsLongitudeRef = 0x03
+	gpsLongitude    = 0x04
+	gpsAltitudeRef  = 0x05
+	gpsAltitude     = 0x06
+	gpsTimeStamp    = 0x07
+	gpsSpeedRef     = 0x0c
+	gpsSpeed        = 0x0d
+	gpsImgDirection = 0x11
+	gpsDateStamp    = 0x1d
+)
+
+// GPSInfo represents the location the image was taken at, in decimal degrees.
+type GPSInfo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+	Direction *float64 `json:"direction,omitempty"`
+	Speed     *float64 `json:"speed,omitempty"`
+	Timestamp string   `json:"timestamp,omitempty"`
+}
+
+// parseEXIF parses the given TIFF structured EXIF data, returning the supported
+// IFD0 and EXIF fields by name and, if present, the GPS location.
+func parseEXIF(buf []byte) (map[string]interface{}, *GPSInfo, error) {
+	order := tiffByteOrder(buf)
+	if order == nil {
+		return nil, nil, errInvalidTIFF
+	}
+
+	ifd0, err := readTIFFDirectory(buf, order, int(order.Uint32(buf[4:8])))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := make(map[string]interface{})
+	var gps *GPSInfo
+	for _, entry := range ifd0 {
+		switch entry.Tag {
+		case exifIFDPointer:
+			offset, _ := tiffEntryInt(entry, order)
+			if entries, err := readTIFFDirectory(buf, order, offset); err == nil {
+				addEXIFFields(fields, entries, order)
+			}
+		case exifGPSPointer:
+			offset, _ := tiffEntryInt(entry, order)
+			if entries, err := readTIFFDirectory(buf, order, offset); err == nil {
+				gps = parseGPS(entries, order)
+			}
+		}
+	}
+	addEXIFFields(fields, ifd0, order)
+
+	return fields, gps, nil
+}
+
+func addEXIFFields(fields map[string]interface{}, entries []tiffEntry, order binary.ByteOrder) {
+	for _, entry := range entries {
+		name, ok := exifTagNames[entry.Tag]
+		if !ok {
+			continue
+		}
+		if value := exifValue(entry, order); value != nil {
+			fields[name] = value
+		}
+	}
+}
+
+// exifValue returns the value of the EXIF field as a string, a number or a list
+// of numbers, or nil if it's empty or binary data.
+func exifValue(e tiffEntry, order binary.ByteOrder) interface{} {
+	switch e.Type {
+	case tiffASCII:
+		if s := exifString(e.Value); s != "" {
+			return s
+		}
+		return nil
+	case tiffUndefined:
+		value := e.Value
+		if e.Tag == 0x9286 && len(value) >= 8 {
+			// UserComment is prefixed by its character code
+			if !bytes.HasPrefix(value, []byte("ASCII")) && !bytes.HasPrefix(value, []byte("\x00\x00\x00\x00\x00\x00\x00\x00")) {
+				return nil
+			}
+			value = value[8:]
+		}
+		if s := exifString(value); s != "" {
+			return s
+		}
+		if e.Count > 4 {
+			return nil
+		}
+	}
+
+	if e.Count == 0 || e.Count > exifMaxValues {
+		return nil
+	}
+
+	values := tiffEntryFloats(e, order)
+	if values == nil {
+		return nil
+	}
+
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			out[i] = int64(v)
+		} else {
+			out[i] = toFixed(v, 6)
+		}
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	return out
+}
+
+// filterEXIF returns the given TIFF structured EXIF data without the fields not kept by the policy,
+// or nil if no field is kept. The thumbnail image directory is always removed.
+func filterEXIF(buf []byte, policy MetadataPolicy) []byte {
+	order := tiffByteOrder(buf)
+	if order == nil {
+		return nil
+	}
+	ifd0, err := readTIFFDirectory(buf, order, int(order.Uint32(buf[4:8])))
+	if err != nil {
+		return nil
+	}
+
+	var fields, exif, gps []tiffEntry
+	for _, entry := range ifd0 {
+		switch entry.Tag {
+		case exifIFDPointer:
+			offset, _ := tiffEntryInt(entry, order)
+			if entries, err := readTIFFDirectory(buf, order, offset); err == nil {
+				exif = filterEXIFFields(entries, policy)
+			}
+		case exifGPSPointer:
+			if !policy.keeps(MetadataGPS, MetadataEXIF) {
+				continue
+			}
+			offset, _ := tiffEntryInt(entry, order)
+			if entries, err := readTIFFDirectory(buf, order, offset); err == nil {
+				gps = entries
+			}
+		default:
+			fields = append(fields, entry)
+		}
+	}
+	fields = filterEXIFFields(fields, policy)
+
+	if len(fields) == 0 && len(exif) == 0 && len(gps) == 0 {
+		return nil
+	}
+	return writeEXIF(order, fields, exif, gps)
+}
+
+func filterEXIFFields(entries []tiffEntry, policy MetadataPolicy) []tiffEntry {
+	kept := make([]tiffEntry, 0, len(entries))
+	for _, entry := range entries {
+		if exifFilteredTags[entry.Tag] {
+			continue
+		}
+		groups := []string{MetadataEXIF}
+		if group, ok := exifFieldGroups[entry.Tag]; ok {
+			groups = []string{group, MetadataEXIF}
+		}
+		if policy.keeps(groups...) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// writeEXIF returns the TIFF structured EXIF data with the given IFD0 fields and
+// EXIF and GPS sub-directories, omitted if empty.
+func writeEXIF(order binary.ByteOrder, ifd0, exif, gps []tiffEntry) []byte {
+	pointer := func(tag uint16, offset int) tiffEntry {
+		value := make([]byte, 4)
+		order.PutUint32(value, uint32(offset))
+		return tiffEntry{Tag: tag, Type: tiffLong, Count: 1, Value: value}
+	}
+
+	// Pointers don't change the directory size, so it's computed before knowing their values
+	entries := append([]tiffEntry{}, ifd0...)
+	if len(exif) > 0 {
+		entries = append(entries, pointer(exifIFDPointer, 0))
+	}
+	if len(gps) > 0 {
+		entries = append(entries, pointer(exifGPSPointer, 0))
+	}
+	exifOffset := 8 + tiffDirectorySize(entries)
+	gpsOffset := exifOffset
+	if len(exif) > 0 {
+		gpsOffset += tiffDirectorySize(exif)
+	}
+	for i, entry := range entries {
+		switch entry.Tag {
+		case exifIFDPointer:
+			entries[i] = pointer(exifIFDPointer, exifOffset)
+		case exifGPSPointer:
+			entries[i] = pointer(exifGPSPointer, gpsOffset)
+		}
+	}
+
+	buf := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(buf, "II*\x00")
+	} else {
+		copy(buf, "MM\x00*")
+	}
+	order.PutUint32(buf[4:], 8)
+
+	buf = appendTIFFDirectory(buf, order, entries)
+	if len(exif) > 0 {
+		buf = appendTIFFDirectory(buf, order, exif)
+	}
+	if len(gps) > 0 {
+		buf = appendTIFFDirectory(buf, order, gps)
+	}
+	return buf
+}
+
+// exifString returns the given value as a string if it's printable text, or an empty string otherwise.
+func exifString(value []byte) string {
+	s := strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+	for _, r := range s {
+		if r == unicode.ReplacementChar || (!unicode.IsPrint(r) && !unicode.IsSpace(r)) {
+			return ""
+		}
+	}
+	return s
+}
+
+// parseGPS returns the location defined by the GPS fields, or nil if it has no coordinates.
+func parseGPS(entries []tiffEntry, order binary.ByteOrder) *GPSInfo {
+	fields := make(map[uint16]tiffEntry)
+	for _, entry := range entries {
+		fields[entry.Tag] = entry
+	}
+
+	latitude, latOk := gpsCoordinate(fields[gpsLatitude], fields[gpsLatitudeRef], order, "S")
+	longitude, lonOk := gpsCoordinate(fields[gpsLongitude], fields[gpsLongitudeRef], order, "W")
+	if !latOk || !lonOk {
+		return nil
+	}
+
+	gps := &GPSInfo{Latitude: toFixed(latitude, 7), Longitude: toFixed(longitude, 7)}
+
+	if values := tiffEntryFloats(fields[gpsAltitude], order); len(values) == 1 {
+		altitude := toFixed(values[0], 2)
+		if ref, ok := tiffEntryInt(fields[gpsAltitudeRef], order); ok && ref == 1 {
+			altitude = -altitude
+		}
+		gps.Altitude = &altitude
+	}
+	if values := tiffEntryFloats(fields[gpsImgDirection], order); len(values) == 1 {
+		direction := toFixed(values[0], 2)
+		gps.Direction = &direction
+	}
+	if values := tiffEntryFloats(fields[gpsSpeed], order); len(values) == 1 {
+		// Speed is converted to km/h
+		speed := values[0]
+		switch exifString(fields[gpsSpeedRef].Value) {
+		case "M":
+			speed *= 1.609344
+		case "N":
+			speed *= 1.852
+		}
+		speed = toFixed(speed, 2)
+		gps.Speed = &speed
+	}
+
+	date := exifString(fields[gpsDateStamp].Value)
+	if t := tiffEntryFloats(fields[gpsTimeStamp], order); len(t) == 3 && len(date) == 10 {
+		gps.Timestamp = fmt.Sprintf("%s-%s-%sT%02d:%02d:%02dZ", date[0:4], date[5:7], date[8:10], int(t[0]), int(t[1]), int(t[2]))
+	}
+
+	return gps
+}
+
+// gpsCoordinate returns the decimal degrees of a degrees, minutes and seconds GPS coordinate.
+func gpsCoordinate(value, ref tiffEntry, order binary.ByteOrder, negativeRef string) (float64, bool) {
+	dms := tiffEntryFloats(value, order)
+	if len(dms) != 3 {
+		return 0, false
+	}
+
+	degrees := dms[0] + dms[1]/60 + dms[2]/3600
+	if exifString(ref.Value) == negativeRef {
+		degrees = -degrees
+	}
+	return degrees, true
+}
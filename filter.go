@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+// Colour filters supported by the filter operation.
+const (
+	FilterGrayscale = "grayscale"
+	FilterSepia     = "sepia"
+	FilterTint      = "tint"
+	FilterDuotone   = "duotone"
+	FilterInvert    = "invert"
+	FilterLUT       = "lut"
+)
+
+// sepiaMatrix defines the RGB transform matrix of the sepia filter.
+var sepiaMatrix = [3][3]float64{
+	{0.393, 0.769, 0.189},
+	{0.349, 0.686, 0.168},
+	{0.272, 0.534, 0.131},
+}
+
+// colorFilter maps an RGB colour, between 0 and 1, to the filtered colour.
+type colorFilter func(c [3]float64) [3]float64
+
+// Filter applies a colour filter to the image, preserving its alpha channel.
+func Filter(buf []byte, o ImageOptions) (Image, error) {
+	filter, err := newColorFilter(o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	intensity := o.Intensity
+	if intensity == 0 {
+		intensity = 1
+	}
+
+	// The filter is applied to every pixel of the full resolution raster
+	size, err := bimg.Size(buf)
+	if err != nil {
+		return Image{}, err
+	}
+	if err := checkRasterResolution(size.Width, size.Height); err != nil {
+		return Image{}, err
+	}
+
+	// Auto rotate the image and convert its colours to sRGB losslessly, leaving the encoding to the final pass.
+	// The profile must be kept for the colours to be transformed, and it's dropped once the raster is re-encoded.
+	srgb, _ := outputProfilePath(ProfileSRGB)
+	base, err := bimg.Resize(buf, bimg.Options{
+		Type:         bimg.PNG,
+		Compression:  1,
+		NoAutoRotate: o.NoRotation,
+		OutputICC:    srgb,
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	img, err := png.Decode(bytes.NewReader(base))
+	if err != nil {
+		return Image{}, err
+	}
+
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&out, applyColorFilter(img, filter, intensity)); err != nil {
+		return Image{}, err
+	}
+
+	imageType := ImageType(o.Type)
+	if imageType == bimg.UNKNOWN {
+		imageType = composeDefaultType(buf)
+	}
+
+	opts := bimg.Options{
+		Type:          imageType,
+		Quality:       o.Quality,
+		Compression:   o.Compression,
+		Interlace:     o.Interlace,
+		Palette:       o.Palette,
+		Speed:         o.Speed,
+		StripMetadata: o.StripMetadata,
+	}
+	if o.Filter == FilterGrayscale && intensity == 1 {
+		// Encode a single band image, along with its alpha band
+		opts.Interpretation = bimg.InterpretationBW
+		o.Colorspace = bimg.InterpretationBW
+	}
+
+	return Process(out.Bytes(), opts, o)
+}
+
+// newColorFilter returns the colour filter of the given image options.
+func newColorFilter(o ImageOptions) (colorFilter, error) {
+	switch o.Filter {
+	case "":
+		return nil, NewError("Missing required param: filter", http.StatusBadRequest)
+	case FilterGrayscale:
+		return func(c [3]float64) [3]float64 {
+			l := luminance(c)
+			return [3]float64{l, l, l}
+		}, nil
+	case FilterSepia:
+		return func(c [3]float64) (out [3]float64) {
+			for i, row := range sepiaMatrix {
+				out[i] = row[0]*c[0] + row[1]*c[1] + row[2]*c[2]
+			}
+			return out
+		}, nil
+	case FilterTint:
+		if len(o.Color) < 3 {
+			return nil, NewError("Missing required param: color", http.StatusBadRequest)
+		}
+		// Replace the chroma of the colours by the tint one, preserving their luminance
+		tint := filterColor(o.Color)
+		chroma := luminance(tint)
+		return func(c [3]float64) [3]float64 {
+			l := luminance(c)
+			return [3]float64{l + tint[0] - chroma, l + tint[1] - chroma, l + tint[2] - chroma}
+		}, nil
+	case FilterDuotone:
+		if len(o.Highlights) < 3 {
+			return nil, NewError("Missing required param: highlights", http.StatusBadRequest)
+		}
+		shadows, highlights := [3]float64{}, filterColor(o.Highlights)
+		if len(o.Shadows) >= 3 {
+			shadows = filterColor(o.Shadows)
+		}
+		return func(c [3]float64) (out [3]float64) {
+			l := luminance(c)
+			for i := range out {
+				out[i] = shadows[i] + (highlights[i]-shadows[i])*l
+			}
+			return out
+		}, nil
+	case FilterInvert:
+		return func(c [3]float64) [3]float64 {
+			return [3]float64{1 - c[0], 1 - c[1], 1 - c[2]}
+		}, nil
+	case FilterLUT:
+		if o.LUT == "" {
+			return nil, NewError("Missing required param: lut", http.StatusBadRequest)
+		}
+		lut, ok := luts[o.LUT]
+		if !ok {
+			return nil, NewError(fmt.Sprintf("Unsupported LUT: %s", o.LUT), http.StatusBadRequest)
+		}
+		return lut.Lookup, nil
+	}
+	return nil, NewError(fmt.Sprintf("Unsupported filter: %s", o.Filter), http.StatusBadRequest)
+}
+
+// applyColorFilter returns the image with the filter applied to the colour of each pixel,
+// blended with the original colour by the given intensity, between 0 and 1.
+func applyColorFilter(img image.Image, filter colorFilter, intensity float64) *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			in := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+			filtered := filter(in)
+
+			var rgb [3]uint8
+			for i := range rgb {
+				value := in[i] + (filtered[i]-in[i])*intensity
+				rgb[i] = uint8(math.Round(math.Max(0, math.Min(1, value)) * 255))
+			}
+			out.SetNRGBA(x, y, color.NRGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: c.A})
+		}
+	}
+
+	return out
+}
+
+// luminance returns the Rec. 709 luma of the RGB colour.
+func luminance(c [3]float64) float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
+// filterColor returns the RGB colour of the param, between 0 and 1.
+func filterColor(c []uint8) [3]float64 {
+	return [3]float64{float64(c[0]) / 255, float64(c[1]) / 255, float64(c[2]) / 255}
+}
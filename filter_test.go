@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// identityCube returns an identity 3D LUT in the .cube format, with an optional channel swap.
+func identityCube(size int, swap bool) string {
+	cube := "TITLE \"identity\"\n# comment\nLUT_3D_SIZE " + fmt.Sprint(size) + "\n"
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				red, green, blue := float64(r)/float64(size-1), float64(g)/float64(size-1), float64(b)/float64(size-1)
+				if swap {
+					red, blue = blue, red
+				}
+				cube += fmt.Sprintf("%.6f %.6f %.6f\n", red, green, blue)
+			}
+		}
+	}
+	return cube
+}
+
+func TestFilter(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Filter(buf, ImageOptions{Filter: FilterGrayscale})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	defer func(max float64) { rasterMaxPixels = max }(rasterMaxPixels)
+	rasterMaxPixels = 0.01
+	if _, err := Filter(buf, ImageOptions{Filter: FilterGrayscale}); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution error, got: %v", err)
+	}
+}
+
+func TestColorFilters(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 128, A: 255})
+	src.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 64})
+
+	cases := []struct {
+		options   ImageOptions
+		intensity float64
+		expected  [2]color.NRGBA
+	}{
+		{ImageOptions{Filter: FilterGrayscale}, 1, [2]color.NRGBA{{146, 146, 146, 255}, {255, 255, 255, 64}}},
+		{ImageOptions{Filter: FilterInvert}, 1, [2]color.NRGBA{{0, 127, 255, 255}, {0, 0, 0, 64}}},
+		{ImageOptions{Filter: FilterInvert}, 0.5, [2]color.NRGBA{{128, 128, 128, 255}, {128, 128, 128, 64}}},
+		{ImageOptions{Filter: FilterSepia}, 1, [2]color.NRGBA{{199, 177, 138, 255}, {255, 255, 239, 64}}},
+		{
+			ImageOptions{Filter: FilterDuotone, Shadows: []uint8{0, 0, 128}, Highlights: []uint8{255, 255, 0}}, 1,
+			[2]color.NRGBA{{146, 146, 55, 255}, {255, 255, 0, 64}},
+		},
+		{ImageOptions{Filter: FilterTint, Color: []uint8{255, 0, 0}}, 1, [2]color.NRGBA{{255, 92, 92, 255}, {255, 201, 201, 64}}},
+	}
+
+	for _, tc := range cases {
+		filter, err := newColorFilter(tc.options)
+		if err != nil {
+			t.Fatalf("Cannot create %s filter: %s", tc.options.Filter, err)
+		}
+		out := applyColorFilter(src, filter, tc.intensity)
+		for x, expected := range tc.expected {
+			if c := out.NRGBAAt(x, 0); c != expected {
+				t.Errorf("Invalid %s filter colour at %d: %v != %v", tc.options.Filter, x, c, expected)
+			}
+		}
+	}
+
+	for _, o := range []ImageOptions{
+		{},
+		{Filter: "vintage"},
+		{Filter: FilterTint},
+		{Filter: FilterDuotone, Shadows: []uint8{0, 0, 0}},
+		{Filter: FilterLUT},
+		{Filter: FilterLUT, LUT: "missing"},
+	} {
+		if _, err := newColorFilter(o); err == nil {
+			t.Errorf("Expected an error for %#v", o)
+		}
+	}
+}
+
+func TestCubeLUT(t *testing.T) {
+	lut, err := parseCubeLUT(strings.NewReader(identityCube(3, true)))
+	if err != nil {
+		t.Fatalf("Cannot parse LUT: %s", err)
+	}
+
+	out := lut.Lookup([3]float64{0.2, 0.6, 0.9})
+	for i, expected := range [3]float64{0.9, 0.6, 0.2} {
+		if out[i] < expected-1e-6 || out[i] > expected+1e-6 {
+			t.Errorf("Invalid LUT output: %v", out)
+		}
+	}
+
+	for _, cube := range []string{
+		"LUT_1D_SIZE 2\n0 0 0\n1 1 1\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"0 0 0\n",
+		"LUT_3D_SIZE 100\n",
+		"LUT_3D_SIZE 2\nDOMAIN_MIN 1 1 1\n" + strings.Repeat("0 0 0\n", 8),
+		"LUT_3D_SIZE 2\n" + strings.Repeat("0 0 zero\n", 8),
+	} {
+		if _, err := parseCubeLUT(strings.NewReader(cube)); err == nil {
+			t.Errorf("Expected an error for LUT %q", cube)
+		}
+	}
+}
+
+func TestLoadLUTs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(registry map[string]*LUT) { luts = registry }(luts)
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "Swap.cube"), []byte(identityCube(2, true)), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("not a LUT"), 0644)
+	if err := LoadLUTs(ServerOptions{LUTsDir: dir}); err != nil {
+		t.Fatalf("Cannot load LUTs: %s", err)
+	}
+	if len(luts) != 1 || luts["swap"] == nil {
+		t.Fatalf("Invalid LUTs registry: %v", luts)
+	}
+
+	opts, err := buildParamsFromQuery(map[string][]string{"filter": {"LUT"}, "lut": {"Swap"}, "intensity": {"0.5"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+	filter, err := newColorFilter(opts)
+	if err != nil {
+		t.Fatalf("Cannot create LUT filter: %s", err)
+	}
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	if c := applyColorFilter(src, filter, opts.Intensity).NRGBAAt(0, 0); c != (color.NRGBA{128, 0, 128, 255}) {
+		t.Errorf("Invalid LUT filter colour: %v", c)
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "broken.cube"), []byte("LUT_3D_SIZE 2\n"), 0644)
+	if err := LoadLUTs(ServerOptions{LUTsDir: dir}); err == nil {
+		t.Error("Expected an error for an invalid LUT")
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"intensity": {"2"}}); err == nil {
+		t.Error("Expected an error for intensity=2")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+// Supported fit modes of the images within their area, such as the CSS object-fit property.
+const (
+	FitCover   = "cover"
+	FitContain = "contain"
+	FitFill    = "fill"
+	FitInside  = "inside"
+	FitOutside = "outside"
+)
+
+// fitSize represents the size of the resized image, and of the output image once cropped or embedded.
+type fitSize struct {
+	Width, Height       int
+	OutWidth, OutHeight int
+}
+
+// resizeFit resizes the image to the requested width and height according to the fit mode:
+//   - cover: scales the image to cover the area, cropping it to the exact size.
+//   - contain: scales the image to fit within the area, embedding it in the exact size.
+//   - fill: stretches the image to the exact size, ignoring its aspect ratio.
+//   - inside: scales the image to fit within the area, without embedding it.
+//   - outside: scales the image to cover the area, without cropping it.
+func resizeFit(buf []byte, o ImageOptions) (Image, error) {
+	opts := BimgOptions(o)
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	imageWidth, imageHeight := orientedSize(meta, opts.Rotate, o.NoRotation)
+	if imageWidth == 0 || imageHeight == 0 {
+		return Image{}, NewError("Width or height of requested image is zero", http.StatusNotAcceptable)
+	}
+
+	size := calculateFit(o.Fit, imageWidth, imageHeight, opts.Width, opts.Height, o.NoEnlargement)
+	opts.Crop, opts.Embed, opts.Force, opts.Enlarge = false, false, false, true
+
+	switch o.Fit {
+	case FitCover:
+		opts.Width, opts.Height = size.OutWidth, size.OutHeight
+		opts.Crop = true
+		if err := applyFocalCrop(buf, &opts, o); err != nil {
+			return Image{}, err
+		}
+	case FitContain:
+		if o.Extend == ExtendBlur && opts.Width > 0 && opts.Height > 0 {
+			return blurFill(buf, o)
+		}
+		if size.Width < size.OutWidth && size.Height < size.OutHeight {
+			// The image isn't enlarged, which libvips doesn't support when embedding it
+			return embedRaster(buf, size, o)
+		}
+		opts.Width, opts.Height = size.OutWidth, size.OutHeight
+		opts.Embed = true
+	default:
+		opts.Width, opts.Height = size.Width, size.Height
+		opts.Force = true
+	}
+
+	return Process(buf, opts, o)
+}
+
+// embedRaster embeds the unscaled image centred in the output image size, extending its
+// edges based on the extend mode.
+func embedRaster(buf []byte, size fitSize, o ImageOptions) (Image, error) {
+	base, img, err := decodeRaster(buf, o.NoRotation)
+	if err != nil {
+		return Image{}, err
+	}
+
+	opts := BimgOptions(o)
+	outWidth, outHeight := size.OutWidth, size.OutHeight
+	if opts.Rotate == bimg.D90 || opts.Rotate == bimg.D270 {
+		// The image is rotated by the final pass, once embedded
+		outWidth, outHeight = outHeight, outWidth
+	}
+
+	dx := int(math.Max(0, float64(outWidth-img.Bounds().Dx())))
+	dy := int(math.Max(0, float64(outHeight-img.Bounds().Dy())))
+	margins := [4]int{dy / 2, dx - dx/2, dy - dy/2, dx / 2}
+
+	extended, err := extendRaster(img, margins, o.Extend, o.Background)
+	if err != nil {
+		return Image{}, err
+	}
+
+	opts.Width, opts.Height = 0, 0
+	opts.Type = rasterOutputType(buf, o)
+	return processRaster(base, extended, opts, o)
+}
+
+// calculateFit returns the size of the image resized to the given width and height according to
+// the fit mode, along with the size of the output image once cropped or embedded. If only the width
+// or the height is defined, the other one is calculated preserving the image aspect ratio.
+// If noEnlargement is true, the image is never scaled up.
+func calculateFit(fit string, imageWidth, imageHeight, width, height int, noEnlargement bool) fitSize {
+	if width == 0 {
+		width = int(math.Max(1, math.Round(float64(height)*float64(imageWidth)/float64(imageHeight))))
+	}
+	if height == 0 {
+		height = int(math.Max(1, math.Round(float64(width)*float64(imageHeight)/float64(imageWidth))))
+	}
+
+	if fit == FitFill {
+		if noEnlargement {
+			width = int(math.Min(float64(width), float64(imageWidth)))
+			height = int(math.Min(float64(height), float64(imageHeight)))
+		}
+		return fitSize{Width: width, Height: height, OutWidth: width, OutHeight: height}
+	}
+
+	scaleX, scaleY := float64(width)/float64(imageWidth), float64(height)/float64(imageHeight)
+	scale := math.Min(scaleX, scaleY)
+	if fit == FitCover || fit == FitOutside {
+		scale = math.Max(scaleX, scaleY)
+	}
+	if noEnlargement && scale > 1 {
+		scale = 1
+	}
+
+	size := fitSize{
+		Width:  int(math.Max(1, math.Round(float64(imageWidth)*scale))),
+		Height: int(math.Max(1, math.Round(float64(imageHeight)*scale))),
+	}
+	size.OutWidth, size.OutHeight = size.Width, size.Height
+
+	switch fit {
+	case FitCover:
+		size.OutWidth = int(math.Min(float64(width), float64(size.Width)))
+		size.OutHeight = int(math.Min(float64(height), float64(size.Height)))
+	case FitContain:
+		size.OutWidth, size.OutHeight = width, height
+	}
+	return size
+}
+
+// orientedSize returns the image size once rotated by libvips before resizing it,
+// either by the rotation angle or, if not defined, based on its EXIF orientation.
+func orientedSize(meta bimg.ImageMetadata, angle bimg.Angle, noRotation bool) (int, int) {
+	width, height := meta.Size.Width, meta.Size.Height
+
+	rotated := angle == bimg.D90 || angle == bimg.D270
+	if angle == bimg.D0 && !noRotation {
+		// EXIF orientations from 5 to 8 are rotated by 90 or 270 degrees
+		rotated = meta.Orientation > 4
+	}
+
+	if rotated {
+		return height, width
+	}
+	return width, height
+}
+
+func isValidFit(fit string) bool {
+	return fit == FitCover || fit == FitContain || fit == FitFill || fit == FitInside || fit == FitOutside
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestResizeFit(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	for fit, expected := range map[string][2]int{FitCover: {300, 200}, FitContain: {300, 200}, FitFill: {300, 200}} {
+		opts, _ := buildParamsFromQuery(map[string][]string{"width": {"300"}, "height": {"200"}, "fit": {fit}})
+		img, err := Resize(buf, opts)
+		if err != nil {
+			t.Fatalf("Cannot process image with fit=%s: %s", fit, err)
+		}
+		if img.Mime != "image/jpeg" {
+			t.Error("Invalid image MIME type")
+		}
+
+		size, _ := bimg.Size(img.Body)
+		if size.Width != expected[0] || size.Height != expected[1] {
+			t.Errorf("Invalid image size with fit=%s: %dx%d", fit, size.Width, size.Height)
+		}
+	}
+}
+
+func TestCalculateFit(t *testing.T) {
+	cases := []struct {
+		fit           string
+		imageWidth    int
+		imageHeight   int
+		width         int
+		height        int
+		noEnlargement bool
+		expected      fitSize
+	}{
+		// Landscape image
+		{FitCover, 400, 100, 200, 100, false, fitSize{400, 100, 200, 100}},
+		{FitContain, 400, 100, 200, 100, false, fitSize{200, 50, 200, 100}},
+		{FitFill, 400, 100, 200, 100, false, fitSize{200, 100, 200, 100}},
+		{FitInside, 400, 100, 200, 100, false, fitSize{200, 50, 200, 50}},
+		{FitOutside, 400, 100, 200, 100, false, fitSize{400, 100, 400, 100}},
+		// Portrait image
+		{FitCover, 100, 400, 200, 100, false, fitSize{200, 800, 200, 100}},
+		{FitContain, 100, 400, 200, 100, false, fitSize{25, 100, 200, 100}},
+		{FitFill, 100, 400, 200, 100, false, fitSize{200, 100, 200, 100}},
+		{FitInside, 100, 400, 200, 100, false, fitSize{25, 100, 25, 100}},
+		{FitOutside, 100, 400, 200, 100, false, fitSize{200, 800, 200, 800}},
+		// Square image
+		{FitCover, 200, 200, 200, 100, false, fitSize{200, 200, 200, 100}},
+		{FitContain, 200, 200, 200, 100, false, fitSize{100, 100, 200, 100}},
+		{FitFill, 200, 200, 200, 100, false, fitSize{200, 100, 200, 100}},
+		{FitInside, 200, 200, 200, 100, false, fitSize{100, 100, 100, 100}},
+		{FitOutside, 200, 200, 200, 100, false, fitSize{200, 200, 200, 200}},
+		// Small image, enlarged
+		{FitCover, 50, 40, 200, 100, false, fitSize{200, 160, 200, 100}},
+		{FitContain, 50, 40, 200, 100, false, fitSize{125, 100, 200, 100}},
+		{FitFill, 50, 40, 200, 100, false, fitSize{200, 100, 200, 100}},
+		{FitInside, 50, 40, 200, 100, false, fitSize{125, 100, 125, 100}},
+		{FitOutside, 50, 40, 200, 100, false, fitSize{200, 160, 200, 160}},
+		// Small image, without enlargement
+		{FitCover, 50, 40, 200, 100, true, fitSize{50, 40, 50, 40}},
+		{FitContain, 50, 40, 200, 100, true, fitSize{50, 40, 200, 100}},
+		{FitFill, 50, 40, 200, 100, true, fitSize{50, 40, 50, 40}},
+		{FitInside, 50, 40, 200, 100, true, fitSize{50, 40, 50, 40}},
+		{FitOutside, 50, 40, 200, 100, true, fitSize{50, 40, 50, 40}},
+		// Image smaller than the area in one dimension, without enlargement
+		{FitCover, 100, 400, 200, 100, true, fitSize{100, 400, 100, 100}},
+		{FitContain, 100, 400, 200, 100, true, fitSize{25, 100, 200, 100}},
+		{FitFill, 100, 400, 200, 100, true, fitSize{100, 100, 100, 100}},
+		{FitInside, 100, 400, 200, 100, true, fitSize{25, 100, 25, 100}},
+		{FitOutside, 100, 400, 200, 100, true, fitSize{100, 400, 100, 400}},
+		// Width or height only, preserving the aspect ratio
+		{FitCover, 400, 100, 200, 0, false, fitSize{200, 50, 200, 50}},
+		{FitContain, 400, 100, 200, 0, false, fitSize{200, 50, 200, 50}},
+		{FitFill, 100, 400, 0, 100, false, fitSize{25, 100, 25, 100}},
+		{FitOutside, 100, 400, 0, 100, false, fitSize{25, 100, 25, 100}},
+		{FitInside, 100, 400, 0, 800, true, fitSize{100, 400, 100, 400}},
+	}
+
+	for _, tc := range cases {
+		size := calculateFit(tc.fit, tc.imageWidth, tc.imageHeight, tc.width, tc.height, tc.noEnlargement)
+		if size != tc.expected {
+			t.Errorf("Invalid %s fit of %dx%d in %dx%d (noEnlargement=%t): %+v != %+v",
+				tc.fit, tc.imageWidth, tc.imageHeight, tc.width, tc.height, tc.noEnlargement, size, tc.expected)
+		}
+	}
+}
+
+func TestCalculateFitOrientation(t *testing.T) {
+	landscape := map[string]fitSize{
+		FitCover:   {400, 100, 200, 100},
+		FitContain: {200, 50, 200, 100},
+		FitFill:    {200, 100, 200, 100},
+		FitInside:  {200, 50, 200, 50},
+		FitOutside: {400, 100, 400, 100},
+	}
+	portrait := map[string]fitSize{
+		FitCover:   {200, 800, 200, 100},
+		FitContain: {25, 100, 200, 100},
+		FitFill:    {200, 100, 200, 100},
+		FitInside:  {25, 100, 25, 100},
+		FitOutside: {200, 800, 200, 800},
+	}
+
+	// EXIF orientations from 5 to 8 turn the landscape image into a portrait one
+	for orientation := 0; orientation <= 8; orientation++ {
+		meta := bimg.ImageMetadata{Size: bimg.ImageSize{Width: 400, Height: 100}, Orientation: orientation}
+		expected := landscape
+		if orientation > 4 {
+			expected = portrait
+		}
+
+		for fit, size := range expected {
+			width, height := orientedSize(meta, bimg.D0, false)
+			if out := calculateFit(fit, width, height, 200, 100, false); out != size {
+				t.Errorf("Invalid %s fit with orientation %d: %+v != %+v", fit, orientation, out, size)
+			}
+
+			// Without auto rotation, the image is always resized as stored
+			width, height = orientedSize(meta, bimg.D0, true)
+			if out := calculateFit(fit, width, height, 200, 100, false); out != landscape[fit] {
+				t.Errorf("Invalid %s fit with orientation %d and norotation: %+v != %+v", fit, orientation, out, landscape[fit])
+			}
+		}
+	}
+}
+
+func TestOrientedSize(t *testing.T) {
+	cases := []struct {
+		orientation int
+		angle       bimg.Angle
+		noRotation  bool
+		expected    [2]int
+	}{
+		{1, bimg.D0, false, [2]int{400, 100}},
+		{3, bimg.D0, false, [2]int{400, 100}},
+		{6, bimg.D0, false, [2]int{100, 400}},
+		{8, bimg.D0, false, [2]int{100, 400}},
+		{6, bimg.D0, true, [2]int{400, 100}},
+		// The rotation angle takes precedence over the EXIF orientation
+		{1, bimg.D90, false, [2]int{100, 400}},
+		{6, bimg.D90, false, [2]int{100, 400}},
+		{6, bimg.D180, false, [2]int{400, 100}},
+		{1, bimg.D270, true, [2]int{100, 400}},
+	}
+
+	for _, tc := range cases {
+		meta := bimg.ImageMetadata{Size: bimg.ImageSize{Width: 400, Height: 100}, Orientation: tc.orientation}
+		width, height := orientedSize(meta, tc.angle, tc.noRotation)
+		if width != tc.expected[0] || height != tc.expected[1] {
+			t.Errorf("Invalid oriented size with orientation %d and angle %d: %dx%d", tc.orientation, tc.angle, width, height)
+		}
+	}
+}
+
+func TestFitParams(t *testing.T) {
+	for _, fit := range []string{FitCover, FitContain, FitFill, FitInside, FitOutside} {
+		opts, err := buildParamsFromQuery(map[string][]string{"fit": {fit}, "withoutenlargement": {"true"}})
+		if err != nil {
+			t.Fatalf("Failed reading params: %s", err)
+		}
+		if opts.Fit != fit || !opts.NoEnlargement {
+			t.Errorf("Invalid fit params: %s, %t", opts.Fit, opts.NoEnlargement)
+		}
+	}
+	if _, err := buildParamsFromQuery(map[string][]string{"fit": {"scale-down"}}); err == nil {
+		t.Error("Expected an error for an unsupported fit mode")
+	}
+
+	// Compositions don't support the fit modes overflowing their cells
+	for _, fit := range []string{FitInside, FitOutside} {
+		if _, err := composeLayout(ImageOptions{Fit: fit}, 2); err == nil {
+			t.Errorf("Expected an error for compositions with fit=%s", fit)
+		}
+		if _, err := parseLayout(fmt.Sprintf("0,0,1,1,%s", fit), FitCover); err == nil {
+			t.Errorf("Expected an error for layout cells with fit=%s", fit)
+		}
+	}
+}
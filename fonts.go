@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fontExtensions defines the font file types loaded from the fonts directory.
+var fontExtensions = map[string]bool{
+	".ttf":  true,
+	".ttc":  true,
+	".otf":  true,
+	".pfb":  true,
+	".woff": true,
+}
+
+// fontconfigTemplate defines the fontconfig configuration used to register custom fonts.
+// System fonts are still available through the included default configuration.
+const fontconfigTemplate = `<?xml version="1.0"?>
+<!DOCTYPE fontconfig SYSTEM "fonts.dtd">
+<fontconfig>
+  <include ignore_missing="yes">%s</include>
+  <dir>%s</dir>
+  <cachedir prefix="xdg">fontconfig</cachedir>
+</fontconfig>
+`
+
+// fontconfigFile stores the path of the temporary fontconfig configuration, removed once the server exits.
+var fontconfigFile string
+
+// loadFontsDirectory registers the font files of the given directory in fontconfig,
+// so they can be used by the text watermark via the font param.
+// It must be called before any text is rendered by libvips.
+func loadFontsDirectory(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fonts []string
+	for _, file := range files {
+		if !file.IsDir() && fontExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+			fonts = append(fonts, file.Name())
+		}
+	}
+	if len(fonts) == 0 {
+		return nil, fmt.Errorf("no font files found in directory: %s", dir)
+	}
+
+	// Preserve any user defined fontconfig configuration
+	include := os.Getenv("FONTCONFIG_FILE")
+	if include == "" {
+		include = "/etc/fonts/fonts.conf"
+	}
+
+	config, err := ioutil.TempFile("", "imaginary-fonts-*.conf")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(config, fontconfigTemplate, html.EscapeString(include), html.EscapeString(dir))
+	if closeErr := config.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Setenv("FONTCONFIG_FILE", config.Name())
+	}
+	if err != nil {
+		_ = os.Remove(config.Name())
+		return nil, err
+	}
+
+	removeFontconfigFile()
+	fontconfigFile = config.Name()
+	return fonts, nil
+}
+
+// removeFontconfigFile removes the temporary fontconfig configuration written by loadFontsDirectory, if any.
+func removeFontconfigFile() {
+	if fontconfigFile != "" {
+		_ = os.Remove(fontconfigFile)
+		fontconfigFile = ""
+	}
+}
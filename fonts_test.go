@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFontsDirectory(t *testing.T) {
+	if value, ok := os.LookupEnv("FONTCONFIG_FILE"); ok {
+		defer os.Setenv("FONTCONFIG_FILE", value)
+	} else {
+		defer os.Unsetenv("FONTCONFIG_FILE")
+	}
+
+	dir, err := ioutil.TempDir("", "imaginary-fonts")
+	if err != nil {
+		t.Fatalf("Cannot create fonts directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := loadFontsDirectory(dir); err == nil {
+		t.Error("Expected an error for a directory without fonts")
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "custom.ttf"), []byte("font"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("text"), 0644)
+	fonts, err := loadFontsDirectory(dir)
+	if err != nil || len(fonts) != 1 || fonts[0] != "custom.ttf" {
+		t.Fatalf("Invalid fonts: %v, %v", fonts, err)
+	}
+
+	config := os.Getenv("FONTCONFIG_FILE")
+	if config != fontconfigFile {
+		t.Fatalf("Invalid fontconfig file: %s", config)
+	}
+	if _, err := os.Stat(config); err != nil {
+		t.Fatalf("Missing fontconfig file: %s", err)
+	}
+
+	removeFontconfigFile()
+	if _, err := os.Stat(config); !os.IsNotExist(err) {
+		t.Errorf("The fontconfig file must be removed: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+)
+
+// GIF block introducers.
+// See: https://www.w3.org/Graphics/GIF/spec-gif89a.txt
+const (
+	gifExtensionIntroducer = 0x21
+	gifImageSeparator      = 0x2C
+	gifTrailer             = 0x3B
+)
+
+var errInvalidGIF = errors.New("invalid GIF image")
+
+// gifFrameEnds scans the blocks of a GIF image, without decoding them, and returns
+// the offset where the data of every frame ends.
+func gifFrameEnds(buf []byte) ([]int, error) {
+	if len(buf) < 13 || string(buf[0:3]) != "GIF" {
+		return nil, errInvalidGIF
+	}
+
+	var ends []int
+	offset := 13 + gifColorTableSize(buf[10])
+	for offset < len(buf) {
+		introducer := buf[offset]
+		switch introducer {
+		case gifExtensionIntroducer:
+			// Skip the extension label
+			offset += 2
+		case gifImageSeparator:
+			if offset+10 > len(buf) {
+				return nil, errInvalidGIF
+			}
+			// Skip the image descriptor, the local color table and the LZW minimum code size
+			offset += 10 + gifColorTableSize(buf[offset+9]) + 1
+		case gifTrailer:
+			return ends, nil
+		default:
+			return nil, errInvalidGIF
+		}
+
+		// Skip the data sub-blocks, ended by an empty one
+		for {
+			if offset >= len(buf) {
+				return nil, errInvalidGIF
+			}
+			size := int(buf[offset])
+			offset += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+
+		if introducer == gifImageSeparator {
+			ends = append(ends, offset)
+		}
+	}
+
+	return nil, errInvalidGIF
+}
+
+// gifColorTableSize returns the size in bytes of the color table defined by the given packed fields.
+func gifColorTableSize(fields byte) int {
+	if fields&0x80 == 0 {
+		return 0
+	}
+	return 3 << (uint(fields&0x07) + 1)
+}
+
+// truncateGIF returns the GIF image truncated to the frames ending at the given offset.
+func truncateGIF(buf []byte, end int) []byte {
+	out := make([]byte, end+1)
+	copy(out, buf[:end])
+	out[end] = gifTrailer
+	return out
+}
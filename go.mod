@@ -6,7 +6,8 @@ require (
 	github.com/garyburd/redigo v1.6.0 // indirect
 	github.com/h2non/bimg v1.1.7
 	github.com/h2non/filetype v1.1.0
-	github.com/hashicorp/golang-lru v0.0.0-20160813221303-0a025b7e63ad // indirect
+	github.com/hashicorp/golang-lru v0.5.4 // indirect
 	github.com/rs/cors v0.0.0-20170727213201-7af7a1e09ba3
+	github.com/throttled/throttled/v2 v2.15.0
 	gopkg.in/throttled/throttled.v2 v2.0.3
 )
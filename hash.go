@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+)
+
+const (
+	// hashSampleSize defines the max width/height of the downsampled image used to compute hashes.
+	hashSampleSize = 256
+	// hashSize defines the width and height of the hash bits grid, resulting in 64 bits hashes.
+	hashSize = 8
+	// hashDCTSize defines the width and height of the image the pHash DCT is computed from.
+	hashDCTSize = 32
+)
+
+// HashInfo represents the perceptual hashes of an image, in hex notation.
+type HashInfo struct {
+	AHash string `json:"ahash"`
+	DHash string `json:"dhash"`
+	PHash string `json:"phash"`
+}
+
+// HashDistance represents the Hamming distance between the perceptual hashes of two images.
+type HashDistance struct {
+	AHash int `json:"ahash"`
+	DHash int `json:"dhash"`
+	PHash int `json:"phash"`
+}
+
+// HashComparison represents the perceptual hashes of two images and their distance.
+type HashComparison struct {
+	Hashes   []HashInfo   `json:"hashes"`
+	Distance HashDistance `json:"distance"`
+}
+
+// imageHashes stores the perceptual hashes of an image.
+type imageHashes struct {
+	AHash, DHash, PHash uint64
+}
+
+func Hash(buf []byte, o ImageOptions) (Image, error) {
+	// We're not handling an image here, but we reused the struct.
+	image := Image{Mime: "application/json"}
+
+	hashes, err := hashImage(buf)
+	if err != nil {
+		return image, err
+	}
+
+	body, _ := json.Marshal(hashes.info())
+	image.Body = body
+
+	return image, nil
+}
+
+// CompareHashes returns the perceptual hashes of two images and their Hamming distance.
+func CompareHashes(a, b []byte) (Image, error) {
+	image := Image{Mime: "application/json"}
+
+	first, err := hashImage(a)
+	if err != nil {
+		return image, err
+	}
+	second, err := hashImage(b)
+	if err != nil {
+		return image, err
+	}
+
+	comparison := HashComparison{
+		Hashes: []HashInfo{first.info(), second.info()},
+		Distance: HashDistance{
+			AHash: bits.OnesCount64(first.AHash ^ second.AHash),
+			DHash: bits.OnesCount64(first.DHash ^ second.DHash),
+			PHash: bits.OnesCount64(first.PHash ^ second.PHash),
+		},
+	}
+
+	body, _ := json.Marshal(comparison)
+	image.Body = body
+
+	return image, nil
+}
+
+// hashImage computes the perceptual hashes of the image, once auto rotated.
+func hashImage(buf []byte) (imageHashes, error) {
+	img, err := rasterize(buf, hashSampleSize)
+	if err != nil {
+		return imageHashes{}, NewError("Cannot compute image hash: "+err.Error(), http.StatusBadRequest)
+	}
+	return computeHashes(img), nil
+}
+
+func computeHashes(img image.Image) imageHashes {
+	return imageHashes{
+		AHash: averageHash(img),
+		DHash: differenceHash(img),
+		PHash: perceptualHash(img),
+	}
+}
+
+func (h imageHashes) info() HashInfo {
+	return HashInfo{
+		AHash: fmt.Sprintf("%016x", h.AHash),
+		DHash: fmt.Sprintf("%016x", h.DHash),
+		PHash: fmt.Sprintf("%016x", h.PHash),
+	}
+}
+
+// averageHash sets a bit for every pixel of the 8x8 grayscale image brighter than the mean.
+func averageHash(img image.Image) uint64 {
+	pixels := grayscale(img, hashSize, hashSize)
+
+	mean := 0.0
+	for _, v := range pixels {
+		mean += v
+	}
+	mean /= float64(len(pixels))
+
+	return hashBits(pixels, func(i int) bool { return pixels[i] > mean })
+}
+
+// differenceHash sets a bit for every pixel of the 9x8 grayscale image brighter than its left neighbour.
+func differenceHash(img image.Image) uint64 {
+	pixels := grayscale(img, hashSize+1, hashSize)
+
+	diffs := make([]float64, hashSize*hashSize)
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			row := y * (hashSize + 1)
+			diffs[y*hashSize+x] = pixels[row+x+1] - pixels[row+x]
+		}
+	}
+
+	return hashBits(diffs, func(i int) bool { return diffs[i] > 0 })
+}
+
+// perceptualHash sets a bit for every one of the 8x8 lowest frequencies of the
+// 32x32 grayscale image DCT greater than their median.
+func perceptualHash(img image.Image) uint64 {
+	pixels := grayscale(img, hashDCTSize, hashDCTSize)
+
+	// Separable 2D DCT-II, rows first
+	rows := make([]float64, hashDCTSize*hashSize)
+	for y := 0; y < hashDCTSize; y++ {
+		for u := 0; u < hashSize; u++ {
+			rows[y*hashSize+u] = dct(u, func(x int) float64 { return pixels[y*hashDCTSize+x] })
+		}
+	}
+
+	freqs := make([]float64, hashSize*hashSize)
+	for v := 0; v < hashSize; v++ {
+		for u := 0; u < hashSize; u++ {
+			freqs[v*hashSize+u] = dct(v, func(y int) float64 { return rows[y*hashSize+u] })
+		}
+	}
+
+	sorted := append([]float64(nil), freqs...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	return hashBits(freqs, func(i int) bool { return freqs[i] > median })
+}
+
+// dct returns the k frequency of the unnormalised DCT-II of hashDCTSize values.
+func dct(k int, value func(int) float64) float64 {
+	sum := 0.0
+	for n := 0; n < hashDCTSize; n++ {
+		sum += value(n) * math.Cos(math.Pi/hashDCTSize*(float64(n)+0.5)*float64(k))
+	}
+	return sum
+}
+
+// hashBits builds a hash setting the bits, most significant first, that match the given condition.
+func hashBits(values []float64, set func(int) bool) uint64 {
+	var hash uint64
+	for i := range values {
+		hash <<= 1
+		if set(i) {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// grayscale downsamples the image to the given size by averaging the pixels luma,
+// flattening transparent pixels over a white background.
+func grayscale(img image.Image, width, height int) []float64 {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	pixels := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := int(math.Max(float64((y+1)*srcHeight/height), float64(y0+1)))
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := int(math.Max(float64((x+1)*srcWidth/width), float64(x0+1)))
+
+			sum := 0.0
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					white := float64(0xffff - a)
+					sum += 0.299*(float64(r)+white) + 0.587*(float64(g)+white) + 0.114*(float64(b)+white)
+				}
+			}
+			pixels[y*width+x] = sum / float64((y1-y0)*(x1-x0)) / 0x101
+		}
+	}
+	return pixels
+}
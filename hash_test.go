@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"testing"
+)
+
+// newTestPattern returns a grayscale image whose brightness, between 0 and 1,
+// is defined by the given function of the relative pixel coordinates.
+func newTestPattern(width, height int, brightness func(x, y float64) float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(255 * brightness(float64(x)/float64(width), float64(y)/float64(height)))
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestHash(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Hash(buf, ImageOptions{})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "application/json" {
+		t.Error("Invalid response MIME type")
+	}
+
+	var info HashInfo
+	if err := json.Unmarshal(img.Body, &info); err != nil {
+		t.Fatalf("Invalid JSON response: %s", err)
+	}
+	if len(info.AHash) != 16 || len(info.DHash) != 16 || len(info.PHash) != 16 {
+		t.Errorf("Invalid hashes: %#v", info)
+	}
+}
+
+func TestComputeHashes(t *testing.T) {
+	gradient := func(x, y float64) float64 { return x }
+	waves := func(x, y float64) float64 { return 0.5 + 0.25*math.Sin(9*x)*math.Cos(7*y) + 0.2*y }
+
+	horizontal := computeHashes(newTestPattern(100, 80, gradient))
+	if horizontal.AHash != 0x0f0f0f0f0f0f0f0f {
+		t.Errorf("Invalid average hash: %016x", horizontal.AHash)
+	}
+	if horizontal.DHash != 0xffffffffffffffff {
+		t.Errorf("Invalid difference hash: %016x", horizontal.DHash)
+	}
+
+	original := computeHashes(newTestPattern(120, 90, waves))
+
+	// Hashes must be resilient to scaling and transparency over white
+	scaled := newTestPattern(400, 300, waves)
+	for x := 0; x < 10; x++ {
+		scaled.SetNRGBA(x, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 0})
+	}
+	similar := computeHashes(scaled)
+	if d := bits.OnesCount64(original.PHash ^ similar.PHash); d > 4 {
+		t.Errorf("Invalid perceptual hash distance between scaled images: %d", d)
+	}
+	if d := bits.OnesCount64(original.DHash ^ similar.DHash); d > 4 {
+		t.Errorf("Invalid difference hash distance between scaled images: %d", d)
+	}
+
+	if d := bits.OnesCount64(original.PHash ^ horizontal.PHash); d < 16 {
+		t.Errorf("Invalid perceptual hash distance between different images: %d", d)
+	}
+}
+
+func TestHashInfo(t *testing.T) {
+	info := imageHashes{AHash: 0xf, DHash: 0xffffffffffffffff}.info()
+	if info.AHash != "000000000000000f" || info.DHash != "ffffffffffffffff" || info.PHash != "0000000000000000" {
+		t.Errorf("Invalid hex hashes: %#v", info)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// iccHeaderSize defines the size of the ICC profile header, followed by the tag table.
+const iccHeaderSize = 128
+
+var errInvalidICC = errors.New("invalid ICC profile")
+
+// iccClasses defines the names of the ICC profile device classes.
+var iccClasses = map[string]string{
+	"scnr": "input",
+	"mntr": "display",
+	"prtr": "output",
+	"link": "link",
+	"spac": "colorspace",
+	"abst": "abstract",
+	"nmcl": "namedcolor",
+}
+
+// ICCInfo represents the details of an ICC colour profile.
+type ICCInfo struct {
+	Description string `json:"description,omitempty"`
+	Copyright   string `json:"copyright,omitempty"`
+	ColorSpace  string `json:"colorSpace"`
+	Class       string `json:"class"`
+	Version     string `json:"version"`
+}
+
+// parseICC parses the header and the description and copyright tags of an ICC profile.
+func parseICC(buf []byte) (*ICCInfo, error) {
+	if len(buf) < iccHeaderSize+4 || string(buf[36:40]) != "acsp" {
+		return nil, errInvalidICC
+	}
+
+	info := &ICCInfo{
+		ColorSpace: strings.TrimSpace(string(buf[16:20])),
+		Class:      iccClasses[string(buf[12:16])],
+		Version:    fmt.Sprintf("%d.%d", buf[8], buf[9]>>4),
+	}
+
+	count := int(binary.BigEndian.Uint32(buf[iccHeaderSize:]))
+	for i := 0; i < count && iccHeaderSize+4+(i+1)*12 <= len(buf); i++ {
+		entry := buf[iccHeaderSize+4+i*12:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(buf) {
+			continue
+		}
+
+		switch string(entry[0:4]) {
+		case "desc":
+			info.Description = iccText(buf[offset : offset+size])
+		case "cprt":
+			info.Copyright = iccText(buf[offset : offset+size])
+		}
+	}
+
+	return info, nil
+}
+
+// iccText returns the text of an ICC text, text description or multi-localized Unicode tag,
+// using the first localization of the latter.
+func iccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+
+	switch string(tag[0:4]) {
+	case "text":
+		return strings.TrimRight(string(tag[8:]), "\x00 ")
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:]))
+		if length < 0 || 12+length > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+length]), "\x00 ")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if length < 0 || offset < 0 || offset+length > len(tag) {
+			return ""
+		}
+
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00 ")
+	}
+	return ""
+}
@@ -4,8 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"math"
 	"net/http"
 	"strings"
@@ -32,12 +30,18 @@ var OperationsMap = map[string]Operation{
 	"blur":           GaussianBlur,
 	"smartcrop":      SmartCrop,
 	"fit":            Fit,
+	"filter":         Filter,
+	"redact":         Redact,
+	"pad":            Pad,
+	"border":         Border,
 }
 
 // Image stores an image binary buffer and its MIME type
 type Image struct {
 	Body []byte
 	Mime string
+	// Quality stores the encoder quality chosen for the image, if any
+	Quality int
 }
 
 // Operation implements an image transformation runnable interface
@@ -50,14 +54,27 @@ func (o Operation) Run(buf []byte, opts ImageOptions) (Image, error) {
 
 // ImageInfo represents an image details and additional metadata
 type ImageInfo struct {
-	Width       int    `json:"width"`
-	Height      int    `json:"height"`
-	Type        string `json:"type"`
-	Space       string `json:"space"`
-	Alpha       bool   `json:"hasAlpha"`
-	Profile     bool   `json:"hasProfile"`
-	Channels    int    `json:"channels"`
-	Orientation int    `json:"orientation"`
+	Width       int                    `json:"width"`
+	Height      int                    `json:"height"`
+	Type        string                 `json:"type"`
+	Space       string                 `json:"space"`
+	Alpha       bool                   `json:"hasAlpha"`
+	Profile     bool                   `json:"hasProfile"`
+	Channels    int                    `json:"channels"`
+	Orientation int                    `json:"orientation"`
+	Pages       int                    `json:"pages,omitempty"`
+	Frames      int                    `json:"frames,omitempty"`
+	Animated    bool                   `json:"animated"`
+	Progressive bool                   `json:"progressive"`
+	FileSize    int                    `json:"fileSize"`
+	BitDepth    int                    `json:"bitDepth,omitempty"`
+	DPI         *ImageDPI              `json:"dpi,omitempty"`
+	EXIF        map[string]interface{} `json:"exif,omitempty"`
+	GPS         *GPSInfo               `json:"gps,omitempty"`
+	IPTC        map[string]interface{} `json:"iptc,omitempty"`
+	XMP         map[string]interface{} `json:"xmp,omitempty"`
+	ICC         *ICCInfo               `json:"icc,omitempty"`
+	Hash        *HashInfo              `json:"hash,omitempty"`
 }
 
 func Info(buf []byte, o ImageOptions) (Image, error) {
@@ -70,6 +87,8 @@ func Info(buf []byte, o ImageOptions) (Image, error) {
 		return image, NewError("Cannot retrieve image metadata: %s"+err.Error(), http.StatusBadRequest)
 	}
 
+	metadata := readMetadata(buf)
+
 	info := ImageInfo{
 		Width:       meta.Size.Width,
 		Height:      meta.Size.Height,
@@ -79,6 +98,26 @@ func Info(buf []byte, o ImageOptions) (Image, error) {
 		Profile:     meta.Profile,
 		Channels:    meta.Channels,
 		Orientation: meta.Orientation,
+		Pages:       countPages(buf),
+		Animated:    metadata.Frames > 1,
+		Progressive: metadata.Progressive,
+		FileSize:    len(buf),
+		BitDepth:    metadata.BitDepth,
+		DPI:         metadata.DPI,
+	}
+	if info.Animated {
+		info.Frames = metadata.Frames
+	}
+
+	addMetadataGroups(&info, metadata, o.Groups)
+
+	if o.Hash {
+		hashes, err := hashImage(buf)
+		if err != nil {
+			return image, err
+		}
+		hash := hashes.info()
+		info.Hash = &hash
 	}
 
 	body, _ := json.Marshal(info)
@@ -92,6 +131,10 @@ func Resize(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required param: height or width", http.StatusBadRequest)
 	}
 
+	if o.Fit != "" {
+		return resizeFit(buf, o)
+	}
+
 	opts := BimgOptions(o)
 	opts.Embed = true
 
@@ -99,7 +142,15 @@ func Resize(buf []byte, o ImageOptions) (Image, error) {
 		opts.Crop = !o.NoCrop
 	}
 
-	return Process(buf, opts)
+	if o.Extend == ExtendBlur && opts.Width > 0 && opts.Height > 0 && !opts.Crop && !opts.Force {
+		return blurFill(buf, o)
+	}
+
+	if err := applyFocalCrop(buf, &opts, o); err != nil {
+		return Image{}, err
+	}
+
+	return Process(buf, opts, o)
 }
 
 func Fit(buf []byte, o ImageOptions) (Image, error) {
@@ -107,6 +158,10 @@ func Fit(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required params: height, width", http.StatusBadRequest)
 	}
 
+	if o.Extend == ExtendBlur {
+		return blurFill(buf, o)
+	}
+
 	metadata, err := bimg.Metadata(buf)
 	if err != nil {
 		return Image{}, err
@@ -149,7 +204,7 @@ func Fit(buf []byte, o ImageOptions) (Image, error) {
 	opts := BimgOptions(o)
 	opts.Embed = true
 
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 // calculateDestinationFitDimension calculates the fit area based on the image and desired fit dimensions
@@ -165,6 +220,104 @@ func calculateDestinationFitDimension(imageWidth, imageHeight, fitWidth, fitHeig
 	return fitWidth, fitHeight
 }
 
+// focalPoint returns the relative focal point coordinates defined by the fx/fy
+// params or by a compass gravity not natively supported by bimg.
+func focalPoint(o ImageOptions) (fx, fy float64, ok bool) {
+	if o.IsDefinedField.FocalX || o.IsDefinedField.FocalY {
+		fx, fy = 0.5, 0.5
+		if o.IsDefinedField.FocalX {
+			fx = o.FocalX
+		}
+		if o.IsDefinedField.FocalY {
+			fy = o.FocalY
+		}
+		return fx, fy, true
+	}
+
+	switch o.Gravity {
+	case GravityNorthEast:
+		return 1, 0, true
+	case GravitySouthEast:
+		return 1, 1, true
+	case GravitySouthWest:
+		return 0, 1, true
+	case GravityNorthWest:
+		return 0, 0, true
+	}
+
+	return 0, 0, false
+}
+
+// applyFocalCrop replaces the bimg crop by an explicit resize and area extraction
+// that keeps the focal point as close to the centre as the crop allows.
+func applyFocalCrop(buf []byte, opts *bimg.Options, o ImageOptions) error {
+	fx, fy, ok := focalPoint(o)
+	if !ok || !opts.Crop || opts.Gravity == bimg.GravitySmart {
+		return nil
+	}
+
+	metadata, err := bimg.Metadata(buf)
+	if err != nil {
+		return err
+	}
+
+	imageWidth, imageHeight := metadata.Size.Width, metadata.Size.Height
+	if !o.NoRotation && metadata.Orientation > 4 {
+		// width/height will be switched with auto rotation
+		imageWidth, imageHeight = imageHeight, imageWidth
+	}
+
+	if imageWidth == 0 || imageHeight == 0 {
+		return NewError("Width or height of requested image is zero", http.StatusNotAcceptable)
+	}
+
+	width, height := opts.Width, opts.Height
+	if width == 0 {
+		width = imageWidth
+	}
+	if height == 0 {
+		height = imageHeight
+	}
+
+	// Nothing to crop, the image is already within the requested area
+	if !opts.Enlarge && imageWidth <= width && imageHeight <= height {
+		return nil
+	}
+
+	resizeWidth, resizeHeight, left, top := calculateFocalCrop(imageWidth, imageHeight, width, height, fx, fy)
+
+	opts.Width = resizeWidth
+	opts.Height = resizeHeight
+	opts.Force = true
+	opts.Crop = false
+	opts.Embed = false
+	opts.Gravity = bimg.GravityCentre
+	opts.Top = top
+	opts.Left = left
+	opts.AreaWidth = width
+	opts.AreaHeight = height
+
+	return nil
+}
+
+// calculateFocalCrop calculates the dimensions the image must be resized to in order to cover
+// the crop area, and the crop area offsets keeping the focal point as centred as possible.
+func calculateFocalCrop(imageWidth, imageHeight, width, height int, fx, fy float64) (resizeWidth, resizeHeight, left, top int) {
+	factor := math.Max(float64(width)/float64(imageWidth), float64(height)/float64(imageHeight))
+
+	resizeWidth = int(math.Max(math.Round(float64(imageWidth)*factor), float64(width)))
+	resizeHeight = int(math.Max(math.Round(float64(imageHeight)*factor), float64(height)))
+
+	left = clampOffset(fx*float64(resizeWidth)-float64(width)/2, resizeWidth-width)
+	top = clampOffset(fy*float64(resizeHeight)-float64(height)/2, resizeHeight-height)
+
+	return resizeWidth, resizeHeight, left, top
+}
+
+func clampOffset(offset float64, max int) int {
+	return int(math.Min(math.Max(math.Round(offset), 0), float64(max)))
+}
+
 func Enlarge(buf []byte, o ImageOptions) (Image, error) {
 	if o.Width == 0 || o.Height == 0 {
 		return Image{}, NewError("Missing required params: height, width", http.StatusBadRequest)
@@ -176,7 +329,11 @@ func Enlarge(buf []byte, o ImageOptions) (Image, error) {
 	// Since both width & height is required, we allow cropping by default.
 	opts.Crop = !o.NoCrop
 
-	return Process(buf, opts)
+	if err := applyFocalCrop(buf, &opts, o); err != nil {
+		return Image{}, err
+	}
+
+	return Process(buf, opts, o)
 }
 
 func Extract(buf []byte, o ImageOptions) (Image, error) {
@@ -190,7 +347,7 @@ func Extract(buf []byte, o ImageOptions) (Image, error) {
 	opts.AreaWidth = o.AreaWidth
 	opts.AreaHeight = o.AreaHeight
 
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Crop(buf []byte, o ImageOptions) (Image, error) {
@@ -200,7 +357,12 @@ func Crop(buf []byte, o ImageOptions) (Image, error) {
 
 	opts := BimgOptions(o)
 	opts.Crop = true
-	return Process(buf, opts)
+
+	if err := applyFocalCrop(buf, &opts, o); err != nil {
+		return Image{}, err
+	}
+
+	return Process(buf, opts, o)
 }
 
 func SmartCrop(buf []byte, o ImageOptions) (Image, error) {
@@ -211,7 +373,7 @@ func SmartCrop(buf []byte, o ImageOptions) (Image, error) {
 	opts := BimgOptions(o)
 	opts.Crop = true
 	opts.Gravity = bimg.GravitySmart
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Rotate(buf []byte, o ImageOptions) (Image, error) {
@@ -219,45 +381,39 @@ func Rotate(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required param: rotate", http.StatusBadRequest)
 	}
 
+	if angle := normalizeAngle(o.Rotate); !isRightAngle(angle) {
+		return rotateImage(buf, angle, o)
+	}
+
 	opts := BimgOptions(o)
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
-func AutoRotate(buf []byte, o ImageOptions) (out Image, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			switch value := r.(type) {
-			case error:
-				err = value
-			case string:
-				err = errors.New(value)
-			default:
-				err = errors.New("libvips internal error")
-			}
-			out = Image{}
-		}
-	}()
-
-	// Resize image via bimg
-	ibuf, err := bimg.NewImage(buf).AutoRotate()
-	if err != nil {
-		return Image{}, err
-	}
-
-	mime := GetImageMimeType(bimg.DetermineImageType(ibuf))
-	return Image{Body: ibuf, Mime: mime}, nil
+func AutoRotate(buf []byte, o ImageOptions) (Image, error) {
+	// bimg auto rotates the image if no further transformation is defined
+	opts := bimg.Options{
+		Type:          ImageType(o.Type),
+		Quality:       o.Quality,
+		Compression:   o.Compression,
+		Interlace:     o.Interlace,
+		Palette:       o.Palette,
+		Speed:         o.Speed,
+		NoProfile:     o.NoProfile,
+		StripMetadata: o.StripMetadata,
+	}
+	return Process(buf, opts, o)
 }
 
 func Flip(buf []byte, o ImageOptions) (Image, error) {
 	opts := BimgOptions(o)
 	opts.Flip = true
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Flop(buf []byte, o ImageOptions) (Image, error) {
 	opts := BimgOptions(o)
 	opts.Flop = true
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Thumbnail(buf []byte, o ImageOptions) (Image, error) {
@@ -265,7 +421,7 @@ func Thumbnail(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required params: width or height", http.StatusBadRequest)
 	}
 
-	return Process(buf, BimgOptions(o))
+	return Process(buf, BimgOptions(o), o)
 }
 
 func Zoom(buf []byte, o ImageOptions) (Image, error) {
@@ -291,7 +447,7 @@ func Zoom(buf []byte, o ImageOptions) (Image, error) {
 	}
 
 	opts.Zoom = o.Factor
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Convert(buf []byte, o ImageOptions) (Image, error) {
@@ -303,7 +459,7 @@ func Convert(buf []byte, o ImageOptions) (Image, error) {
 	}
 	opts := BimgOptions(o)
 
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Watermark(buf []byte, o ImageOptions) (Image, error) {
@@ -311,7 +467,15 @@ func Watermark(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required param: text", http.StatusBadRequest)
 	}
 
+	if err := validateTextWatermark(o); err != nil {
+		return Image{}, err
+	}
+
 	opts := BimgOptions(o)
+	if shouldLayoutTextWatermark(o) {
+		return watermarkWithTextLayout(buf, opts, o)
+	}
+
 	opts.Watermark.DPI = o.DPI
 	opts.Watermark.Text = o.Text
 	opts.Watermark.Font = o.Font
@@ -324,41 +488,30 @@ func Watermark(buf []byte, o ImageOptions) (Image, error) {
 		opts.Watermark.Background = bimg.Color{R: o.Color[0], G: o.Color[1], B: o.Color[2]}
 	}
 
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func WatermarkImage(buf []byte, o ImageOptions) (Image, error) {
-	if o.Image == "" {
+	if o.Image == "" && o.Watermark == "" {
 		return Image{}, NewError("Missing required param: image", http.StatusBadRequest)
 	}
-	response, err := http.Get(o.Image)
+
+	imageBuf, err := watermarks.Load(o)
 	if err != nil {
-		return Image{}, NewError(fmt.Sprintf("Unable to retrieve watermark image. %s", o.Image), http.StatusBadRequest)
+		return Image{}, err
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
-
-	bodyReader := io.LimitReader(response.Body, 1e6)
-
-	imageBuf, err := ioutil.ReadAll(bodyReader)
-	if len(imageBuf) == 0 {
-		errMessage := "Unable to read watermark image"
 
-		if err != nil {
-			errMessage = fmt.Sprintf("%s. %s", errMessage, err.Error())
-		}
-
-		return Image{}, NewError(errMessage, http.StatusBadRequest)
+	opts := BimgOptions(o)
+	if o.IsDefinedField.Gravity || o.Scale > 0 || o.Replicate {
+		return watermarkWithImageLayout(buf, imageBuf, opts, o)
 	}
 
-	opts := BimgOptions(o)
 	opts.WatermarkImage.Left = o.Left
 	opts.WatermarkImage.Top = o.Top
 	opts.WatermarkImage.Buf = imageBuf
 	opts.WatermarkImage.Opacity = o.Opacity
 
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func GaussianBlur(buf []byte, o ImageOptions) (Image, error) {
@@ -366,7 +519,7 @@ func GaussianBlur(buf []byte, o ImageOptions) (Image, error) {
 		return Image{}, NewError("Missing required param: sigma or minampl", http.StatusBadRequest)
 	}
 	opts := BimgOptions(o)
-	return Process(buf, opts)
+	return Process(buf, opts, o)
 }
 
 func Pipeline(buf []byte, o ImageOptions) (Image, error) {
@@ -418,7 +571,8 @@ func Pipeline(buf []byte, o ImageOptions) (Image, error) {
 	return image, err
 }
 
-func Process(buf []byte, opts bimg.Options) (out Image, err error) {
+// Process applies the given bimg options to the image, along with the metadata policy of the image options.
+func Process(buf []byte, opts bimg.Options, o ImageOptions) (out Image, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			switch value := r.(type) {
@@ -433,6 +587,84 @@ func Process(buf []byte, opts bimg.Options) (out Image, err error) {
 		}
 	}()
 
+	// bimg only rotates by right angles, arbitrary angles are supported by the rotate operation only
+	if !isRightAngle(normalizeAngle(o.Rotate)) {
+		return Image{}, NewError("Unsupported rotate angle: must be a multiple of 90 degrees, except for the rotate operation", http.StatusBadRequest)
+	}
+
+	if o.AutoQuality {
+		quality, err := autoQuality(buf, opts, o)
+		if err != nil {
+			return Image{}, err
+		}
+		opts.Quality = quality
+	}
+
+	if o.MaxBytes > 0 {
+		return processMaxBytes(buf, opts, o)
+	}
+
+	out, err = process(buf, opts, o)
+	if err == nil && o.AutoQuality {
+		out.Quality = opts.Quality
+	}
+	return out, err
+}
+
+func process(buf []byte, opts bimg.Options, o ImageOptions) (Image, error) {
+	outputType := opts.Type
+	if outputType == bimg.UNKNOWN {
+		outputType = bimg.DetermineImageType(buf)
+	}
+
+	// Apply the encoder options of the output type
+	encoder, err := resolveEncoderOptions(outputType, o)
+	if err != nil {
+		return Image{}, err
+	}
+	applyEncoderOptions(&opts, encoder)
+
+	// Preserve the frames of animated images, if required
+	if anim, ok, err := processAnimation(buf, opts, encoder); ok {
+		return anim, err
+	}
+
+	// Convert the image colours to the output profile
+	if meta, err := bimg.Metadata(buf); err == nil {
+		if err := applyColorProfile(&opts, meta, o); err != nil {
+			return Image{}, err
+		}
+	}
+
+	// Metadata not stripped entirely by the policy is filtered once encoded, if the output type supports it
+	policy := resolveMetadataPolicy(o)
+	if o.NoProfile && opts.OutputICC != "" {
+		// The output profile is omitted once the colours are transformed
+		policy.Strip = append(append([]string{}, policy.Strip...), MetadataICC)
+	}
+	filter := false
+	if policy.IsDefined() {
+		filter = !policy.StripsAll() && canFilterMetadata(outputType)
+		opts.StripMetadata = !filter
+	}
+
+	// Images quantised by imaginary are encoded as true colour PNG images by bimg
+	var q Quantization
+	quantize := shouldQuantize(outputType, o)
+	if quantize {
+		if q, err = quantization(o); err != nil {
+			return Image{}, err
+		}
+		opts.Type = bimg.PNG
+		opts.Palette = false
+	}
+
+	// Encoder options not supported by bimg require encoding a lossless TIFF image instead
+	encode := !quantize && !encoder.IsNative()
+	if encode {
+		opts.Type = bimg.TIFF
+	}
+
 	// Resize image via bimg
 	ibuf, err := bimg.Resize(buf, opts)
 
@@ -447,6 +679,24 @@ func Process(buf []byte, opts bimg.Options) (out Image, err error) {
 		return Image{}, err
 	}
 
+	if encode {
+		if ibuf, err = encodeImage(ibuf, outputType, encoder, opts); err != nil {
+			return Image{}, err
+		}
+	}
+
+	if quantize {
+		if ibuf, err = quantizeImage(ibuf, outputType, q, opts.Compression); err != nil {
+			return Image{}, err
+		}
+	}
+
+	if filter {
+		if ibuf, err = filterMetadata(ibuf, policy); err != nil {
+			return Image{}, err
+		}
+	}
+
 	mime := GetImageMimeType(bimg.DetermineImageType(ibuf))
 	return Image{Body: ibuf, Mime: mime}, nil
 }
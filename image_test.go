@@ -93,6 +93,41 @@ func TestImageFit(t *testing.T) {
 	}
 }
 
+func TestImageCropFocalPoint(t *testing.T) {
+	opts := ImageOptions{
+		Width:          300,
+		Height:         100,
+		FocalX:         0.3,
+		FocalY:         0.2,
+		IsDefinedField: IsDefinedField{FocalX: true, FocalY: true},
+	}
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Crop(buf, opts)
+	if err != nil {
+		t.Errorf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+	if err := assertSize(img.Body, opts.Width, opts.Height); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImageCropCompassGravity(t *testing.T) {
+	opts := ImageOptions{Width: 200, Height: 200, Gravity: GravitySouthWest}
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Crop(buf, opts)
+	if err != nil {
+		t.Errorf("Cannot process image: %s", err)
+	}
+	if err := assertSize(img.Body, opts.Width, opts.Height); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestImageAutoRotate(t *testing.T) {
 	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
 	img, err := AutoRotate(buf, ImageOptions{})
@@ -178,3 +213,35 @@ func TestCalculateDestinationFitDimension(t *testing.T) {
 	}
 
 }
+
+func TestCalculateFocalCrop(t *testing.T) {
+	cases := []struct {
+		imageWidth, imageHeight int
+		width, height           int
+		fx, fy                  float64
+
+		resizeWidth, resizeHeight int
+		left, top                 int
+	}{
+		// Centred focal point
+		{1000, 500, 200, 200, 0.5, 0.5, 400, 200, 100, 0},
+		// Focal point close to the left edge is clamped
+		{1000, 500, 200, 200, 0.1, 0.5, 400, 200, 0, 0},
+		// Focal point close to the right edge is clamped
+		{1000, 500, 200, 200, 1, 0.5, 400, 200, 200, 0},
+		// Focal point at 30%/20%
+		{1000, 1000, 500, 200, 0.3, 0.2, 500, 500, 0, 0},
+		{1000, 1000, 200, 500, 0.3, 0.6, 500, 500, 50, 0},
+		{550, 740, 300, 100, 0.3, 0.2, 300, 404, 0, 31},
+	}
+
+	for _, tc := range cases {
+		resizeWidth, resizeHeight, left, top := calculateFocalCrop(tc.imageWidth, tc.imageHeight, tc.width, tc.height, tc.fx, tc.fy)
+		if resizeWidth != tc.resizeWidth || resizeHeight != tc.resizeHeight || left != tc.left || top != tc.top {
+			t.Errorf(
+				"Focal crop calculation failure\nExpected : %dx%d +%d+%d\nActual   : %dx%d +%d+%d\n%+v",
+				tc.resizeWidth, tc.resizeHeight, tc.left, tc.top, resizeWidth, resizeHeight, left, top, tc,
+			)
+		}
+	}
+}
@@ -52,6 +52,17 @@ var (
 	aCpus               = flag.Int("cpus", runtime.GOMAXPROCS(-1), "Number of cpu cores to use")
 	aLogLevel           = flag.String("log-level", "info", "Define log level for http-server. E.g: info,warning,error")
 	aReturnSize         = flag.Bool("return-size", false, "Return the image size in the HTTP headers")
+	aFontsDir           = flag.String("fonts-dir", "", "Directory of custom font files to be used by the text watermark")
+	aWatermarksDir      = flag.String("watermarks-dir", "", "Directory of watermark images to be preloaded and used by name")
+	aWatermarkCacheTTL  = flag.Int("watermark-cache-ttl", 300, "TTL in seconds of the remote watermark images cache. Use 0 to disable it")
+	aMaxAnimFrames      = flag.Int("max-animation-frames", 300, "Restrict maximum number of frames of animated images")
+	aMaxAnimPixels      = flag.Float64("max-animation-resolution", 18.0, "Restrict maximum total resolution of all the frames of animated images (in megapixels)")
+	aClientHints        = flag.Bool("enable-client-hints", false, "Enable the DPR, width, viewport width and save data client hints")
+	aMaxDPR             = flag.Float64("max-dpr", 3.0, "Restrict maximum device pixel ratio of the dpr param and the client hints")
+	aProfilesDir        = flag.String("profiles-dir", "", "Directory of ICC profiles to be used by name as output profile")
+	aLUTsDir            = flag.String("luts-dir", "", "Directory of 3D LUT files to be used by name by the lut filter")
+	aMetadataPolicy     = flag.String("metadata-policy", "", "Default metadata groups kept or stripped from the output images. E.g: keep=icc,copyright or strip=gps,makernotes")
+	aEncoderDefaults    = flag.String("encoder-defaults", "", "Default encoder options of each output image type. E.g: jpeg.subsample=off&png.effort=4")
 )
 
 const usage = `imaginary %s
@@ -70,6 +81,13 @@ Usage:
   imaginary -enable-url-source -placeholder ./placeholder.jpg
   imaginary -enable-url-signature -url-signature-key 4f46feebafc4b5e988f131c4ff8b5997
   imaginary -enable-url-source -forward-headers X-Custom,X-Token
+  imaginary -fonts-dir ./fonts
+  imaginary -watermarks-dir ./watermarks
+  imaginary -metadata-policy strip=gps,makernotes,serials
+  imaginary -profiles-dir ./profiles
+  imaginary -luts-dir ./luts
+  imaginary -enable-client-hints -max-dpr 2
+  imaginary -encoder-defaults "jpeg.subsample=off&jpeg.trellis=true&webp.effort=6"
   imaginary -h | -help
   imaginary -v | -version
 
@@ -97,6 +115,8 @@ Options:
   -allowed-origins <urls>    Restrict remote image source processing to certain origins (separated by commas)
   -max-allowed-size <bytes>  Restrict maximum size of http image source (in bytes)
   -max-allowed-resolution <megapixels> Restrict maximum resolution of the image [default: 18.0]
+  -max-animation-frames <num> Restrict maximum number of frames of animated images [default: 300]
+  -max-animation-resolution <megapixels> Restrict maximum total resolution of all the frames of animated images [default: 18.0]
   -certfile <path>           TLS certificate file path
   -keyfile <path>            TLS private key file path
   -authorization <value>     Defines a constant Authorization header value passed to all the image source servers. -enable-url-source flag must be defined. This overwrites authorization headers forwarding behavior via X-Forward-Authorization
@@ -110,6 +130,17 @@ Options:
   -log-level                 Set log level for http-server. E.g: info,warning,error [default: info].
                              Or can use the environment variable GOLANG_LOG=info.
   -return-size               Return the image size with X-Width and X-Height HTTP header. [default: disabled].
+  -fonts-dir <path>          Directory of custom font files (TTF, OTF...) to be used by the text watermark via the font param
+  -watermarks-dir <path>     Directory of watermark images to be preloaded and used by file name via the watermark param
+  -watermark-cache-ttl <num> TTL in seconds of the remote watermark images cache [default: 300]. Use 0 to disable it
+  -metadata-policy <policy>  Default metadata groups kept or stripped from the output images, overridden by the keep,
+                             strip and stripmeta params. E.g: keep=icc,copyright or strip=gps,makernotes [default: keep all]
+  -profiles-dir <path>       Directory of ICC profiles (ICC, ICM) to be used by file name via the outputprofile param
+  -luts-dir <path>           Directory of 3D LUTs (CUBE) to be used by file name via the lut param of the lut filter
+  -enable-client-hints       Enable the Sec-CH-DPR, Sec-CH-Width, Viewport-Width and Save-Data client hints [default: false]
+  -max-dpr <num>             Restrict maximum device pixel ratio of the dpr param and the client hints [default: 3.0]
+  -encoder-defaults <opts>   Default encoder options of each output image type, overridden by the encoder params.
+                             E.g: jpeg.subsample=off&png.effort=4 [default: libvips defaults]
 `
 
 type URLSignature struct {
@@ -163,6 +194,14 @@ func main() {
 		MaxAllowedPixels:   *aMaxAllowedPixels,
 		LogLevel:           getLogLevel(*aLogLevel),
 		ReturnSize:         *aReturnSize,
+		WatermarksDir:      *aWatermarksDir,
+		WatermarkCacheTTL:  *aWatermarkCacheTTL,
+		MaxAnimationFrames: *aMaxAnimFrames,
+		MaxAnimationPixels: *aMaxAnimPixels,
+		ProfilesDir:        *aProfilesDir,
+		LUTsDir:            *aLUTsDir,
+		EnableClientHints:  *aClientHints,
+		MaxDPR:             *aMaxDPR,
 	}
 
 	// Show warning if gzip flag is passed
@@ -180,6 +219,15 @@ func main() {
 		checkMountDirectory(*aMount)
 	}
 
+	// Register custom fonts directory, if present
+	if *aFontsDir != "" {
+		fonts, err := loadFontsDirectory(*aFontsDir)
+		if err != nil {
+			exitWithError("cannot load fonts directory: %s", err)
+		}
+		debug("Loaded %d custom fonts from %s", len(fonts), *aFontsDir)
+	}
+
 	// Validate HTTP cache param, if present
 	if *aHTTPCacheTTL != -1 {
 		checkHTTPCacheTTL(*aHTTPCacheTTL)
@@ -190,6 +238,24 @@ func main() {
 		opts.Endpoints = parseEndpoints(*aDisableEndpoints)
 	}
 
+	// Parse the default metadata policy, if present
+	if *aMetadataPolicy != "" {
+		policy, err := ParseMetadataPolicy(*aMetadataPolicy)
+		if err != nil {
+			exitWithError("invalid metadata policy: %s", err)
+		}
+		opts.MetadataPolicy = policy
+	}
+
+	// Parse the default encoder options, if present
+	if *aEncoderDefaults != "" {
+		defaults, err := ParseEncoderOptions(*aEncoderDefaults)
+		if err != nil {
+			exitWithError("invalid encoder defaults: %s", err)
+		}
+		opts.EncoderDefaults = defaults
+	}
+
 	// Read placeholder image, if required
 	if *aPlaceholder != "" {
 		buf, err := ioutil.ReadFile(*aPlaceholder)
@@ -224,8 +290,38 @@ func main() {
 	// Load image source providers
 	LoadSources(opts)
 
+	// Define animated images limits
+	SetAnimationLimits(opts)
+
+	// Define the rasterised images limits
+	SetRasterLimits(opts)
+
+	// Define the default metadata policy
+	SetMetadataPolicy(opts)
+
+	// Define the default encoder options
+	SetEncoderDefaults(opts)
+
+	// Load output ICC profiles
+	if err := LoadProfiles(opts); err != nil {
+		exitWithError("cannot load profiles directory: %s", err)
+	}
+
+	// Load colour filter LUTs
+	if err := LoadLUTs(opts); err != nil {
+		exitWithError("cannot load LUTs directory: %s", err)
+	}
+
+	// Load watermark image sources
+	if err := LoadWatermarks(opts); err != nil {
+		exitWithError("cannot load watermarks directory: %s", err)
+	}
+
 	// Start the server
 	Server(opts)
+
+	// Remove the temporary files once the server is stopped
+	removeFontconfigFile()
 }
 
 func getPort(port int) int {
@@ -348,6 +444,7 @@ func memoryRelease(interval int) {
 
 func exitWithError(format string, args ...interface{}) {
 	_, _ = fmt.Fprintf(os.Stderr, format+"\n", args)
+	removeFontconfigFile()
 	os.Exit(1)
 }
 
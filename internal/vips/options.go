@@ -0,0 +1,50 @@
+// Package vips implements the libvips encoder and loader options not supported by bimg.
+package vips
+
+import "errors"
+
+// ErrNotSupported is returned if imaginary is built without cgo, and so without libvips.
+var ErrNotSupported = errors.New("libvips is not available")
+
+// JPEGOptions defines the JPEG encoder options.
+type JPEGOptions struct {
+	Strip          bool
+	Quality        int
+	Interlace      bool
+	Subsample      int
+	Trellis        bool
+	Overshoot      bool
+	OptimizeScans  bool
+	OptimizeCoding bool
+	QuantTable     int
+}
+
+// WebPOptions defines the WebP encoder options.
+type WebPOptions struct {
+	Strip          bool
+	Quality        int
+	Lossless       bool
+	NearLossless   bool
+	AlphaQuality   int
+	Effort         int
+	SmartSubsample bool
+}
+
+// PNGOptions defines the PNG encoder options.
+type PNGOptions struct {
+	Strip       bool
+	Compression int
+	Quality     int
+	Interlace   bool
+	Palette     bool
+	Effort      int
+	Filter      int
+}
+
+// PDFDocument defines the size of the loaded pages of a PDF document, stacked vertically,
+// and its total number of pages.
+type PDFDocument struct {
+	Width  int
+	Height int
+	Pages  int
+}
@@ -0,0 +1,89 @@
+//go:build cgo
+// +build cgo
+
+package vips
+
+/*
+#include <stdlib.h>
+#include <vips/vips.h>
+
+#define IMAGINARY_VIPS_AT_LEAST(major, minor) \
+	(VIPS_MAJOR_VERSION > major || (VIPS_MAJOR_VERSION == major && VIPS_MINOR_VERSION >= minor))
+
+static int
+imaginary_pdfload(void *buf, size_t len, VipsImage **out, int page, int n, double dpi) {
+#if IMAGINARY_VIPS_AT_LEAST(8, 5)
+	return vips_pdfload_buffer(buf, len, out,
+		"page", page,
+		"n", n,
+		"dpi", dpi,
+		"access", VIPS_ACCESS_SEQUENTIAL,
+		NULL
+	);
+#else
+	vips_error("imaginary", "PDF page selection requires libvips 8.5+");
+	return -1;
+#endif
+}
+
+static int
+imaginary_pdf_pages(VipsImage *image) {
+	int pages = 1;
+	if (vips_image_get_typeof(image, "n-pages")) {
+		vips_image_get_int(image, "n-pages", &pages);
+	}
+	return pages;
+}
+
+static int
+imaginary_losslesssave(VipsImage *in, void **buf, size_t *len) {
+	return vips_pngsave_buffer(in, buf, len, "compression", 1, NULL);
+}
+*/
+import "C"
+
+import "unsafe"
+
+// PDFInfo returns the size of the given pages of the PDF document, stacked vertically,
+// once rendered at the given density. The pages are not rendered.
+func PDFInfo(buf []byte, page, n int, dpi float64) (PDFDocument, error) {
+	input := C.CBytes(buf)
+	defer C.free(input)
+
+	image, err := loadPDF(input, len(buf), page, n, dpi)
+	if err != nil {
+		return PDFDocument{}, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return PDFDocument{
+		Width:  int(C.vips_image_get_width(image)),
+		Height: int(C.vips_image_get_height(image)),
+		Pages:  int(C.imaginary_pdf_pages(image)),
+	}, nil
+}
+
+// LoadPDF renders the given pages of the PDF document at the given density,
+// stacked vertically, and encodes them losslessly as PNG.
+func LoadPDF(buf []byte, page, n int, dpi float64) ([]byte, error) {
+	input := C.CBytes(buf)
+	defer C.free(input)
+
+	image, err := loadPDF(input, len(buf), page, n, dpi)
+	if err != nil {
+		return nil, err
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return encode(image, func(image *C.VipsImage, ptr *unsafe.Pointer, length *C.size_t) C.int {
+		return C.imaginary_losslesssave(image, ptr, length)
+	})
+}
+
+func loadPDF(input unsafe.Pointer, length, page, n int, dpi float64) (*C.VipsImage, error) {
+	var image *C.VipsImage
+	if C.imaginary_pdfload(input, C.size_t(length), &image, C.int(page), C.int(n), C.double(dpi)) != 0 {
+		return nil, lastError()
+	}
+	return image, nil
+}
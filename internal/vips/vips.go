@@ -0,0 +1,165 @@
+//go:build cgo
+// +build cgo
+
+package vips
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+#define IMAGINARY_VIPS_AT_LEAST(major, minor) \
+	(VIPS_MAJOR_VERSION > major || (VIPS_MAJOR_VERSION == major && VIPS_MINOR_VERSION >= minor))
+
+static VipsImage *
+imaginary_load(void *buf, size_t len) {
+	return vips_image_new_from_buffer(buf, len, "", NULL);
+}
+
+static int
+imaginary_jpegsave(VipsImage *in, void **buf, size_t *len, int strip, int quality, int interlace,
+	int subsample, int trellis, int overshoot, int optimize_scans, int optimize_coding, int quant_table) {
+#if IMAGINARY_VIPS_AT_LEAST(8, 10)
+	return vips_jpegsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"interlace", interlace,
+		"subsample_mode", subsample,
+		"trellis_quant", trellis,
+		"overshoot_deringing", overshoot,
+		"optimize_scans", optimize_scans,
+		"optimize_coding", optimize_coding,
+		"quant_table", quant_table,
+		NULL
+	);
+#else
+	return vips_jpegsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"interlace", interlace,
+		"no_subsample", subsample == 2,
+		"trellis_quant", trellis,
+		"overshoot_deringing", overshoot,
+		"optimize_scans", optimize_scans,
+		"optimize_coding", optimize_coding,
+		"quant_table", quant_table,
+		NULL
+	);
+#endif
+}
+
+static int
+imaginary_webpsave(VipsImage *in, void **buf, size_t *len, int strip, int quality, int lossless,
+	int near_lossless, int alpha_quality, int effort, int smart_subsample) {
+	return vips_webpsave_buffer(in, buf, len,
+		"strip", strip,
+		"Q", quality,
+		"lossless", lossless,
+		"near_lossless", near_lossless,
+		"alpha_q", alpha_quality,
+#if IMAGINARY_VIPS_AT_LEAST(8, 12)
+		"effort", effort,
+#else
+		"reduction_effort", effort,
+#endif
+		"smart_subsample", smart_subsample,
+		NULL
+	);
+}
+
+static int
+imaginary_pngsave(VipsImage *in, void **buf, size_t *len, int strip, int compression, int quality,
+	int interlace, int palette, int effort, int filter) {
+	return vips_pngsave_buffer(in, buf, len,
+		"strip", strip,
+		"compression", compression,
+		"interlace", interlace,
+		"filter", filter,
+#if IMAGINARY_VIPS_AT_LEAST(8, 12)
+		"palette", palette,
+		"Q", quality,
+		"effort", effort,
+#elif IMAGINARY_VIPS_AT_LEAST(8, 7)
+		"palette", palette,
+		"Q", quality,
+#endif
+		NULL
+	);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// SaveJPEG encodes the image as JPEG with the given encoder options.
+func SaveJPEG(buf []byte, o JPEGOptions) ([]byte, error) {
+	return save(buf, func(image *C.VipsImage, ptr *unsafe.Pointer, length *C.size_t) C.int {
+		return C.imaginary_jpegsave(image, ptr, length, cbool(o.Strip), C.int(o.Quality), cbool(o.Interlace),
+			C.int(o.Subsample), cbool(o.Trellis), cbool(o.Overshoot), cbool(o.OptimizeScans),
+			cbool(o.OptimizeCoding), C.int(o.QuantTable))
+	})
+}
+
+// SaveWebP encodes the image as WebP with the given encoder options.
+func SaveWebP(buf []byte, o WebPOptions) ([]byte, error) {
+	return save(buf, func(image *C.VipsImage, ptr *unsafe.Pointer, length *C.size_t) C.int {
+		return C.imaginary_webpsave(image, ptr, length, cbool(o.Strip), C.int(o.Quality), cbool(o.Lossless),
+			cbool(o.NearLossless), C.int(o.AlphaQuality), C.int(o.Effort), cbool(o.SmartSubsample))
+	})
+}
+
+// SavePNG encodes the image as PNG with the given encoder options.
+func SavePNG(buf []byte, o PNGOptions) ([]byte, error) {
+	return save(buf, func(image *C.VipsImage, ptr *unsafe.Pointer, length *C.size_t) C.int {
+		return C.imaginary_pngsave(image, ptr, length, cbool(o.Strip), C.int(o.Compression), C.int(o.Quality),
+			cbool(o.Interlace), cbool(o.Palette), C.int(o.Effort), C.int(o.Filter))
+	})
+}
+
+// save loads the image and encodes it via the given libvips save operation.
+func save(buf []byte, fn func(*C.VipsImage, *unsafe.Pointer, *C.size_t) C.int) ([]byte, error) {
+	// libvips reads the image lazily, so the buffer must outlive the image
+	input := C.CBytes(buf)
+	defer C.free(input)
+
+	image := C.imaginary_load(input, C.size_t(len(buf)))
+	if image == nil {
+		return nil, lastError()
+	}
+	defer C.g_object_unref(C.gpointer(image))
+
+	return encode(image, fn)
+}
+
+// encode encodes the image via the given libvips save operation.
+func encode(image *C.VipsImage, fn func(*C.VipsImage, *unsafe.Pointer, *C.size_t) C.int) ([]byte, error) {
+	var ptr unsafe.Pointer
+	var length C.size_t
+	if fn(image, &ptr, &length) != 0 {
+		return nil, lastError()
+	}
+
+	defer C.g_free(C.gpointer(ptr))
+	return C.GoBytes(ptr, C.int(length)), nil
+}
+
+// lastError returns the last libvips error, clearing it.
+func lastError() error {
+	message := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+	C.vips_error_clear()
+	if message == "" {
+		message = "libvips internal error"
+	}
+	return errors.New(message)
+}
+
+func cbool(value bool) C.int {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+//go:build !cgo
+// +build !cgo
+
+package vips
+
+// SaveJPEG encodes the image as JPEG, requiring libvips.
+func SaveJPEG(buf []byte, o JPEGOptions) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// SaveWebP encodes the image as WebP, requiring libvips.
+func SaveWebP(buf []byte, o WebPOptions) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// SavePNG encodes the image as PNG, requiring libvips.
+func SavePNG(buf []byte, o PNGOptions) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+// PDFInfo returns the size of the given pages of the PDF document, requiring libvips.
+func PDFInfo(buf []byte, page, n int, dpi float64) (PDFDocument, error) {
+	return PDFDocument{}, ErrNotSupported
+}
+
+// LoadPDF renders the given pages of the PDF document, requiring libvips.
+func LoadPDF(buf []byte, page, n int, dpi float64) ([]byte, error) {
+	return nil, ErrNotSupported
+}
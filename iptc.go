@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+const (
+	// iptcMarker defines the tag marker byte of every IPTC-IIM dataset.
+	iptcMarker = 0x1c
+	// iptcApplicationRecord defines the IPTC-IIM record holding the editorial metadata.
+	iptcApplicationRecord = 2
+	// photoshopIPTCResource defines the Photoshop image resource holding IPTC-IIM data.
+	photoshopIPTCResource = 0x0404
+)
+
+// iptcDatasetNames defines the names of the supported application record datasets.
+var iptcDatasetNames = map[byte]string{
+	5:   "ObjectName",
+	7:   "EditStatus",
+	10:  "Urgency",
+	12:  "SubjectReference",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	22:  "FixtureIdentifier",
+	25:  "Keywords",
+	26:  "ContentLocationCode",
+	27:  "ContentLocationName",
+	30:  "ReleaseDate",
+	35:  "ReleaseTime",
+	37:  "ExpirationDate",
+	38:  "ExpirationTime",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	62:  "DigitalCreationDate",
+	63:  "DigitalCreationTime",
+	65:  "OriginatingProgram",
+	70:  "ProgramVersion",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	100: "Country-PrimaryLocationCode",
+	101: "Country-PrimaryLocationName",
+	103: "OriginalTransmissionReference",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	118: "Contact",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// iptcRepeatableDatasets defines the datasets that can be defined more than once, returned as lists.
+var iptcRepeatableDatasets = map[byte]bool{
+	12: true, 20: true, 25: true, 26: true, 27: true, 80: true, 85: true, 118: true, 122: true,
+}
+
+// iptcCopyrightDatasets defines the application record datasets of the copyright metadata policy group.
+var iptcCopyrightDatasets = map[byte]bool{
+	80: true, 85: true, 110: true, 115: true, 116: true, 118: true,
+}
+
+// parseIPTC parses the given IPTC-IIM data, returning the supported application record datasets by name.
+func parseIPTC(buf []byte) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for offset := 0; offset+5 <= len(buf) && buf[offset] == iptcMarker; {
+		record, dataset := buf[offset+1], buf[offset+2]
+		length := int(binary.BigEndian.Uint16(buf[offset+3:]))
+		if length&0x8000 != 0 || offset+5+length > len(buf) {
+			// Extended datasets are only used for binary data
+			break
+		}
+		value := buf[offset+5 : offset+5+length]
+		offset += 5 + length
+
+		name, ok := iptcDatasetNames[dataset]
+		if record != iptcApplicationRecord || !ok {
+			continue
+		}
+
+		text := iptcString(value)
+		if text == "" {
+			continue
+		}
+		if iptcRepeatableDatasets[dataset] {
+			values, _ := fields[name].([]string)
+			fields[name] = append(values, text)
+		} else {
+			fields[name] = text
+		}
+	}
+	return fields
+}
+
+// iptcString returns the dataset value as text, decoding it as Latin-1 if it's not valid UTF-8.
+func iptcString(value []byte) string {
+	value = bytes.TrimSpace(bytes.TrimRight(value, "\x00"))
+	if utf8.Valid(value) {
+		return string(value)
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// photoshopIPTCResourceBlock returns the Photoshop image resources holding only the given IPTC-IIM data.
+func photoshopIPTCResourceBlock(iptc []byte) []byte {
+	buf := make([]byte, 12, 12+len(iptc)+1)
+	copy(buf, "8BIM")
+	binary.BigEndian.PutUint16(buf[4:], photoshopIPTCResource)
+	// Empty resource name, padded to an even length
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(iptc)))
+	buf = append(buf, iptc...)
+	if len(iptc)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// readPhotoshopIPTC returns the IPTC-IIM data of the given Photoshop image resources.
+func readPhotoshopIPTC(buf []byte) []byte {
+	for offset := 0; offset+7 <= len(buf) && string(buf[offset:offset+4]) == "8BIM"; {
+		id := binary.BigEndian.Uint16(buf[offset+4:])
+
+		// Resource name as a Pascal string, padded to an even length
+		nameLength := int(buf[offset+6]) + 1
+		nameLength += nameLength % 2
+		offset += 6 + nameLength
+		if offset+4 > len(buf) {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint32(buf[offset:]))
+		offset += 4
+		if size < 0 || offset+size > len(buf) {
+			break
+		}
+		if id == photoshopIPTCResource {
+			return buf[offset : offset+size]
+		}
+		offset += size + size%2
+	}
+	return nil
+}
+
+// filterIPTC returns the given IPTC-IIM data without the application record datasets not kept by the policy,
+// or nil if none is kept. Datasets of other records are kept along with the application record.
+func filterIPTC(buf []byte, policy MetadataPolicy) []byte {
+	var out []byte
+	kept := false
+	for offset := 0; offset+5 <= len(buf) && buf[offset] == iptcMarker; {
+		record, dataset := buf[offset+1], buf[offset+2]
+		length := int(binary.BigEndian.Uint16(buf[offset+3:]))
+		if length&0x8000 != 0 || offset+5+length > len(buf) {
+			break
+		}
+		data := buf[offset : offset+5+length]
+		offset += 5 + length
+
+		if record == iptcApplicationRecord {
+			groups := []string{MetadataIPTC}
+			if iptcCopyrightDatasets[dataset] {
+				groups = []string{MetadataCopyright, MetadataIPTC}
+			}
+			if !policy.keeps(groups...) {
+				continue
+			}
+			kept = true
+		}
+		out = append(out, data...)
+	}
+
+	if !kept {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// JPEG markers.
+const (
+	jpegSOI  = 0xd8
+	jpegEOI  = 0xd9
+	jpegSOS  = 0xda
+	jpegAPP0 = 0xe0
+	jpegAPP1 = 0xe1
+	jpegAPP2 = 0xe2
+	jpegAPPD = 0xed
+)
+
+// Signatures of the JPEG application segments holding metadata.
+const (
+	jpegJFIFSignature      = "JFIF\x00"
+	jpegEXIFSignature      = "Exif\x00\x00"
+	jpegXMPSignature       = "http://ns.adobe.com/xap/1.0/\x00"
+	jpegXMPExtSignature    = "http://ns.adobe.com/xmp/extension/\x00"
+	jpegICCSignature       = "ICC_PROFILE\x00"
+	jpegPhotoshopSignature = "Photoshop 3.0\x00"
+)
+
+var errInvalidJPEG = errors.New("invalid JPEG image")
+
+// jpegSegment represents a marker segment of a JPEG image.
+type jpegSegment struct {
+	Marker byte
+	Data   []byte
+}
+
+// readJPEGSegments returns the marker segments of a JPEG image preceding the image data,
+// excluding the start of image marker, and the offset the image data starts at.
+func readJPEGSegments(buf []byte) ([]jpegSegment, int, error) {
+	if len(buf) < 4 || buf[0] != 0xff || buf[1] != jpegSOI {
+		return nil, 0, errInvalidJPEG
+	}
+
+	var segments []jpegSegment
+	offset := 2
+	for offset < len(buf) {
+		if buf[offset] != 0xff {
+			return nil, 0, errInvalidJPEG
+		}
+		start := offset
+
+		// Skip fill bytes
+		for offset < len(buf) && buf[offset] == 0xff {
+			offset++
+		}
+		if offset >= len(buf) {
+			return nil, 0, errInvalidJPEG
+		}
+
+		marker := buf[offset]
+		offset++
+		if marker == jpegEOI || marker == jpegSOS {
+			return segments, start, nil
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			// Markers without payload
+			continue
+		}
+
+		if offset+2 > len(buf) {
+			return nil, 0, errInvalidJPEG
+		}
+		length := int(binary.BigEndian.Uint16(buf[offset:]))
+		if length < 2 || offset+length > len(buf) {
+			return nil, 0, errInvalidJPEG
+		}
+
+		segments = append(segments, jpegSegment{Marker: marker, Data: buf[offset+2 : offset+length]})
+		offset += length
+	}
+
+	return segments, len(buf), nil
+}
+
+// writeJPEGSegments returns a JPEG image with the given marker segments followed by the image data.
+// Segments exceeding the max segment size are omitted.
+func writeJPEGSegments(segments []jpegSegment, data []byte) []byte {
+	buf := []byte{0xff, jpegSOI}
+	for _, segment := range segments {
+		if len(segment.Data)+2 > 0xffff {
+			continue
+		}
+		buf = append(buf, 0xff, segment.Marker, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(segment.Data)+2))
+		buf = append(buf, segment.Data...)
+	}
+	return append(buf, data...)
+}
+
+// isJPEGFrameMarker returns true for the start of frame markers, defining the image encoding.
+func isJPEGFrameMarker(marker byte) bool {
+	return marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+}
+
+// isJPEGProgressiveMarker returns true for the start of frame markers of progressive images.
+func isJPEGProgressiveMarker(marker byte) bool {
+	return marker == 0xc2 || marker == 0xc6 || marker == 0xca || marker == 0xce
+}
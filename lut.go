@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lutMaxSize defines the max number of entries of each dimension of the 3D LUTs.
+const lutMaxSize = 65
+
+// lutExtensions defines the file extensions of the LUTs read from the LUTs directory.
+var lutExtensions = map[string]bool{".cube": true}
+
+// luts stores the LUTs of the LUTs directory, by lower case file name without extension.
+var luts = map[string]*LUT{}
+
+// LUT represents a 3D colour lookup table, mapping RGB colours within its domain to RGB colours between 0 and 1.
+type LUT struct {
+	Size int
+	Min  [3]float64
+	Max  [3]float64
+	// Table stores the output colours, being red the fastest changing input colour.
+	Table [][3]float64
+}
+
+// LoadLUTs loads the LUTs of the LUTs directory, if present, based on the server options.
+func LoadLUTs(o ServerOptions) error {
+	if o.LUTsDir == "" {
+		return nil
+	}
+
+	registry, err := readLUTsDirectory(o.LUTsDir)
+	if err != nil {
+		return err
+	}
+	luts = registry
+	return nil
+}
+
+// readLUTsDirectory returns the valid LUTs of the given directory, registered by their lower case file name without extension.
+func readLUTsDirectory(dir string) (map[string]*LUT, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := make(map[string]*LUT)
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || !lutExtensions[strings.ToLower(ext)] {
+			continue
+		}
+
+		lut, err := readLUTFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("invalid LUT %s: %s", file.Name(), err)
+		}
+
+		registry[strings.ToLower(strings.TrimSuffix(file.Name(), ext))] = lut
+	}
+	return registry, nil
+}
+
+func readLUTFile(path string) (*LUT, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseCubeLUT(file)
+}
+
+// parseCubeLUT parses a 3D LUT in the Adobe/Resolve .cube format.
+func parseCubeLUT(r io.Reader) (*LUT, error) {
+	lut := &LUT{Max: [3]float64{1, 1, 1}}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var err error
+		switch keyword := fields[0]; keyword {
+		case "TITLE":
+			// The LUT title is ignored
+		case "LUT_1D_SIZE":
+			return nil, errors.New("1D LUTs are not supported")
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid LUT_3D_SIZE at line %d", line)
+			}
+			lut.Size, err = strconv.Atoi(fields[1])
+			if err != nil || lut.Size < 2 || lut.Size > lutMaxSize {
+				return nil, fmt.Errorf("LUT_3D_SIZE must be between 2 and %d", lutMaxSize)
+			}
+			lut.Table = make([][3]float64, 0, lut.Size*lut.Size*lut.Size)
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			var value [3]float64
+			if value, err = parseLUTValues(fields[1:]); err != nil {
+				return nil, fmt.Errorf("invalid %s at line %d", keyword, line)
+			}
+			if keyword == "DOMAIN_MIN" {
+				lut.Min = value
+			} else {
+				lut.Max = value
+			}
+		case "LUT_3D_INPUT_RANGE":
+			var min, max float64
+			if len(fields) == 3 {
+				min, err = strconv.ParseFloat(fields[1], 64)
+				if err == nil {
+					max, err = strconv.ParseFloat(fields[2], 64)
+				}
+			}
+			if len(fields) != 3 || err != nil {
+				return nil, fmt.Errorf("invalid LUT_3D_INPUT_RANGE at line %d", line)
+			}
+			lut.Min = [3]float64{min, min, min}
+			lut.Max = [3]float64{max, max, max}
+		default:
+			if lut.Size == 0 {
+				return nil, fmt.Errorf("missing LUT_3D_SIZE before line %d", line)
+			}
+			var value [3]float64
+			if value, err = parseLUTValues(fields); err != nil {
+				return nil, fmt.Errorf("invalid LUT entry at line %d", line)
+			}
+			if len(lut.Table) == cap(lut.Table) {
+				return nil, fmt.Errorf("too many LUT entries at line %d", line)
+			}
+			lut.Table = append(lut.Table, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.Size == 0 || len(lut.Table) != lut.Size*lut.Size*lut.Size {
+		return nil, fmt.Errorf("expected %d LUT entries, got %d", lut.Size*lut.Size*lut.Size, len(lut.Table))
+	}
+	for i := range lut.Min {
+		if lut.Max[i] <= lut.Min[i] {
+			return nil, errors.New("DOMAIN_MAX must be greater than DOMAIN_MIN")
+		}
+	}
+	return lut, nil
+}
+
+func parseLUTValues(fields []string) (value [3]float64, err error) {
+	if len(fields) != 3 {
+		return value, ErrUnsupportedValue
+	}
+	for i, field := range fields {
+		if value[i], err = strconv.ParseFloat(field, 64); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// Lookup returns the output colour of the given RGB colour, between 0 and 1, via trilinear interpolation.
+func (l *LUT) Lookup(c [3]float64) [3]float64 {
+	var index [3]int
+	var fraction [3]float64
+	for i := range c {
+		position := (c[i] - l.Min[i]) / (l.Max[i] - l.Min[i])
+		position = math.Max(0, math.Min(1, position)) * float64(l.Size-1)
+		index[i] = int(math.Min(position, float64(l.Size-2)))
+		fraction[i] = position - float64(index[i])
+	}
+
+	var out [3]float64
+	for corner := 0; corner < 8; corner++ {
+		weight := 1.0
+		var offset [3]int
+		for i := range offset {
+			if corner&(1<<uint(i)) != 0 {
+				offset[i] = 1
+				weight *= fraction[i]
+			} else {
+				weight *= 1 - fraction[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+
+		entry := l.Table[(index[0]+offset[0])+(index[1]+offset[1])*l.Size+(index[2]+offset[2])*l.Size*l.Size]
+		for i := range out {
+			out[i] += entry[i] * weight
+		}
+	}
+	return out
+}
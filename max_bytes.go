@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+// Encoder quality bounds of the images encoded under a max size.
+const (
+	maxBytesMinQuality = 10
+	maxBytesMaxQuality = 100
+)
+
+// maxBytesShrinkSteps defines the max number of times the image is downscaled to fit in the max size.
+const maxBytesShrinkSteps = 5
+
+// encodeFunc encodes an image with the given quality.
+type encodeFunc func(quality int) (Image, error)
+
+// processMaxBytes encodes the image with the highest quality producing an output image no bigger than the
+// max size of the image options, up to the requested quality. If the image doesn't fit even with the lowest
+// quality and the shrink param is true, the image is then downscaled until it fits.
+func processMaxBytes(buf []byte, opts bimg.Options, o ImageOptions) (Image, error) {
+	if opts.Type == bimg.UNKNOWN {
+		opts.Type = bimg.DetermineImageType(buf)
+	}
+	if !qualityTypes[opts.Type] {
+		return Image{}, NewError("Max size is only supported for JPEG, WebP and AVIF output images", http.StatusBadRequest)
+	}
+
+	maxQuality := maxBytesMaxQuality
+	if opts.Quality > 0 && opts.Quality < maxQuality {
+		maxQuality = opts.Quality
+	}
+	minQuality := maxBytesMinQuality
+	if minQuality > maxQuality {
+		minQuality = maxQuality
+	}
+
+	image, ok, err := searchQuality(func(quality int) (Image, error) {
+		opts.Quality = quality
+		return process(buf, opts, o)
+	}, o.MaxBytes, minQuality, maxQuality)
+	if ok || err != nil {
+		return image, err
+	}
+	if !o.Shrink {
+		return Image{}, errMaxBytes(o.MaxBytes, len(image.Body))
+	}
+
+	// The processed image is downscaled from the best quality output, keeping its ICC profile
+	// in order to transform the colours only once
+	baseOptions := o
+	baseOptions.NoProfile = false
+	opts.Quality = maxQuality
+	base, err := process(buf, opts, baseOptions)
+	if err != nil {
+		return Image{}, err
+	}
+	size, err := bimg.Size(base.Body)
+	if err != nil {
+		return Image{}, err
+	}
+
+	width, height := float64(size.Width), float64(size.Height)
+	for step := 0; step < maxBytesShrinkSteps; step++ {
+		// The encoded size is roughly proportional to the image resolution
+		factor := math.Min(math.Sqrt(float64(o.MaxBytes)/float64(len(image.Body)))*0.95, 0.9)
+		width, height = width*factor, height*factor
+		if width < 1 || height < 1 {
+			break
+		}
+
+		shrinkOptions := bimg.Options{
+			Width:        int(math.Round(width)),
+			Height:       int(math.Round(height)),
+			Force:        true,
+			NoAutoRotate: true,
+			Type:         opts.Type,
+			Interlace:    opts.Interlace,
+			Compression:  opts.Compression,
+			Speed:        opts.Speed,
+		}
+		image, ok, err = searchQuality(func(quality int) (Image, error) {
+			shrinkOptions.Quality = quality
+			return process(base.Body, shrinkOptions, o)
+		}, o.MaxBytes, minQuality, maxQuality)
+		if ok || err != nil {
+			return image, err
+		}
+	}
+
+	return Image{}, errMaxBytes(o.MaxBytes, len(image.Body))
+}
+
+// searchQuality returns the image encoded with the highest quality between the given bounds
+// whose size doesn't exceed the given max size, via binary search. If no quality fits,
+// it returns the image encoded with the lowest quality and false.
+func searchQuality(encode encodeFunc, maxBytes, minQuality, maxQuality int) (Image, bool, error) {
+	best, err := encode(maxQuality)
+	if err != nil || len(best.Body) <= maxBytes {
+		best.Quality = maxQuality
+		return best, err == nil, err
+	}
+
+	best, err = encode(minQuality)
+	if err != nil || len(best.Body) > maxBytes {
+		best.Quality = minQuality
+		return best, false, err
+	}
+	best.Quality = minQuality
+
+	// The lower quality always fits, the upper one never does
+	lower, upper := minQuality, maxQuality
+	for upper-lower > 1 {
+		quality := (lower + upper) / 2
+		image, err := encode(quality)
+		if err != nil {
+			return Image{}, false, err
+		}
+		if len(image.Body) > maxBytes {
+			upper = quality
+			continue
+		}
+		lower = quality
+		best = image
+		best.Quality = quality
+	}
+	return best, true, nil
+}
+
+func errMaxBytes(maxBytes, size int) Error {
+	return NewError(fmt.Sprintf("Cannot encode the image in %d bytes, the smallest output image is %d bytes", maxBytes, size), http.StatusUnprocessableEntity)
+}
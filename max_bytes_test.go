@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// testEncoder returns an encoder producing images of ten bytes per quality unit, and counts its calls.
+func testEncoder(calls *int) encodeFunc {
+	return func(quality int) (Image, error) {
+		*calls++
+		return Image{Body: bytes.Repeat([]byte{0}, quality*10)}, nil
+	}
+}
+
+func TestSearchQuality(t *testing.T) {
+	cases := []struct {
+		maxBytes int
+		quality  int
+		ok       bool
+	}{
+		{2000, 100, true},
+		{555, 55, true},
+		{100, 10, true},
+		{99, 10, false},
+	}
+
+	for _, c := range cases {
+		calls := 0
+		image, ok, err := searchQuality(testEncoder(&calls), c.maxBytes, 10, 100)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if ok != c.ok || image.Quality != c.quality {
+			t.Errorf("Invalid quality for %d bytes: %d, %v", c.maxBytes, image.Quality, ok)
+		}
+		if ok && len(image.Body) > c.maxBytes {
+			t.Errorf("Image of %d bytes exceeds %d bytes", len(image.Body), c.maxBytes)
+		}
+		if calls > 9 {
+			t.Errorf("Too many encodes for %d bytes: %d", c.maxBytes, calls)
+		}
+	}
+}
+
+func TestProcessMaxBytesType(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/imaginary.jpg")
+	_, err := Process(buf, bimg.Options{Type: bimg.PNG}, ImageOptions{MaxBytes: 1000})
+	if e, ok := err.(Error); !ok || e.Code != 400 {
+		t.Errorf("Expected an error for a PNG output image: %v", err)
+	}
+}
+
+func TestMaxBytesParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{"maxbytes": {"50000"}, "shrink": {"true"}})
+	if err != nil || opts.MaxBytes != 50000 || !opts.Shrink {
+		t.Errorf("Invalid max bytes params: %d, %v, %v", opts.MaxBytes, opts.Shrink, err)
+	}
+	for _, value := range []string{"0", "abc"} {
+		if _, err := buildParamsFromQuery(map[string][]string{"maxbytes": {value}}); err == nil {
+			t.Errorf("Expected an error for maxbytes %s", value)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/h2non/bimg"
+)
+
+// Metadata groups returned by the info endpoint.
+const (
+	MetadataEXIF = "exif"
+	MetadataGPS  = "gps"
+	MetadataIPTC = "iptc"
+	MetadataXMP  = "xmp"
+	MetadataICC  = "icc"
+)
+
+// metadataGroups defines the metadata groups returned by default.
+var metadataGroups = []string{MetadataEXIF, MetadataGPS, MetadataIPTC, MetadataXMP, MetadataICC}
+
+// metadataMaxSize defines the max size of the compressed metadata once uncompressed.
+const metadataMaxSize = 16 << 20
+
+// TIFF tags holding metadata in TIFF images.
+const (
+	tiffBitsPerSampleTag  = 0x0102
+	tiffXResolutionTag    = 0x011a
+	tiffYResolutionTag    = 0x011b
+	tiffResolutionUnitTag = 0x0128
+	tiffXMPTag            = 0x02bc
+	tiffIPTCTag           = 0x83bb
+	tiffICCTag            = 0x8773
+)
+
+// ImageDPI represents the image horizontal and vertical resolution in dots per inch.
+type ImageDPI struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// imageMetadata stores the raw metadata and encoding details read from an image container.
+type imageMetadata struct {
+	EXIF        []byte
+	XMP         []byte
+	IPTC        []byte
+	ICC         []byte
+	BitDepth    int
+	DPI         *ImageDPI
+	Progressive bool
+	Frames      int
+}
+
+// readMetadata reads the metadata of JPEG, PNG, WebP, TIFF and GIF images.
+// Metadata of any other image type, or of malformed images, is returned empty.
+func readMetadata(buf []byte) imageMetadata {
+	var meta imageMetadata
+	switch bimg.DetermineImageType(buf) {
+	case bimg.JPEG:
+		meta = readJPEGMetadata(buf)
+	case bimg.PNG:
+		meta = readPNGMetadata(buf)
+	case bimg.WEBP:
+		meta = readWebPMetadata(buf)
+	case bimg.TIFF:
+		meta = readTIFFMetadata(buf)
+	case bimg.GIF:
+		meta = imageMetadata{BitDepth: 8, Frames: countPages(buf)}
+	}
+
+	if meta.DPI == nil && len(meta.EXIF) > 0 {
+		meta.DPI = exifResolution(meta.EXIF)
+	}
+	return meta
+}
+
+func readJPEGMetadata(buf []byte) imageMetadata {
+	var meta imageMetadata
+	segments, _, err := readJPEGSegments(buf)
+	if err != nil {
+		return meta
+	}
+
+	// ICC profiles may be split across several segments
+	iccChunks := make(map[int][]byte)
+
+	for _, segment := range segments {
+		data := segment.Data
+		switch {
+		case segment.Marker == jpegAPP0 && bytes.HasPrefix(data, []byte(jpegJFIFSignature)) && len(data) >= 12:
+			x, y := float64(binary.BigEndian.Uint16(data[8:])), float64(binary.BigEndian.Uint16(data[10:]))
+			switch data[7] {
+			case 1:
+				meta.DPI = &ImageDPI{X: x, Y: y}
+			case 2:
+				meta.DPI = &ImageDPI{X: toFixed(x*2.54, 2), Y: toFixed(y*2.54, 2)}
+			}
+		case segment.Marker == jpegAPP1 && bytes.HasPrefix(data, []byte(jpegEXIFSignature)):
+			meta.EXIF = data[len(jpegEXIFSignature):]
+		case segment.Marker == jpegAPP1 && bytes.HasPrefix(data, []byte(jpegXMPSignature)):
+			meta.XMP = data[len(jpegXMPSignature):]
+		case segment.Marker == jpegAPP2 && bytes.HasPrefix(data, []byte(jpegICCSignature)) && len(data) > len(jpegICCSignature)+2:
+			iccChunks[int(data[len(jpegICCSignature)])] = data[len(jpegICCSignature)+2:]
+		case segment.Marker == jpegAPPD && bytes.HasPrefix(data, []byte(jpegPhotoshopSignature)):
+			meta.IPTC = readPhotoshopIPTC(data[len(jpegPhotoshopSignature):])
+		case isJPEGFrameMarker(segment.Marker) && len(data) > 0:
+			meta.BitDepth = int(data[0])
+			meta.Progressive = isJPEGProgressiveMarker(segment.Marker)
+		}
+	}
+
+	sequence := make([]int, 0, len(iccChunks))
+	for i := range iccChunks {
+		sequence = append(sequence, i)
+	}
+	sort.Ints(sequence)
+	for _, i := range sequence {
+		meta.ICC = append(meta.ICC, iccChunks[i]...)
+	}
+
+	return meta
+}
+
+func readPNGMetadata(buf []byte) imageMetadata {
+	var meta imageMetadata
+	chunks, err := readPNGChunks(buf)
+	if err != nil {
+		return meta
+	}
+
+	for _, chunk := range chunks {
+		data := chunk.Data
+		switch chunk.Type {
+		case "IHDR":
+			meta.BitDepth = int(data[8])
+			meta.Progressive = data[12] == 1
+		case "pHYs":
+			// Pixels per metre
+			if len(data) == 9 && data[8] == 1 {
+				meta.DPI = &ImageDPI{
+					X: toFixed(float64(binary.BigEndian.Uint32(data[0:]))*0.0254, 2),
+					Y: toFixed(float64(binary.BigEndian.Uint32(data[4:]))*0.0254, 2),
+				}
+			}
+		case "eXIf":
+			meta.EXIF = data
+		case "iCCP":
+			// Profile name, compression method and zlib compressed profile
+			if i := bytes.IndexByte(data, 0); i > 0 && i+2 < len(data) {
+				meta.ICC = inflate(data[i+2:])
+			}
+		case "iTXt":
+			meta.XMP = readPNGXMP(data, meta.XMP)
+		case "acTL":
+			if len(data) >= 4 {
+				meta.Frames = int(binary.BigEndian.Uint32(data))
+			}
+		}
+	}
+
+	return meta
+}
+
+// readPNGXMP returns the XMP packet of an international text chunk,
+// or the given current packet if it's not an XMP chunk.
+func readPNGXMP(data []byte, current []byte) []byte {
+	keyword := []byte(pngXMPKeyword)
+	if !bytes.HasPrefix(data, keyword) || len(data) < len(keyword)+2 {
+		return current
+	}
+
+	compressed := data[len(keyword)] == 1
+	text := data[len(keyword)+2:]
+
+	// Skip the language tag and the translated keyword
+	for i := 0; i < 2; i++ {
+		end := bytes.IndexByte(text, 0)
+		if end < 0 {
+			return current
+		}
+		text = text[end+1:]
+	}
+
+	if compressed {
+		return inflate(text)
+	}
+	return text
+}
+
+func readWebPMetadata(buf []byte) imageMetadata {
+	meta := imageMetadata{BitDepth: 8}
+	chunks, err := readWebPChunks(buf)
+	if err != nil {
+		return meta
+	}
+
+	for _, chunk := range chunks {
+		switch chunk.ID {
+		case "ICCP":
+			meta.ICC = chunk.Data
+		case "EXIF":
+			meta.EXIF = bytes.TrimPrefix(chunk.Data, []byte(jpegEXIFSignature))
+		case "XMP ":
+			meta.XMP = chunk.Data
+		}
+	}
+
+	if anim, err := parseWebPAnimation(buf); err == nil && anim != nil {
+		meta.Frames = len(anim.Frames)
+	}
+
+	return meta
+}
+
+func readTIFFMetadata(buf []byte) imageMetadata {
+	meta := imageMetadata{EXIF: buf}
+	order := tiffByteOrder(buf)
+	if order == nil {
+		return meta
+	}
+
+	entries, err := readTIFFDirectory(buf, order, int(order.Uint32(buf[4:8])))
+	if err != nil {
+		return meta
+	}
+
+	for _, entry := range entries {
+		switch entry.Tag {
+		case tiffBitsPerSampleTag:
+			meta.BitDepth, _ = tiffEntryInt(entry, order)
+		case tiffXMPTag:
+			meta.XMP = entry.Value
+		case tiffIPTCTag:
+			meta.IPTC = entry.Value
+		case tiffICCTag:
+			meta.ICC = entry.Value
+		}
+	}
+
+	return meta
+}
+
+// exifResolution returns the image resolution defined by the EXIF IFD0 fields, if any.
+func exifResolution(buf []byte) *ImageDPI {
+	order := tiffByteOrder(buf)
+	if order == nil {
+		return nil
+	}
+	entries, err := readTIFFDirectory(buf, order, int(order.Uint32(buf[4:8])))
+	if err != nil {
+		return nil
+	}
+
+	var x, y []float64
+	unit := 2 // Inches by default
+	for _, entry := range entries {
+		switch entry.Tag {
+		case tiffXResolutionTag:
+			x = tiffEntryFloats(entry, order)
+		case tiffYResolutionTag:
+			y = tiffEntryFloats(entry, order)
+		case tiffResolutionUnitTag:
+			unit, _ = tiffEntryInt(entry, order)
+		}
+	}
+
+	if len(x) != 1 || len(y) != 1 || x[0] <= 0 || y[0] <= 0 {
+		return nil
+	}
+	switch unit {
+	case 2:
+		return &ImageDPI{X: toFixed(x[0], 2), Y: toFixed(y[0], 2)}
+	case 3:
+		return &ImageDPI{X: toFixed(x[0]*2.54, 2), Y: toFixed(y[0]*2.54, 2)}
+	}
+	return nil
+}
+
+// addMetadataGroups parses the requested metadata groups into the image details.
+// Groups not present in the image are omitted.
+func addMetadataGroups(info *ImageInfo, meta imageMetadata, groups []string) {
+	if groups == nil {
+		groups = metadataGroups
+	}
+
+	requested := make(map[string]bool)
+	for _, group := range groups {
+		requested[group] = true
+	}
+
+	if len(meta.EXIF) > 0 && (requested[MetadataEXIF] || requested[MetadataGPS]) {
+		if fields, gps, err := parseEXIF(meta.EXIF); err == nil {
+			if requested[MetadataEXIF] && len(fields) > 0 {
+				info.EXIF = fields
+			}
+			if requested[MetadataGPS] {
+				info.GPS = gps
+			}
+		}
+	}
+	if len(meta.IPTC) > 0 && requested[MetadataIPTC] {
+		if fields := parseIPTC(meta.IPTC); len(fields) > 0 {
+			info.IPTC = fields
+		}
+	}
+	if len(meta.XMP) > 0 && requested[MetadataXMP] {
+		if fields, err := parseXMP(meta.XMP); err == nil && len(fields) > 0 {
+			info.XMP = fields
+		}
+	}
+	if len(meta.ICC) > 0 && requested[MetadataICC] {
+		if icc, err := parseICC(meta.ICC); err == nil {
+			info.ICC = icc
+		}
+	}
+}
+
+func isValidMetadataGroup(group string) bool {
+	for _, g := range metadataGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// inflate returns the given zlib compressed data uncompressed, or nil if it's invalid.
+func inflate(data []byte) []byte {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+
+	out, err := ioutil.ReadAll(io.LimitReader(reader, metadataMaxSize+1))
+	if err != nil || len(out) > metadataMaxSize {
+		return nil
+	}
+	return out
+}
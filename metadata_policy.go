@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Metadata groups only supported by the metadata policy, as subsets of the EXIF, IPTC and XMP groups.
+const (
+	MetadataMakerNotes = "makernotes"
+	MetadataSerials    = "serials"
+	MetadataCopyright  = "copyright"
+)
+
+// metadataThumbnails defines the internal metadata group of the embedded thumbnails,
+// not supported by the keep and strip params.
+const metadataThumbnails = "thumbnails"
+
+// metadataPolicyGroups defines the metadata groups that can be kept or stripped.
+var metadataPolicyGroups = []string{
+	MetadataICC, MetadataEXIF, MetadataGPS, MetadataMakerNotes,
+	MetadataSerials, MetadataXMP, MetadataIPTC, MetadataCopyright,
+}
+
+// metadataPolicy defines the server default metadata policy, applied if the request defines none.
+var metadataPolicy MetadataPolicy
+
+// MetadataPolicy defines the metadata groups kept or stripped from the output images.
+// If Keep is defined, only the metadata of the given groups is kept.
+// Metadata in both lists is kept or stripped according to its most specific group,
+// e.g. keep=copyright&strip=exif keeps the EXIF copyright fields only.
+type MetadataPolicy struct {
+	Keep  []string
+	Strip []string
+}
+
+// IsDefined returns true if the policy strips any metadata.
+func (p MetadataPolicy) IsDefined() bool {
+	return p.Keep != nil || len(p.Strip) > 0
+}
+
+// StripsAll returns true if the policy doesn't keep any metadata.
+func (p MetadataPolicy) StripsAll() bool {
+	return p.Keep != nil && len(p.Keep) == 0
+}
+
+// keeps returns whether the metadata of the given groups, from the most specific to the most generic one, is kept.
+func (p MetadataPolicy) keeps(groups ...string) bool {
+	for _, group := range groups {
+		if containsString(p.Strip, group) {
+			return false
+		}
+		if containsString(p.Keep, group) {
+			return true
+		}
+	}
+	return p.Keep == nil
+}
+
+// SetMetadataPolicy defines the default metadata policy based on the server options.
+func SetMetadataPolicy(o ServerOptions) {
+	metadataPolicy = o.MetadataPolicy
+}
+
+// ParseMetadataPolicy parses a metadata policy defined as a query string,
+// e.g. "keep=icc,copyright" or "strip=gps,makernotes".
+func ParseMetadataPolicy(value string) (MetadataPolicy, error) {
+	var policy MetadataPolicy
+	query, err := url.ParseQuery(value)
+	if err != nil {
+		return policy, err
+	}
+
+	for key := range query {
+		switch key {
+		case "keep":
+			policy.Keep, err = parseMetadataPolicyGroups(query.Get(key))
+		case "strip":
+			policy.Strip, err = parseMetadataPolicyGroups(query.Get(key))
+		default:
+			return policy, fmt.Errorf("unsupported metadata policy: %s", key)
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+// parseMetadataPolicyGroups parses a comma separated list of metadata groups.
+// An empty list is returned as an empty, not nil, slice.
+func parseMetadataPolicyGroups(value string) ([]string, error) {
+	groups := []string{}
+	for _, group := range strings.Split(value, ",") {
+		group = strings.TrimSpace(strings.ToLower(group))
+		if group == "" {
+			continue
+		}
+		if !containsString(metadataPolicyGroups, group) {
+			return nil, ErrUnsupportedValue
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// resolveMetadataPolicy returns the metadata policy of the given options.
+// The keep and strip params take precedence over the stripmeta param,
+// which takes precedence over the server default policy.
+func resolveMetadataPolicy(o ImageOptions) MetadataPolicy {
+	policy := metadataPolicy
+	if o.Keep != nil || o.Strip != nil {
+		policy = MetadataPolicy{Keep: o.Keep, Strip: o.Strip}
+	} else if o.IsDefinedField.StripMetadata {
+		policy = MetadataPolicy{}
+	}
+
+	if o.StripMetadata && policy.Keep == nil {
+		policy.Keep = []string{}
+	}
+	return policy
+}
+
+// canFilterMetadata returns true if the metadata of the image type can be filtered by a metadata policy.
+// The metadata of any other image type can only be stripped entirely.
+func canFilterMetadata(imageType bimg.ImageType) bool {
+	return imageType == bimg.JPEG || imageType == bimg.PNG || imageType == bimg.WEBP
+}
+
+// filterMetadata removes the metadata not kept by the policy from a JPEG, PNG or WebP image.
+// Images of any other type are returned unchanged.
+func filterMetadata(buf []byte, policy MetadataPolicy) ([]byte, error) {
+	switch bimg.DetermineImageType(buf) {
+	case bimg.JPEG:
+		return filterJPEGMetadata(buf, policy)
+	case bimg.PNG:
+		return filterPNGMetadata(buf, policy)
+	case bimg.WEBP:
+		return filterWebPMetadata(buf, policy)
+	}
+	return buf, nil
+}
+
+func filterJPEGMetadata(buf []byte, policy MetadataPolicy) ([]byte, error) {
+	segments, offset, err := readJPEGSegments(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([]jpegSegment, 0, len(segments))
+	for _, segment := range segments {
+		data := segment.Data
+		switch {
+		case segment.Marker == jpegAPP1 && bytes.HasPrefix(data, []byte(jpegEXIFSignature)):
+			exif := filterEXIF(data[len(jpegEXIFSignature):], policy)
+			if exif == nil {
+				continue
+			}
+			segment.Data = append([]byte(jpegEXIFSignature), exif...)
+		case segment.Marker == jpegAPP1 && bytes.HasPrefix(data, []byte(jpegXMPSignature)):
+			xmp := filterXMP(data[len(jpegXMPSignature):], policy)
+			if xmp == nil {
+				continue
+			}
+			segment.Data = append([]byte(jpegXMPSignature), xmp...)
+		case segment.Marker == jpegAPP1 && bytes.HasPrefix(data, []byte(jpegXMPExtSignature)):
+			// Extended XMP packets cannot be filtered independently of the main packet
+			continue
+		case segment.Marker == jpegAPP2 && bytes.HasPrefix(data, []byte(jpegICCSignature)):
+			if !policy.keeps(MetadataICC) {
+				continue
+			}
+		case segment.Marker == jpegAPPD && bytes.HasPrefix(data, []byte(jpegPhotoshopSignature)):
+			iptc := filterIPTC(readPhotoshopIPTC(data[len(jpegPhotoshopSignature):]), policy)
+			if iptc == nil {
+				continue
+			}
+			segment.Data = append([]byte(jpegPhotoshopSignature), photoshopIPTCResourceBlock(iptc)...)
+		}
+		kept = append(kept, segment)
+	}
+
+	return writeJPEGSegments(kept, buf[offset:]), nil
+}
+
+func filterPNGMetadata(buf []byte, policy MetadataPolicy) ([]byte, error) {
+	chunks, err := readPNGChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([]pngChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "eXIf":
+			exif := filterEXIF(chunk.Data, policy)
+			if exif == nil {
+				continue
+			}
+			chunk.Data = exif
+		case "iCCP":
+			if !policy.keeps(MetadataICC) {
+				continue
+			}
+		case "iTXt", "tEXt", "zTXt":
+			if chunk.Type == "iTXt" && bytes.HasPrefix(chunk.Data, []byte(pngXMPKeyword)) {
+				xmp := filterXMP(readPNGXMP(chunk.Data, nil), policy)
+				if xmp == nil {
+					continue
+				}
+				chunk.Data = append([]byte(pngXMPKeyword+"\x00\x00\x00\x00"), xmp...)
+			} else if bytes.HasPrefix(chunk.Data, []byte("Raw profile type ")) {
+				// Raw EXIF, IPTC or XMP profiles written by ImageMagick can only be stripped entirely
+				continue
+			}
+		}
+		kept = append(kept, chunk)
+	}
+
+	return writePNGChunks(kept), nil
+}
+
+func filterWebPMetadata(buf []byte, policy MetadataPolicy) ([]byte, error) {
+	chunks, err := readWebPChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags byte
+	kept := make([]riffChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		switch chunk.ID {
+		case "EXIF":
+			exif := filterEXIF(bytes.TrimPrefix(chunk.Data, []byte(jpegEXIFSignature)), policy)
+			if exif == nil {
+				continue
+			}
+			chunk.Data = exif
+			flags |= webpEXIFFlag
+		case "XMP ":
+			xmp := filterXMP(chunk.Data, policy)
+			if xmp == nil {
+				continue
+			}
+			chunk.Data = xmp
+			flags |= webpXMPFlag
+		case "ICCP":
+			if !policy.keeps(MetadataICC) {
+				continue
+			}
+			flags |= webpICCFlag
+		}
+		kept = append(kept, chunk)
+	}
+
+	// Update the extended format flags of the metadata chunks kept
+	if len(kept) > 0 && kept[0].ID == "VP8X" && len(kept[0].Data) > 0 {
+		header := append([]byte{}, kept[0].Data...)
+		header[0] = header[0]&^(webpICCFlag|webpEXIFFlag|webpXMPFlag) | flags
+		kept[0].Data = header
+	}
+
+	return writeWebPChunks(kept), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
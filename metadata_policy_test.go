@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"testing"
+)
+
+const testGPSXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmp:Rating="4" exif:GPSLatitude="33,51.6S">
+   <exif:GPSLongitude>151,12.6E</exif:GPSLongitude>
+   <dc:rights xmlns:dc="http://purl.org/dc/elements/1.1/"><rdf:Alt><rdf:li xml:lang="x-default">All rights reserved</rdf:li></rdf:Alt></dc:rights>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// testMetadataContainers returns JPEG, PNG and WebP images with the same EXIF, GPS, XMP and ICC metadata.
+func testMetadataContainers() map[string][]byte {
+	exif := newTestEXIF(
+		[]testTIFFField{asciiField(0x010f, "Imaginary"), asciiField(0x8298, "(c) Jane Doe")},
+		[]testTIFFField{
+			asciiField(0xa431, "123456"),
+			{Tag: 0x927c, Type: tiffUndefined, Count: 6, Value: []byte{0, 1, 2, 3, 4, 5}},
+		},
+		[]testTIFFField{
+			asciiField(gpsLatitudeRef, "S"),
+			rationalField(gpsLatitude, 33, 1, 51, 1, 36, 1),
+			asciiField(gpsLongitudeRef, "E"),
+			rationalField(gpsLongitude, 151, 1, 12, 1, 3600, 100),
+		},
+	)
+	icc := newTestICC("sRGB IEC61966-2.1")
+
+	jpeg := newTestJPEG(
+		jpegSegment{Marker: jpegAPP1, Data: append([]byte(jpegEXIFSignature), exif...)},
+		jpegSegment{Marker: jpegAPP1, Data: append([]byte(jpegXMPSignature), testGPSXMP...)},
+		jpegSegment{Marker: jpegAPPD, Data: append([]byte(jpegPhotoshopSignature), newTestIPTC(map[byte][]string{
+			25:  {"sea"},
+			116: {"(c) Jane Doe"},
+		})...)},
+		jpegSegment{Marker: jpegAPP2, Data: append([]byte(jpegICCSignature+"\x01\x01"), icc...)},
+	)
+
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	_, _ = writer.Write(icc)
+	_ = writer.Close()
+
+	png := writePNGChunks([]pngChunk{
+		{Type: "IHDR", Data: []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0}},
+		{Type: "iCCP", Data: append([]byte("sRGB\x00\x00"), compressed.Bytes()...)},
+		{Type: "eXIf", Data: exif},
+		{Type: "iTXt", Data: append([]byte(pngXMPKeyword+"\x00\x00\x00\x00"), testGPSXMP...)},
+		{Type: "tEXt", Data: []byte("Raw profile type exif\x00\nexif\n0\n")},
+		{Type: "IDAT", Data: []byte{0}},
+		{Type: "IEND"},
+	})
+
+	webp := writeWebPChunks([]riffChunk{
+		{ID: "VP8X", Data: webpHeader(webpICCFlag|webpEXIFFlag|webpXMPFlag, 1, 1)},
+		{ID: "ICCP", Data: icc},
+		{ID: "VP8L", Data: []byte{0x2f, 0, 0, 0, 0}},
+		{ID: "EXIF", Data: exif},
+		{ID: "XMP ", Data: []byte(testGPSXMP)},
+	})
+
+	return map[string][]byte{"jpeg": jpeg, "png": png, "webp": webp}
+}
+
+func TestFilterMetadataStripGPS(t *testing.T) {
+	policy := MetadataPolicy{Strip: []string{MetadataGPS, MetadataMakerNotes}}
+
+	for name, buf := range testMetadataContainers() {
+		out, err := filterMetadata(buf, policy)
+		if err != nil {
+			t.Fatalf("Cannot filter %s metadata: %s", name, err)
+		}
+
+		var info ImageInfo
+		addMetadataGroups(&info, readMetadata(out), nil)
+		if info.GPS != nil {
+			t.Errorf("GPS location not removed from %s: %#v", name, info.GPS)
+		}
+		if _, ok := info.XMP["exif:GPSLatitude"]; ok {
+			t.Errorf("XMP GPS latitude not removed from %s: %#v", name, info.XMP)
+		}
+		if _, ok := info.XMP["exif:GPSLongitude"]; ok {
+			t.Errorf("XMP GPS longitude not removed from %s: %#v", name, info.XMP)
+		}
+		if info.EXIF["Make"] != "Imaginary" || info.EXIF["BodySerialNumber"] != "123456" {
+			t.Errorf("EXIF fields not kept in %s: %#v", name, info.EXIF)
+		}
+		if info.XMP["xmp:Rating"] != "4" || info.XMP["dc:rights"] != "All rights reserved" {
+			t.Errorf("XMP properties not kept in %s: %#v", name, info.XMP)
+		}
+		if info.ICC == nil {
+			t.Errorf("ICC profile not kept in %s", name)
+		}
+		if bytes.Contains(readMetadata(out).EXIF, []byte{0, 1, 2, 3, 4, 5}) {
+			t.Errorf("Maker notes not removed from %s", name)
+		}
+	}
+}
+
+func TestFilterMetadataKeepCopyright(t *testing.T) {
+	policy := MetadataPolicy{Keep: []string{MetadataICC, MetadataCopyright}}
+
+	for name, buf := range testMetadataContainers() {
+		out, err := filterMetadata(buf, policy)
+		if err != nil {
+			t.Fatalf("Cannot filter %s metadata: %s", name, err)
+		}
+
+		meta := readMetadata(out)
+		var info ImageInfo
+		addMetadataGroups(&info, meta, nil)
+		if info.GPS != nil || len(info.EXIF) != 1 || info.EXIF["Copyright"] != "(c) Jane Doe" {
+			t.Errorf("Invalid %s EXIF metadata: %#v, %#v", name, info.EXIF, info.GPS)
+		}
+		if len(info.XMP) != 1 || info.XMP["dc:rights"] != "All rights reserved" {
+			t.Errorf("Invalid %s XMP metadata: %#v", name, info.XMP)
+		}
+		if info.ICC == nil {
+			t.Errorf("ICC profile not kept in %s", name)
+		}
+		if name == "jpeg" && (len(info.IPTC) != 1 || info.IPTC["CopyrightNotice"] != "(c) Jane Doe") {
+			t.Errorf("Invalid %s IPTC metadata: %#v", name, info.IPTC)
+		}
+		if name == "png" && bytes.Contains(out, []byte("Raw profile type")) {
+			t.Error("Raw profile not removed from png")
+		}
+	}
+}
+
+func TestFilterMetadataStripAll(t *testing.T) {
+	for name, buf := range testMetadataContainers() {
+		out, err := filterMetadata(buf, MetadataPolicy{Keep: []string{}})
+		if err != nil {
+			t.Fatalf("Cannot filter %s metadata: %s", name, err)
+		}
+
+		meta := readMetadata(out)
+		if meta.EXIF != nil || meta.XMP != nil || meta.IPTC != nil || meta.ICC != nil {
+			t.Errorf("Metadata not removed from %s: %#v", name, meta)
+		}
+	}
+
+	chunks, err := readWebPChunks(func() []byte {
+		out, _ := filterMetadata(testMetadataContainers()["webp"], MetadataPolicy{Strip: []string{MetadataEXIF, MetadataXMP}})
+		return out
+	}())
+	if err != nil || chunks[0].Data[0] != webpICCFlag {
+		t.Errorf("Invalid WebP extended format flags: %#v", chunks[0])
+	}
+}
+
+func TestMetadataPolicyParams(t *testing.T) {
+	defer func(policy MetadataPolicy) { metadataPolicy = policy }(metadataPolicy)
+
+	var err error
+	metadataPolicy, err = ParseMetadataPolicy("strip=gps,makernotes")
+	if err != nil || len(metadataPolicy.Strip) != 2 || metadataPolicy.Keep != nil {
+		t.Fatalf("Invalid metadata policy: %#v, %v", metadataPolicy, err)
+	}
+	for _, value := range []string{"keep=icc,location", "remove=gps"} {
+		if _, err := ParseMetadataPolicy(value); err == nil {
+			t.Errorf("Expected an error for the metadata policy %s", value)
+		}
+	}
+
+	cases := []struct {
+		query    map[string][]string
+		expected MetadataPolicy
+	}{
+		{map[string][]string{}, metadataPolicy},
+		{map[string][]string{"keep": {"ICC, copyright"}}, MetadataPolicy{Keep: []string{"icc", "copyright"}}},
+		{map[string][]string{"stripmeta": {"false"}}, MetadataPolicy{}},
+		{map[string][]string{"stripmeta": {"true"}}, MetadataPolicy{Keep: []string{}}},
+		{map[string][]string{"stripmeta": {"true"}, "keep": {"icc"}}, MetadataPolicy{Keep: []string{"icc"}}},
+	}
+
+	for _, c := range cases {
+		io, err := buildParamsFromQuery(c.query)
+		if err != nil {
+			t.Fatalf("Failed reading params, %s", err)
+		}
+
+		policy := resolveMetadataPolicy(io)
+		if (policy.Keep == nil) != (c.expected.Keep == nil) || len(policy.Keep) != len(c.expected.Keep) || len(policy.Strip) != len(c.expected.Strip) {
+			t.Errorf("Invalid metadata policy for %v: %#v", c.query, policy)
+		}
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"strip": {"gps,thumbnail"}}); err == nil {
+		t.Error("Expected an error for an unsupported metadata group")
+	}
+}
+
+func TestFilterMalformedMetadata(t *testing.T) {
+	policy := MetadataPolicy{Keep: []string{MetadataCopyright}}
+
+	// Must never panic
+	for _, buf := range testMetadataContainers() {
+		for i := 0; i < len(buf); i++ {
+			_, _ = filterMetadata(buf[:i], policy)
+		}
+	}
+	exif := readMetadata(testMetadataContainers()["jpeg"]).EXIF
+	for i := 0; i < len(exif); i++ {
+		filterEXIF(exif[:i], policy)
+		filterXMP([]byte(testGPSXMP[:i%len(testGPSXMP)]), policy)
+	}
+}
+
+// newTestGPSJPEG returns the imaginary.jpg fixture tagged with a GPS location in its EXIF and XMP metadata.
+func newTestGPSJPEG() []byte {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	segments, start, err := readJPEGSegments(buf)
+	if err != nil {
+		return nil
+	}
+
+	exif := newTestEXIF(
+		[]testTIFFField{asciiField(0x010f, "Imaginary"), shortField(0x0112, 6)},
+		nil,
+		[]testTIFFField{
+			asciiField(gpsLatitudeRef, "S"),
+			rationalField(gpsLatitude, 33, 1, 51, 1, 36, 1),
+			asciiField(gpsLongitudeRef, "E"),
+			rationalField(gpsLongitude, 151, 1, 12, 1, 3600, 100),
+		},
+	)
+
+	tagged := []jpegSegment{
+		{Marker: jpegAPP1, Data: append([]byte(jpegEXIFSignature), exif...)},
+		{Marker: jpegAPP1, Data: append([]byte(jpegXMPSignature), testGPSXMP...)},
+	}
+	for _, segment := range segments {
+		if segment.Marker != jpegAPP1 {
+			tagged = append(tagged, segment)
+		}
+	}
+	return writeJPEGSegments(tagged, buf[start:])
+}
+
+func TestProcessStripGPS(t *testing.T) {
+	buf := newTestGPSJPEG()
+	var original ImageInfo
+	addMetadataGroups(&original, readMetadata(buf), nil)
+	if original.GPS == nil || original.EXIF["Make"] != "Imaginary" || original.XMP["xmp:Rating"] != "4" {
+		t.Fatalf("Invalid GPS tagged fixture: %#v", original.EXIF)
+	}
+
+	operations := map[string]Operation{"autorotate": AutoRotate, "resize": Resize}
+	for name, operation := range operations {
+		for _, imageType := range []string{"jpeg", "png", "webp"} {
+			opts, err := buildParamsFromQuery(map[string][]string{"type": {imageType}, "width": {"300"}, "strip": {"gps"}})
+			if err != nil {
+				t.Fatalf("Failed reading params, %s", err)
+			}
+
+			img, err := operation(buf, opts)
+			if err != nil {
+				t.Fatalf("Cannot process image via %s to %s: %s", name, imageType, err)
+			}
+			if img.Mime != "image/"+imageType {
+				t.Errorf("Invalid %s image MIME type: %s", imageType, img.Mime)
+			}
+
+			var info ImageInfo
+			addMetadataGroups(&info, readMetadata(img.Body), nil)
+			if info.GPS != nil {
+				t.Errorf("GPS location not removed by %s from %s: %#v", name, imageType, info.GPS)
+			}
+			if _, ok := info.XMP["exif:GPSLatitude"]; ok {
+				t.Errorf("XMP GPS latitude not removed by %s from %s: %#v", name, imageType, info.XMP)
+			}
+			if info.XMP["xmp:Rating"] != "4" {
+				t.Errorf("XMP properties not kept by %s in %s: %#v", name, imageType, info.XMP)
+			}
+			// libvips may not write the EXIF metadata of PNG images, depending on its version
+			if imageType != "png" && info.EXIF["Make"] != "Imaginary" {
+				t.Errorf("EXIF fields not kept by %s in %s: %#v", name, imageType, info.EXIF)
+			}
+		}
+	}
+}
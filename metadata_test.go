@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// testTIFFField represents a field of a test TIFF directory.
+type testTIFFField struct {
+	Tag   uint16
+	Type  uint16
+	Count int
+	Value []byte
+}
+
+func asciiField(tag uint16, value string) testTIFFField {
+	return testTIFFField{Tag: tag, Type: tiffASCII, Count: len(value) + 1, Value: append([]byte(value), 0)}
+}
+
+func shortField(tag uint16, value uint16) testTIFFField {
+	v := make([]byte, 2)
+	binary.BigEndian.PutUint16(v, value)
+	return testTIFFField{Tag: tag, Type: tiffShort, Count: 1, Value: v}
+}
+
+func rationalField(tag uint16, values ...uint32) testTIFFField {
+	v := make([]byte, len(values)*4)
+	for i, value := range values {
+		binary.BigEndian.PutUint32(v[i*4:], value)
+	}
+	return testTIFFField{Tag: tag, Type: tiffRational, Count: len(values) / 2, Value: v}
+}
+
+// newTestEXIF returns big endian TIFF structured EXIF data with the given IFD0,
+// EXIF and GPS directories fields. Empty sub-directories are omitted.
+func newTestEXIF(ifd0, exif, gps []testTIFFField) []byte {
+	buf := []byte("MM\x00*\x00\x00\x00\x08")
+
+	directorySize := func(fields []testTIFFField) int {
+		size := 2 + len(fields)*12 + 4
+		for _, field := range fields {
+			if len(field.Value) > 4 {
+				size += len(field.Value) + len(field.Value)%2
+			}
+		}
+		return size
+	}
+
+	pointers := 0
+	if len(exif) > 0 {
+		pointers++
+	}
+	if len(gps) > 0 {
+		pointers++
+	}
+
+	offset := 8 + directorySize(ifd0) + pointers*12
+	ifd0 = append([]testTIFFField(nil), ifd0...)
+	if len(exif) > 0 {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, uint32(offset))
+		ifd0 = append(ifd0, testTIFFField{Tag: exifIFDPointer, Type: tiffLong, Count: 1, Value: v})
+		offset += directorySize(exif)
+	}
+	if len(gps) > 0 {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, uint32(offset))
+		ifd0 = append(ifd0, testTIFFField{Tag: exifGPSPointer, Type: tiffLong, Count: 1, Value: v})
+	}
+
+	for _, fields := range [][]testTIFFField{ifd0, exif, gps} {
+		if len(fields) == 0 {
+			continue
+		}
+
+		data := len(buf) + 2 + len(fields)*12 + 4
+		var values []byte
+
+		directory := make([]byte, 2+len(fields)*12+4)
+		binary.BigEndian.PutUint16(directory, uint16(len(fields)))
+		for i, field := range fields {
+			entry := directory[2+i*12:]
+			binary.BigEndian.PutUint16(entry[0:], field.Tag)
+			binary.BigEndian.PutUint16(entry[2:], field.Type)
+			binary.BigEndian.PutUint32(entry[4:], uint32(field.Count))
+			if len(field.Value) <= 4 {
+				copy(entry[8:], field.Value)
+				continue
+			}
+			binary.BigEndian.PutUint32(entry[8:], uint32(data+len(values)))
+			values = append(values, field.Value...)
+			if len(field.Value)%2 == 1 {
+				values = append(values, 0)
+			}
+		}
+		buf = append(buf, directory...)
+		buf = append(buf, values...)
+	}
+
+	return buf
+}
+
+// newTestJPEG returns a JPEG image header with the given marker segments.
+func newTestJPEG(segments ...jpegSegment) []byte {
+	buf := []byte{0xff, jpegSOI}
+	for _, segment := range segments {
+		buf = append(buf, 0xff, segment.Marker, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(segment.Data)+2))
+		buf = append(buf, segment.Data...)
+	}
+	return append(buf, 0xff, jpegSOS, 0, 2, 0xff, jpegEOI)
+}
+
+// newTestICC returns an ICC profile with the given description.
+func newTestICC(description string) []byte {
+	buf := make([]byte, iccHeaderSize+4+12)
+	copy(buf[12:], "mntr")
+	copy(buf[16:], "RGB ")
+	copy(buf[36:], "acsp")
+	buf[8], buf[9] = 2, 0x10
+
+	tag := append([]byte("desc\x00\x00\x00\x00\x00\x00\x00\x00"), description...)
+	binary.BigEndian.PutUint32(tag[8:], uint32(len(description)))
+
+	binary.BigEndian.PutUint32(buf[iccHeaderSize:], 1)
+	copy(buf[iccHeaderSize+4:], "desc")
+	binary.BigEndian.PutUint32(buf[iccHeaderSize+8:], uint32(len(buf)))
+	binary.BigEndian.PutUint32(buf[iccHeaderSize+12:], uint32(len(tag)))
+	return append(buf, tag...)
+}
+
+// newTestIPTC returns Photoshop image resources with the given IPTC application record datasets.
+func newTestIPTC(datasets map[byte][]string) []byte {
+	var iim []byte
+	for dataset, values := range datasets {
+		for _, value := range values {
+			iim = append(iim, iptcMarker, iptcApplicationRecord, dataset, 0, 0)
+			binary.BigEndian.PutUint16(iim[len(iim)-2:], uint16(len(value)))
+			iim = append(iim, value...)
+		}
+	}
+
+	buf := []byte("8BIM\x04\x04\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(iim)))
+	return append(buf, iim...)
+}
+
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmp:Rating="4">
+   <dc:creator><rdf:Seq><rdf:li>Jane Doe</rdf:li></rdf:Seq></dc:creator>
+   <dc:rights><rdf:Alt><rdf:li xml:lang="es">Derechos</rdf:li><rdf:li xml:lang="x-default">All rights reserved</rdf:li></rdf:Alt></dc:rights>
+   <dc:subject><rdf:Bag><rdf:li>sea</rdf:li><rdf:li>boat</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func newTestMetadataJPEG() []byte {
+	exif := newTestEXIF(
+		[]testTIFFField{
+			asciiField(0x010f, "Imaginary"),
+			shortField(0x0112, 6),
+			rationalField(tiffXResolutionTag, 300, 1),
+			rationalField(tiffYResolutionTag, 300, 1),
+			asciiField(0x8298, "(c) Jane Doe"),
+		},
+		[]testTIFFField{
+			asciiField(0x9003, "2020:01:02 03:04:05"),
+			rationalField(0x829d, 28, 10),
+			{Tag: 0x927c, Type: tiffUndefined, Count: 6, Value: []byte{0, 1, 2, 3, 4, 5}},
+		},
+		[]testTIFFField{
+			asciiField(gpsLatitudeRef, "S"),
+			rationalField(gpsLatitude, 33, 1, 51, 1, 36, 1),
+			asciiField(gpsLongitudeRef, "E"),
+			rationalField(gpsLongitude, 151, 1, 12, 1, 3600, 100),
+			rationalField(gpsAltitude, 105, 2),
+			asciiField(gpsDateStamp, "2020:01:02"),
+			rationalField(gpsTimeStamp, 3, 1, 4, 1, 5, 1),
+		},
+	)
+
+	return newTestJPEG(
+		jpegSegment{Marker: jpegAPP1, Data: append([]byte(jpegEXIFSignature), exif...)},
+		jpegSegment{Marker: jpegAPP1, Data: append([]byte(jpegXMPSignature), testXMP...)},
+		jpegSegment{Marker: jpegAPPD, Data: append([]byte(jpegPhotoshopSignature), newTestIPTC(map[byte][]string{
+			25:  {"sea", "boat"},
+			116: {"(c) Jane Doe"},
+		})...)},
+		jpegSegment{Marker: jpegAPP2, Data: append([]byte(jpegICCSignature+"\x01\x01"), newTestICC("sRGB IEC61966-2.1")...)},
+		jpegSegment{Marker: 0xc2, Data: []byte{8, 0, 1, 0, 1, 1, 1, 0x11, 0}},
+	)
+}
+
+func TestReadJPEGMetadata(t *testing.T) {
+	meta := readMetadata(newTestMetadataJPEG())
+
+	if meta.BitDepth != 8 || !meta.Progressive {
+		t.Errorf("Invalid JPEG encoding details: %d bits, progressive %t", meta.BitDepth, meta.Progressive)
+	}
+	if meta.DPI == nil || meta.DPI.X != 300 || meta.DPI.Y != 300 {
+		t.Errorf("Invalid JPEG resolution: %#v", meta.DPI)
+	}
+
+	var info ImageInfo
+	addMetadataGroups(&info, meta, nil)
+
+	body, _ := json.Marshal(info)
+	for _, expected := range []string{
+		`"exif":{"Copyright":"(c) Jane Doe","DateTimeOriginal":"2020:01:02 03:04:05","FNumber":2.8,"Make":"Imaginary","Orientation":6,"XResolution":300,"YResolution":300}`,
+		`"gps":{"latitude":-33.86,"longitude":151.21,"altitude":52.5,"timestamp":"2020-01-02T03:04:05Z"}`,
+		`"iptc":{"CopyrightNotice":"(c) Jane Doe","Keywords":["sea","boat"]}`,
+		`"xmp":{"dc:creator":["Jane Doe"],"dc:rights":"All rights reserved","dc:subject":["sea","boat"],"xmp:Rating":"4"}`,
+		`"icc":{"description":"sRGB IEC61966-2.1","colorSpace":"RGB","class":"display","version":"2.1"}`,
+	} {
+		if !bytes.Contains(body, []byte(expected)) {
+			t.Errorf("Missing metadata %s in %s", expected, body)
+		}
+	}
+}
+
+func TestMetadataGroups(t *testing.T) {
+	io, err := buildParamsFromQuery(map[string][]string{"groups": {"GPS, icc"}})
+	if err != nil {
+		t.Fatalf("Failed reading params, %s", err)
+	}
+
+	var info ImageInfo
+	addMetadataGroups(&info, readMetadata(newTestMetadataJPEG()), io.Groups)
+	if info.GPS == nil || info.ICC == nil || info.EXIF != nil || info.IPTC != nil || info.XMP != nil {
+		t.Errorf("Invalid metadata groups: %#v", info)
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"groups": {"exif,makernotes"}}); err == nil {
+		t.Error("Expected an error for an unsupported metadata group")
+	}
+}
+
+func TestReadPNGMetadata(t *testing.T) {
+	chunk := func(typ string, data []byte) []byte {
+		buf := make([]byte, 8, 12+len(data))
+		binary.BigEndian.PutUint32(buf, uint32(len(data)))
+		copy(buf[4:], typ)
+		return append(append(buf, data...), 0, 0, 0, 0)
+	}
+
+	phys := make([]byte, 9)
+	binary.BigEndian.PutUint32(phys[0:], 2835)
+	binary.BigEndian.PutUint32(phys[4:], 2835)
+	phys[8] = 1
+
+	buf := []byte(pngSignature)
+	buf = append(buf, chunk("IHDR", []byte{0, 0, 0, 1, 0, 0, 0, 1, 16, 2, 0, 0, 1})...)
+	buf = append(buf, chunk("pHYs", phys)...)
+	buf = append(buf, chunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), testXMP...))...)
+	buf = append(buf, chunk("eXIf", newTestEXIF([]testTIFFField{asciiField(0x013b, "Jane Doe")}, nil, nil))...)
+	buf = append(buf, chunk("IEND", nil)...)
+
+	meta := readMetadata(buf)
+	if meta.BitDepth != 16 || !meta.Progressive {
+		t.Errorf("Invalid PNG encoding details: %d bits, interlaced %t", meta.BitDepth, meta.Progressive)
+	}
+	if meta.DPI == nil || meta.DPI.X != 72.01 {
+		t.Errorf("Invalid PNG resolution: %#v", meta.DPI)
+	}
+
+	var info ImageInfo
+	addMetadataGroups(&info, meta, nil)
+	if info.EXIF["Artist"] != "Jane Doe" || info.XMP["xmp:Rating"] != "4" || info.GPS != nil {
+		t.Errorf("Invalid PNG metadata: %#v, %#v", info.EXIF, info.XMP)
+	}
+}
+
+func TestReadMalformedMetadata(t *testing.T) {
+	buf := newTestMetadataJPEG()
+	meta := readMetadata(buf)
+
+	// Must never panic
+	for i := 0; i < len(buf); i++ {
+		var info ImageInfo
+		addMetadataGroups(&info, readMetadata(buf[:i]), nil)
+	}
+	for i := 0; i < len(meta.EXIF); i++ {
+		var info ImageInfo
+		addMetadataGroups(&info, imageMetadata{EXIF: meta.EXIF[:i], ICC: meta.ICC[:i%len(meta.ICC)], XMP: meta.XMP[:i%len(meta.XMP)]}, nil)
+	}
+}
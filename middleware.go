@@ -39,14 +39,18 @@ func Middleware(fn func(http.ResponseWriter, *http.Request), o ServerOptions) ht
 
 func ImageMiddleware(o ServerOptions) func(Operation) http.Handler {
 	return func(fn Operation) http.Handler {
-		handler := validateImage(Middleware(imageController(o, fn), o), o)
+		return imageMiddleware(imageController(o, fn), o)
+	}
+}
 
-		if o.EnableURLSignature {
-			return validateURLSignature(handler, o)
-		}
+func imageMiddleware(fn func(http.ResponseWriter, *http.Request), o ServerOptions) http.Handler {
+	handler := validateImage(Middleware(fn, o), o)
 
-		return handler
+	if o.EnableURLSignature {
+		return validateURLSignature(handler, o)
 	}
+
+	return handler
 }
 
 func filterEndpoint(next http.Handler, o ServerOptions) http.Handler {
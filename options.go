@@ -16,8 +16,10 @@ type ImageOptions struct {
 	AreaWidth     int
 	AreaHeight    int
 	Quality       int
+	AutoQuality   bool
+	QualityTarget string
 	Compression   int
-	Rotate        int
+	Rotate        float64
 	Top           int
 	Left          int
 	Margin        int
@@ -45,6 +47,58 @@ type ImageOptions struct {
 	Background    []uint8
 	Interlace     bool
 	Speed         int
+	Colors        int
+	Dither        float64
+	QuantQuality  int
+	PaletteAuto   bool
+	FocalX        float64
+	FocalY        float64
+	TextAngle     float64
+	OffsetX       int
+	OffsetY       int
+	StrokeWidth   int
+	StrokeColor   []uint8
+	ShadowX       int
+	ShadowY       int
+	ShadowBlur    float64
+	ShadowColor   []uint8
+	Watermark     string
+	Scale         float64
+	Replicate     bool
+	Page          int
+	Pages         int
+	Density       float64
+	Columns       int
+	Spacing       int
+	Layout        string
+	Fit           string
+	Hash          bool
+	Output        string
+	Groups        []string
+	Keep          []string
+	Strip         []string
+	OutputProfile string
+	DPR           float64
+	MaxBytes      int
+	Shrink        bool
+	Download      bool
+	Filename      string
+	Encoder       EncoderOptions
+	Filter        string
+	LUT           string
+	Intensity     float64
+	Shadows       []uint8
+	Highlights    []uint8
+	Regions       []RedactRegion
+	Mode          string
+	Relative      bool
+	BlockSize     int
+	RotateCrop    bool
+	Padding       [4]PadMargin
+	BorderWidth   PadMargin
+	BorderColor   []uint8
+	Darken        float64
+	NoEnlargement bool
 	Extend        bimg.Extend
 	Gravity       bimg.Gravity
 	Colorspace    bimg.Interpretation
@@ -65,8 +119,23 @@ type IsDefinedField struct {
 	StripMetadata bool
 	Interlace     bool
 	Palette       bool
+	Dither        bool
+	FocalX        bool
+	FocalY        bool
+	Gravity       bool
+	Page          bool
+	Extend        bool
 }
 
+// Compass gravities not natively supported by bimg.
+// They're handled by imaginary as focal points at the image corners.
+const (
+	GravityNorthEast bimg.Gravity = bimg.GravitySmart + 1 + iota
+	GravitySouthEast
+	GravitySouthWest
+	GravityNorthWest
+)
+
 // PipelineOperation represents the structure for an operation field.
 type PipelineOperation struct {
 	Name          string                 `json:"operation"`
@@ -142,12 +211,17 @@ func BimgOptions(o ImageOptions) bimg.Options {
 		Interpretation: o.Colorspace,
 		StripMetadata:  o.StripMetadata,
 		Type:           ImageType(o.Type),
-		Rotate:         bimg.Angle(o.Rotate),
+		Rotate:         bimg.Angle(normalizeAngle(o.Rotate)),
 		Interlace:      o.Interlace,
 		Palette:        o.Palette,
 		Speed:          o.Speed,
 	}
 
+	if o.Extend == ExtendBlur {
+		// Only supported by the resize and fit operations, extending the image with the default mode otherwise
+		opts.Extend = bimg.ExtendCopy
+	}
+
 	if len(o.Background) != 0 {
 		opts.Background = bimg.Color{R: o.Background[0], G: o.Background[1], B: o.Background[2]}
 	}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// padMaxMargin defines the max margin in pixels added to each side of the image.
+	padMaxMargin = 5000
+	// padMaxPercent defines the max margin in percent of the image size.
+	padMaxPercent = 100
+)
+
+// PadMargin represents a margin added to a side of the image, in pixels
+// or in percent of the image size if Percent is true.
+type PadMargin struct {
+	Value   float64
+	Percent bool
+}
+
+// pixels returns the margin in pixels for the given image dimension.
+func (m PadMargin) pixels(size int) int {
+	if m.Percent {
+		return int(math.Round(m.Value * float64(size) / 100))
+	}
+	return int(math.Round(m.Value))
+}
+
+// Pad adds the given top, right, bottom and left margins to the image, filling them with the
+// background colour, being transparent by default, or white for JPEG output images.
+// If the extend param is defined, the margins are filled with the given extend mode instead.
+func Pad(buf []byte, o ImageOptions) (Image, error) {
+	if o.Padding == ([4]PadMargin{}) {
+		return Image{}, NewError("Missing required param: padding", http.StatusBadRequest)
+	}
+
+	base, img, err := decodeRaster(buf, o.NoRotation)
+	if err != nil {
+		return Image{}, err
+	}
+
+	opts := BimgOptions(o)
+	opts.Type = rasterOutputType(buf, o)
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	margins := [4]int{
+		o.Padding[0].pixels(height),
+		o.Padding[1].pixels(width),
+		o.Padding[2].pixels(height),
+		o.Padding[3].pixels(width),
+	}
+
+	var padded *image.NRGBA
+	if o.IsDefinedField.Extend {
+		padded, err = extendRaster(img, margins, o.Extend, o.Background)
+	} else {
+		padded, err = padRaster(img, margins, fillColor(o, opts.Type))
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	return processRaster(base, padded, opts, o)
+}
+
+// Border adds a border of the given width and colour, being black by default, around the image.
+// The border width in percent is relative to the smallest image dimension.
+func Border(buf []byte, o ImageOptions) (Image, error) {
+	if o.BorderWidth.Value == 0 {
+		return Image{}, NewError("Missing required param: borderwidth", http.StatusBadRequest)
+	}
+
+	base, img, err := decodeRaster(buf, o.NoRotation)
+	if err != nil {
+		return Image{}, err
+	}
+
+	opts := BimgOptions(o)
+	opts.Type = rasterOutputType(buf, o)
+
+	border := color.NRGBA{A: 255}
+	switch {
+	case len(o.BorderColor) > 3:
+		border = color.NRGBA{R: o.BorderColor[0], G: o.BorderColor[1], B: o.BorderColor[2], A: o.BorderColor[3]}
+	case len(o.BorderColor) > 2:
+		border = color.NRGBA{R: o.BorderColor[0], G: o.BorderColor[1], B: o.BorderColor[2], A: 255}
+	}
+
+	size := o.BorderWidth.pixels(int(math.Min(float64(img.Bounds().Dx()), float64(img.Bounds().Dy()))))
+	if size == 0 {
+		size = 1
+	}
+	bordered, err := padRaster(img, [4]int{size, size, size, size}, border)
+	if err != nil {
+		return Image{}, err
+	}
+	return processRaster(base, bordered, opts, o)
+}
+
+// padRaster adds the top, right, bottom and left margins to the image, filled with the given colour.
+// The padded image size must not exceed the max resolution of the rasterised images.
+func padRaster(img *image.NRGBA, margins [4]int, fill color.NRGBA) (*image.NRGBA, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	outWidth, outHeight := width+margins[1]+margins[3], height+margins[0]+margins[2]
+	if err := checkRasterResolution(outWidth, outHeight); err != nil {
+		return nil, err
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, outWidth, outHeight))
+	draw.Draw(out, out.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	draw.Draw(out, image.Rect(margins[3], margins[0], margins[3]+width, margins[0]+height), img, img.Bounds().Min, draw.Src)
+	return out, nil
+}
+
+// extendRaster adds the top, right, bottom and left margins to the image, filled based on the
+// extend mode: a solid colour for black, white and background, the edge pixels for copy and
+// lastpixel, or the mirrored image for mirror.
+// The extended image size must not exceed the max resolution of the rasterised images.
+func extendRaster(img *image.NRGBA, margins [4]int, mode bimg.Extend, background []uint8) (*image.NRGBA, error) {
+	switch mode {
+	case bimg.ExtendBlack:
+		return padRaster(img, margins, color.NRGBA{A: 255})
+	case bimg.ExtendWhite:
+		return padRaster(img, margins, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	case bimg.ExtendBackground:
+		fill := color.NRGBA{A: 255}
+		if len(background) > 2 {
+			fill = color.NRGBA{R: background[0], G: background[1], B: background[2], A: 255}
+		}
+		return padRaster(img, margins, fill)
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	outWidth, outHeight := width+margins[1]+margins[3], height+margins[0]+margins[2]
+	if err := checkRasterResolution(outWidth, outHeight); err != nil {
+		return nil, err
+	}
+
+	source := func(v, size int) int {
+		if mode != bimg.ExtendMirror {
+			return clampInt(v, 0, size-1)
+		}
+		// Mirror the image, repeating it with a period of twice its size
+		v %= 2 * size
+		if v < 0 {
+			v += 2 * size
+		}
+		if v >= size {
+			v = 2*size - v - 1
+		}
+		return v
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, outWidth, outHeight))
+	for y := 0; y < out.Bounds().Dy(); y++ {
+		sy := source(y-margins[0], height)
+		for x := 0; x < out.Bounds().Dx(); x++ {
+			out.SetNRGBA(x, y, img.NRGBAAt(source(x-margins[3], width), sy))
+		}
+	}
+	return out, nil
+}
+
+// parsePadMargin parses a margin in pixels, or in percent if suffixed by %.
+func parsePadMargin(val string) (PadMargin, error) {
+	val = strings.TrimSpace(val)
+	margin := PadMargin{Percent: strings.HasSuffix(val, "%")}
+
+	value, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+	if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return PadMargin{}, ErrUnsupportedValue
+	}
+	if (margin.Percent && value > padMaxPercent) || (!margin.Percent && value > padMaxMargin) {
+		return PadMargin{}, ErrUnsupportedValue
+	}
+
+	margin.Value = value
+	return margin, nil
+}
+
+// parsePadding parses the padding param, being a comma separated list of 1 to 4 margins
+// in the top, right, bottom and left order, expanded such as the CSS padding property.
+// Example: 10,5% or 10,20,30,40
+func parsePadding(val string) ([4]PadMargin, error) {
+	var padding [4]PadMargin
+	parts := strings.Split(val, ",")
+	if len(parts) > 4 {
+		return padding, ErrUnsupportedValue
+	}
+
+	margins := make([]PadMargin, len(parts))
+	for i, part := range parts {
+		margin, err := parsePadMargin(part)
+		if err != nil {
+			return padding, err
+		}
+		margins[i] = margin
+	}
+
+	switch len(margins) {
+	case 1:
+		padding = [4]PadMargin{margins[0], margins[0], margins[0], margins[0]}
+	case 2:
+		padding = [4]PadMargin{margins[0], margins[1], margins[0], margins[1]}
+	case 3:
+		padding = [4]PadMargin{margins[0], margins[1], margins[2], margins[1]}
+	case 4:
+		padding = [4]PadMargin{margins[0], margins[1], margins[2], margins[3]}
+	}
+	return padding, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestPad(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"padding": {"10,20"}, "background": {"255,0,0"}})
+	img, err := Pad(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	size, _ := bimg.Size(img.Body)
+	source, _ := bimg.Size(buf)
+	if size.Width != source.Width+40 || size.Height != source.Height+20 {
+		t.Errorf("Invalid padded size: %dx%d", size.Width, size.Height)
+	}
+
+	if _, err := Pad(buf, ImageOptions{}); err == nil {
+		t.Error("Expected an error for missing padding")
+	}
+	if _, err := Border(buf, ImageOptions{}); err == nil {
+		t.Error("Expected an error for missing border width")
+	}
+}
+
+func TestPadMaxResolution(t *testing.T) {
+	defer func(pixels float64) { rasterMaxPixels = pixels }(rasterMaxPixels)
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	source, _ := bimg.Size(buf)
+
+	// The padded image is 9 times bigger than the image
+	rasterMaxPixels = float64(source.Width*source.Height) * 8 / 1000000
+	opts, _ := buildParamsFromQuery(map[string][]string{"padding": {"100%"}})
+	if _, err := Pad(buf, opts); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution too big error, got: %v", err)
+	}
+
+	opts, _ = buildParamsFromQuery(map[string][]string{"borderwidth": {"5000"}})
+	if _, err := Border(buf, opts); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution too big error for the border, got: %v", err)
+	}
+}
+
+func TestPadRaster(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for x := 0; x < 3; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{R: uint8(x), A: 255})
+		src.SetNRGBA(x, 1, color.NRGBA{R: uint8(x), G: 1, A: 255})
+	}
+	margins := [4]int{1, 2, 3, 4}
+
+	out, err := padRaster(src, margins, color.NRGBA{B: 255, A: 128})
+	if err != nil {
+		t.Fatalf("Cannot pad raster: %s", err)
+	}
+	if out.Bounds().Dx() != 9 || out.Bounds().Dy() != 6 {
+		t.Fatalf("Invalid padded size: %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(0, 0); c != (color.NRGBA{B: 255, A: 128}) {
+		t.Errorf("Invalid margin colour: %v", c)
+	}
+	if c := out.NRGBAAt(6, 2); c != src.NRGBAAt(2, 1) {
+		t.Errorf("Invalid image colour: %v", c)
+	}
+
+	cases := []struct {
+		mode     bimg.Extend
+		point    image.Point
+		expected color.NRGBA
+	}{
+		{bimg.ExtendBlack, image.Point{0, 0}, color.NRGBA{A: 255}},
+		{bimg.ExtendWhite, image.Point{8, 5}, color.NRGBA{255, 255, 255, 255}},
+		{bimg.ExtendBackground, image.Point{8, 5}, color.NRGBA{10, 20, 30, 255}},
+		{bimg.ExtendCopy, image.Point{0, 0}, color.NRGBA{A: 255}},
+		{bimg.ExtendLast, image.Point{8, 5}, color.NRGBA{2, 1, 0, 255}},
+		// Left margin of 4 pixels mirrors the columns 0, 1, 2, 2
+		{bimg.ExtendMirror, image.Point{0, 1}, color.NRGBA{2, 0, 0, 255}},
+		{bimg.ExtendMirror, image.Point{3, 1}, color.NRGBA{0, 0, 0, 255}},
+		// Bottom margin of 3 pixels mirrors the rows 1, 0, 0
+		{bimg.ExtendMirror, image.Point{4, 5}, color.NRGBA{0, 0, 0, 255}},
+		{bimg.ExtendMirror, image.Point{4, 3}, color.NRGBA{0, 1, 0, 255}},
+	}
+	for _, tc := range cases {
+		out, err := extendRaster(src, margins, tc.mode, []uint8{10, 20, 30})
+		if err != nil {
+			t.Fatalf("Cannot extend raster: %s", err)
+		}
+		if out.Bounds().Dx() != 9 || out.Bounds().Dy() != 6 {
+			t.Fatalf("Invalid extended size: %v", out.Bounds())
+		}
+		if c := out.NRGBAAt(tc.point.X, tc.point.Y); c != tc.expected {
+			t.Errorf("Invalid extend mode %d colour at %v: %v != %v", tc.mode, tc.point, c, tc.expected)
+		}
+	}
+
+	// The 9x6 output image size is checked before allocating it
+	defer func(pixels float64) { rasterMaxPixels = pixels }(rasterMaxPixels)
+	rasterMaxPixels = 50e-6
+	if _, err := padRaster(src, margins, color.NRGBA{}); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution too big error, got: %v", err)
+	}
+	for _, mode := range []bimg.Extend{bimg.ExtendWhite, bimg.ExtendMirror} {
+		if _, err := extendRaster(src, margins, mode, nil); err != ErrResolutionTooBig {
+			t.Errorf("Expected a resolution too big error for extend mode %d, got: %v", mode, err)
+		}
+	}
+}
+
+func TestPadParams(t *testing.T) {
+	cases := map[string][4]PadMargin{
+		"10":             {{10, false}, {10, false}, {10, false}, {10, false}},
+		"10,5%":          {{10, false}, {5, true}, {10, false}, {5, true}},
+		"1,2,3":          {{1, false}, {2, false}, {3, false}, {2, false}},
+		" 1, 2, 3%, 4 ":  {{1, false}, {2, false}, {3, true}, {4, false}},
+		"0,0,0,12.5%":    {{0, false}, {0, false}, {0, false}, {12.5, true}},
+		"5000,100%,0,0%": {{5000, false}, {100, true}, {0, false}, {0, true}},
+	}
+	for value, expected := range cases {
+		opts, err := buildParamsFromQuery(map[string][]string{"padding": {value}})
+		if err != nil {
+			t.Fatalf("Failed reading padding %q: %s", value, err)
+		}
+		if opts.Padding != expected {
+			t.Errorf("Invalid padding for %q: %v", value, opts.Padding)
+		}
+	}
+
+	for _, value := range []string{"", "1,2,3,4,5", "-10", "10px", "101%", "5001", "NaN", "10,,10"} {
+		if _, err := buildParamsFromQuery(map[string][]string{"padding": {value}}); err == nil {
+			t.Errorf("Expected an error for padding=%s", value)
+		}
+	}
+
+	opts, err := buildParamsFromQuery(map[string][]string{"borderwidth": {"5%"}, "bordercolor": {"255,0,0,128"}, "extend": {"white"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+	if opts.BorderWidth != (PadMargin{5, true}) || len(opts.BorderColor) != 4 || !opts.IsDefinedField.Extend {
+		t.Errorf("Invalid border params: %v, %v", opts.BorderWidth, opts.BorderColor)
+	}
+	if margin := opts.BorderWidth.pixels(300); margin != 15 {
+		t.Errorf("Invalid border width: %d", margin)
+	}
+
+	operation := PipelineOperation{Params: map[string]interface{}{"padding": 8.0, "borderwidth": 2.0}}
+	opts, err = buildParamsFromOperation(operation)
+	if err != nil || opts.Padding[3] != (PadMargin{8, false}) || opts.BorderWidth != (PadMargin{2, false}) {
+		t.Errorf("Invalid operation params: %v, %v, %v", opts.Padding, opts.BorderWidth, err)
+	}
+	if opts.IsDefinedField.Extend {
+		t.Error("The extend mode must not be defined by default")
+	}
+}
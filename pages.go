@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+	"github.com/h2non/imaginary/internal/vips"
+)
+
+const (
+	// vectorDensity defines the default density in DPI libvips rasterises vector images at.
+	vectorDensity = 72
+	// maxSelectedPages defines the max number of pages or frames selected via the pages param.
+	maxSelectedPages = 50
+)
+
+var (
+	ErrPageOutOfRange      = NewError("Requested page is out of range", http.StatusBadRequest)
+	ErrDensityNotSupported = NewError("Density is only supported for SVG and PDF images", http.StatusUnprocessableEntity)
+)
+
+// preparePages selects the requested pages of multi-page TIFF and PDF images or frames of
+// animated images, and scales SVG and PDF images to be rasterised at the requested density.
+// Pages stacked into a single image must not exceed the max allowed resolution in megapixels.
+func preparePages(buf []byte, o ImageOptions, maxAllowedPixels float64) ([]byte, error) {
+	imageType := bimg.DetermineImageType(buf)
+
+	if imageType == bimg.PDF {
+		if o.IsDefinedField.Page || o.Pages > 0 || o.Density > 0 {
+			return selectPDFPages(buf, o.Page, o.Pages, o.Density, maxAllowedPixels)
+		}
+		return buf, nil
+	}
+
+	if o.IsDefinedField.Page || o.Pages > 0 {
+		var err error
+		switch imageType {
+		case bimg.GIF, bimg.WEBP:
+			buf, err = selectFrames(buf, imageType, o.Page, o.Pages)
+		case bimg.TIFF:
+			buf, err = selectTIFFPages(buf, o.Page, o.Pages, maxAllowedPixels)
+		default:
+			if o.Page > 0 {
+				err = ErrPageOutOfRange
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if imageType == bimg.SVG {
+		return scaleVector(buf, o)
+	}
+	if o.Density > 0 {
+		return nil, ErrDensityNotSupported
+	}
+
+	return buf, nil
+}
+
+// selectFrames returns the requested frames of an animated image, encoded in its original format.
+// Static images are handled as single frame animations.
+func selectFrames(buf []byte, imageType bimg.ImageType, page, pages int) ([]byte, error) {
+	count := countPages(buf)
+	if page >= count {
+		return nil, ErrPageOutOfRange
+	}
+	end := page + int(math.Max(float64(pages), 1))
+	if end > count {
+		end = count
+	}
+
+	// Decode the frames up to the last selected one only
+	anim, err := decodeAnimation(buf, imageType, end)
+	if err != nil {
+		return nil, err
+	}
+	if anim == nil {
+		return buf, nil
+	}
+
+	anim.Frames = anim.Frames[page:]
+	anim.Delays = anim.Delays[page:]
+
+	if imageType == bimg.GIF {
+		return encodeGIFAnimation(anim)
+	}
+
+	frames := make([][]byte, len(anim.Frames))
+	for i, frame := range anim.Frames {
+		var out bytes.Buffer
+		if err := png.Encode(&out, frame); err != nil {
+			return nil, err
+		}
+		if frames[i], err = bimg.Resize(out.Bytes(), bimg.Options{Type: bimg.WEBP, Lossless: true}); err != nil {
+			return nil, err
+		}
+	}
+	if len(frames) == 1 {
+		return frames[0], nil
+	}
+
+	bounds := anim.Frames[0].Bounds()
+	return muxWebPAnimation(frames, bounds.Dx(), bounds.Dy(), anim.Delays, anim.LoopCount)
+}
+
+// selectTIFFPages returns the requested pages of a TIFF image.
+// Multiple pages are stacked vertically into a single PNG image.
+func selectTIFFPages(buf []byte, page, pages int, maxAllowedPixels float64) ([]byte, error) {
+	_, offsets, err := tiffPageOffsets(buf)
+	if err != nil {
+		return nil, err
+	}
+	if page >= len(offsets) {
+		return nil, ErrPageOutOfRange
+	}
+	if pages <= 1 {
+		return extractTIFFPage(buf, page)
+	}
+
+	end := page + pages
+	if end > len(offsets) {
+		end = len(offsets)
+	}
+
+	// Check the size of the stacked pages before rasterising them
+	var pageBufs [][]byte
+	width, height := 0, 0
+	for i := page; i < end; i++ {
+		pageBuf, err := extractTIFFPage(buf, i)
+		if err != nil {
+			return nil, err
+		}
+		size, err := bimg.Size(pageBuf)
+		if err != nil {
+			return nil, err
+		}
+		pageBufs = append(pageBufs, pageBuf)
+		width = int(math.Max(float64(width), float64(size.Width)))
+		height += size.Height
+	}
+	if err := checkPagesResolution(width, height, maxAllowedPixels); err != nil {
+		return nil, err
+	}
+
+	var images []image.Image
+	var bounds image.Rectangle
+	for _, pageBuf := range pageBufs {
+		img, err := rasterize(pageBuf, 0)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+
+		if img.Bounds().Dx() > bounds.Dx() {
+			bounds.Max.X = img.Bounds().Dx()
+		}
+		bounds.Max.Y += img.Bounds().Dy()
+	}
+
+	canvas := image.NewNRGBA(bounds)
+	top := 0
+	for _, img := range images {
+		r := image.Rect(0, top, img.Bounds().Dx(), top+img.Bounds().Dy())
+		draw.Draw(canvas, r, img, img.Bounds().Min, draw.Src)
+		top += img.Bounds().Dy()
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, canvas); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// selectPDFPages renders the requested pages of a PDF document at the given density
+// or, by default, at the libvips default density. Multiple pages are stacked vertically.
+func selectPDFPages(buf []byte, page, pages int, density, maxAllowedPixels float64) ([]byte, error) {
+	if density == 0 {
+		density = vectorDensity
+	}
+
+	doc, err := vips.PDFInfo(buf, 0, 1, density)
+	if err != nil {
+		return nil, err
+	}
+	if page >= doc.Pages {
+		return nil, ErrPageOutOfRange
+	}
+
+	n := int(math.Max(float64(pages), 1))
+	if page+n > doc.Pages {
+		n = doc.Pages - page
+	}
+
+	// Check the size of the stacked pages before rendering them
+	if doc, err = vips.PDFInfo(buf, page, n, density); err != nil {
+		return nil, err
+	}
+	if err := checkPagesResolution(doc.Width, doc.Height, maxAllowedPixels); err != nil {
+		return nil, err
+	}
+	return vips.LoadPDF(buf, page, n, density)
+}
+
+// checkPagesResolution returns an error if the size of the stacked pages exceeds
+// the max allowed resolution in megapixels.
+func checkPagesResolution(width, height int, maxAllowedPixels float64) error {
+	if float64(width)*float64(height)/1000000 > maxAllowedPixels {
+		return ErrResolutionTooBig
+	}
+	return nil
+}
+
+// scaleVector scales the SVG image to the requested density or, by default,
+// to the density required to cover the requested output size.
+func scaleVector(buf []byte, o ImageOptions) ([]byte, error) {
+	factor := o.Density / vectorDensity
+	if o.Density == 0 {
+		if o.Width == 0 && o.Height == 0 {
+			return buf, nil
+		}
+
+		size, err := bimg.Size(buf)
+		if err != nil || size.Width == 0 || size.Height == 0 {
+			return buf, nil
+		}
+
+		factor = math.Max(float64(o.Width)/float64(size.Width), float64(o.Height)/float64(size.Height))
+	}
+
+	if factor <= 1 && o.Density == 0 {
+		return buf, nil
+	}
+
+	scaled, err := scaleSVG(buf, factor)
+	if err != nil {
+		// Fallback to the libvips default density
+		if o.Density == 0 {
+			return buf, nil
+		}
+		return nil, NewError("Cannot render the image at the requested density: "+err.Error(), http.StatusBadRequest)
+	}
+	return scaled, nil
+}
+
+// countPages returns the number of pages of TIFF and PDF images or frames of animated images.
+// It returns zero for PDF images if libvips cannot load them, while any other image
+// is considered to have a single page.
+func countPages(buf []byte) int {
+	switch bimg.DetermineImageType(buf) {
+	case bimg.PDF:
+		if doc, err := vips.PDFInfo(buf, 0, 1, vectorDensity); err == nil {
+			return doc.Pages
+		}
+		return 0
+	case bimg.GIF:
+		if ends, err := gifFrameEnds(buf); err == nil {
+			return len(ends)
+		}
+	case bimg.WEBP:
+		if anim, err := parseWebPAnimation(buf); err == nil && anim != nil {
+			return len(anim.Frames)
+		}
+	case bimg.TIFF:
+		if _, offsets, err := tiffPageOffsets(buf); err == nil {
+			return len(offsets)
+		}
+	}
+	return 1
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/h2non/bimg"
+	"github.com/h2non/imaginary/internal/vips"
+)
+
+// newTestTIFF returns a TIFF container with the given number of empty directories.
+func newTestTIFF(pages int) []byte {
+	buf := []byte("MM\x00*\x00\x00\x00\x08")
+	for i := 0; i < pages; i++ {
+		next := uint32(len(buf) + 2 + 12 + 4)
+		if i == pages-1 {
+			next = 0
+		}
+
+		ifd := make([]byte, 2+12+4)
+		binary.BigEndian.PutUint16(ifd[0:], 1)
+		binary.BigEndian.PutUint16(ifd[2:], 256) // ImageWidth tag
+		binary.BigEndian.PutUint32(ifd[14:], next)
+		buf = append(buf, ifd...)
+	}
+	return buf
+}
+
+func TestTIFFPages(t *testing.T) {
+	buf := newTestTIFF(3)
+
+	_, offsets, err := tiffPageOffsets(buf)
+	if err != nil {
+		t.Fatalf("Cannot read TIFF pages: %s", err)
+	}
+	if len(offsets) != 3 {
+		t.Fatalf("Invalid number of pages: %d", len(offsets))
+	}
+
+	page, err := extractTIFFPage(buf, 1)
+	if err != nil {
+		t.Fatalf("Cannot extract TIFF page: %s", err)
+	}
+	_, pageOffsets, err := tiffPageOffsets(page)
+	if err != nil {
+		t.Fatalf("Invalid extracted TIFF page: %s", err)
+	}
+	if len(pageOffsets) != 1 || pageOffsets[0] != offsets[1] {
+		t.Errorf("Invalid extracted TIFF page offsets: %v", pageOffsets)
+	}
+
+	if _, err := extractTIFFPage(buf, 3); err != ErrPageOutOfRange {
+		t.Errorf("Expected a page out of range error, got: %v", err)
+	}
+
+	// Directories must not be linked in loops
+	loop := newTestTIFF(2)
+	binary.BigEndian.PutUint32(loop[len(loop)-4:], 8)
+	if _, _, err := tiffPageOffsets(loop); err == nil {
+		t.Error("Expected an invalid TIFF error")
+	}
+}
+
+func TestSelectFrames(t *testing.T) {
+	buf, err := selectFrames(newTestGIF(0), bimg.GIF, 1, 2)
+	if err != nil {
+		t.Fatalf("Cannot select frames: %s", err)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("Invalid GIF image: %s", err)
+	}
+	if len(g.Image) != 2 || g.Delay[0] != 20 {
+		t.Errorf("Invalid selected frames: %d frames, %v delays", len(g.Image), g.Delay)
+	}
+
+	if _, err := selectFrames(newTestGIF(0), bimg.GIF, 3, 0); err != ErrPageOutOfRange {
+		t.Errorf("Expected a page out of range error, got: %v", err)
+	}
+
+	// The frames after the last selected one must not be decoded
+	defer func(limits AnimationLimits) { animationLimits = limits }(animationLimits)
+	animationLimits = AnimationLimits{MaxFrames: 2}
+	if _, err := selectFrames(newTestGIF(0), bimg.GIF, 0, 2); err != nil {
+		t.Errorf("Cannot select the first frames: %s", err)
+	}
+}
+
+func TestScaleSVG(t *testing.T) {
+	cases := []struct {
+		svg      string
+		expected string
+	}{
+		{`<svg width="100" height="50" viewBox="0 0 10 5">`, `<svg width="200" height="100" viewBox="0 0 10 5">`},
+		{`<svg xmlns="http://www.w3.org/2000/svg" width='1in' height='72pt'>`, `<svg width="192" height="192" viewBox="0 0 96 96" xmlns="http://www.w3.org/2000/svg">`},
+		{`<svg viewBox="0,0,40,20">`, `<svg width="80" height="40" viewBox="0,0,40,20">`},
+		{`<svg width="40" viewBox="0 0 40 20">`, `<svg width="80" height="40" viewBox="0 0 40 20">`},
+	}
+
+	for _, c := range cases {
+		out, err := scaleSVG([]byte(`<?xml version="1.0"?>`+c.svg+`<rect/></svg>`), 2)
+		if err != nil {
+			t.Errorf("Cannot scale SVG %s: %s", c.svg, err)
+			continue
+		}
+		if !strings.Contains(string(out), c.expected+`<rect/></svg>`) {
+			t.Errorf("Invalid scaled SVG: %s != %s", out, c.expected)
+		}
+	}
+
+	if _, err := scaleSVG([]byte(`<svg width="100%" height="100%">`), 2); err == nil {
+		t.Error("Expected an error for relative SVG sizes")
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	io, err := buildParamsFromQuery(url.Values{"page": []string{"0"}, "pages": []string{"3"}, "density": []string{"300"}})
+	if err != nil {
+		t.Fatalf("Failed reading params, %s", err)
+	}
+	if !io.IsDefinedField.Page || io.Page != 0 || io.Pages != 3 || io.Density != 300 {
+		t.Errorf("Invalid page params: %#v", io)
+	}
+
+	if _, err := buildParamsFromQuery(url.Values{"pages": []string{"51"}}); err == nil {
+		t.Error("Expected an error for pages=51")
+	}
+
+	// Pipeline numbers are not coerced to absolute values such as the query params
+	for _, params := range []map[string]interface{}{{"page": -1.0}, {"pages": -1.0}, {"density": -72.0}} {
+		if _, err := buildParamsFromOperation(PipelineOperation{Params: params}); err == nil {
+			t.Errorf("Expected an error for page params: %v", params)
+		}
+	}
+}
+
+func TestPDFPages(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("pages.pdf"))
+	if _, err := vips.PDFInfo(buf, 0, 1, vectorDensity); err == vips.ErrNotSupported || bimg.DetermineImageType(buf) != bimg.PDF {
+		t.Skip("PDF images are not supported by libvips")
+	}
+
+	if pages := countPages(buf); pages != 2 {
+		t.Errorf("Invalid number of PDF pages: %d", pages)
+	}
+
+	cases := []struct {
+		page, pages   int
+		density       float64
+		width, height int
+	}{
+		{1, 0, 144, 400, 200},
+		{0, 2, 0, 200, 200},
+		{1, 5, 72, 200, 100},
+	}
+	for _, c := range cases {
+		o := ImageOptions{Page: c.page, Pages: c.pages, Density: c.density}
+		o.IsDefinedField.Page = true
+
+		out, err := preparePages(buf, o, 100)
+		if err != nil {
+			t.Fatalf("Cannot select PDF pages: %s", err)
+		}
+		size, _ := bimg.Size(out)
+		if size.Width != c.width || size.Height != c.height {
+			t.Errorf("Invalid size of PDF page %d: %dx%d", c.page, size.Width, size.Height)
+		}
+	}
+
+	o := ImageOptions{Page: 2}
+	o.IsDefinedField.Page = true
+	if _, err := preparePages(buf, o, 100); err != ErrPageOutOfRange {
+		t.Errorf("Expected a page out of range error, got: %v", err)
+	}
+
+	// The stacked pages must be checked before rendering them
+	o = ImageOptions{Pages: 2, Density: 720}
+	if _, err := preparePages(buf, o, 2.5); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution too big error, got: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"sort"
+)
+
+const (
+	// paletteSampleSize defines the max width/height of the downsampled image used to compute colours.
+	paletteSampleSize = 100
+	// paletteDefaultColors defines the default number of colours in the palette.
+	paletteDefaultColors = 5
+	// paletteMaxColors defines the max number of colours allowed in the palette.
+	paletteMaxColors = 32
+	// paletteAlphaThreshold defines the alpha level under which pixels are ignored as transparent.
+	paletteAlphaThreshold = 128
+)
+
+// PaletteColor represents a single colour in hex and RGB notation.
+type PaletteColor struct {
+	Hex        string   `json:"hex"`
+	RGB        [3]uint8 `json:"rgb"`
+	Percentage float64  `json:"percentage,omitempty"`
+}
+
+// PaletteInfo represents the colour details of an image.
+type PaletteInfo struct {
+	Average  PaletteColor   `json:"average"`
+	Dominant PaletteColor   `json:"dominant"`
+	Palette  []PaletteColor `json:"palette"`
+}
+
+// colorBox represents a set of pixels in the RGB space used by the median cut quantisation.
+type colorBox []color.NRGBA
+
+func Palette(buf []byte, o ImageOptions) (Image, error) {
+	// We're not handling an image here, but we reused the struct.
+	image := Image{Mime: "application/json"}
+
+	colors := o.Colors
+	if colors == 0 {
+		colors = paletteDefaultColors
+	}
+	if colors < 1 || colors > paletteMaxColors {
+		return image, NewError(fmt.Sprintf("Invalid colors param: must be between 1 and %d", paletteMaxColors), http.StatusBadRequest)
+	}
+
+	img, err := rasterize(buf, paletteSampleSize)
+	if err != nil {
+		return image, NewError("Cannot retrieve image colors: "+err.Error(), http.StatusBadRequest)
+	}
+
+	pixels := opaquePixels(img)
+	if len(pixels) == 0 {
+		return image, NewError("Cannot retrieve image colors: image is fully transparent", http.StatusUnprocessableEntity)
+	}
+
+	info := PaletteInfo{Average: newPaletteColor(pixels.mean(), 0)}
+	for _, box := range medianCut(pixels, colors) {
+		percentage := toFixed(float64(len(box))*100/float64(len(pixels)), 2)
+		info.Palette = append(info.Palette, newPaletteColor(box.mean(), percentage))
+	}
+	info.Dominant = info.Palette[0]
+
+	body, _ := json.Marshal(info)
+	image.Body = body
+
+	return image, nil
+}
+
+func newPaletteColor(c color.NRGBA, percentage float64) PaletteColor {
+	return PaletteColor{
+		Hex:        fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+		RGB:        [3]uint8{c.R, c.G, c.B},
+		Percentage: percentage,
+	}
+}
+
+// opaquePixels returns the image pixels, ignoring the transparent ones.
+func opaquePixels(img image.Image) colorBox {
+	bounds := img.Bounds()
+	pixels := make(colorBox, 0, bounds.Dx()*bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < paletteAlphaThreshold {
+				continue
+			}
+			pixels = append(pixels, c)
+		}
+	}
+
+	return pixels
+}
+
+// medianCut splits the given pixels in up to n boxes of similar colours,
+// sorted by population in descending order.
+func medianCut(pixels colorBox, n int) []colorBox {
+	return splitColorBoxes(pixels, n, 3)
+}
+
+// splitColorBoxes splits the given pixels in up to n boxes by the given number
+// of channels, being the fourth one the alpha channel.
+func splitColorBoxes(pixels colorBox, n, channels int) []colorBox {
+	boxes := []colorBox{pixels}
+
+	for len(boxes) < n {
+		// Split the box with the widest channel range
+		index, channel, widest := -1, 0, uint8(0)
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if c, r := box.widestChannelOf(channels); r > widest {
+				index, channel, widest = i, c, r
+			}
+		}
+
+		// No box can be split any further
+		if index == -1 {
+			break
+		}
+
+		box := boxes[index]
+		sort.Slice(box, func(i, j int) bool {
+			return channelValue(box[i], channel) < channelValue(box[j], channel)
+		})
+
+		median := splitIndex(box, channel)
+		boxes[index] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return len(boxes[i]) > len(boxes[j])
+	})
+
+	return boxes
+}
+
+// splitIndex returns the index closest to the median of the sorted box
+// which does not split pixels with the same channel value.
+func splitIndex(box colorBox, channel int) int {
+	median := len(box) / 2
+	for i := median; i < len(box); i++ {
+		if channelValue(box[i-1], channel) != channelValue(box[i], channel) {
+			return i
+		}
+	}
+	for i := median - 1; i > 0; i-- {
+		if channelValue(box[i-1], channel) != channelValue(box[i], channel) {
+			return i
+		}
+	}
+	return median
+}
+
+// widestChannel returns the RGB channel index with the widest range and its range.
+func (b colorBox) widestChannel() (int, uint8) {
+	return b.widestChannelOf(3)
+}
+
+// widestChannelOf returns the index of the channel with the widest range, out of the given number of channels.
+func (b colorBox) widestChannelOf(channels int) (int, uint8) {
+	min := [4]uint8{255, 255, 255, 255}
+	max := [4]uint8{0, 0, 0, 0}
+
+	for _, c := range b {
+		for i := 0; i < channels; i++ {
+			v := channelValue(c, i)
+			if v < min[i] {
+				min[i] = v
+			}
+			if v > max[i] {
+				max[i] = v
+			}
+		}
+	}
+
+	channel := 0
+	for i := 1; i < channels; i++ {
+		if max[i]-min[i] > max[channel]-min[channel] {
+			channel = i
+		}
+	}
+
+	return channel, max[channel] - min[channel]
+}
+
+// meanAlpha returns the average alpha of the box.
+func (b colorBox) meanAlpha() uint8 {
+	if len(b) == 0 {
+		return 255
+	}
+
+	var a int
+	for _, c := range b {
+		a += int(c.A)
+	}
+	return uint8((a + len(b)/2) / len(b))
+}
+
+// mean returns the average colour of the box.
+func (b colorBox) mean() color.NRGBA {
+	var r, g, bl int
+	for _, c := range b {
+		r += int(c.R)
+		g += int(c.G)
+		bl += int(c.B)
+	}
+
+	n := len(b)
+	if n == 0 {
+		return color.NRGBA{A: 255}
+	}
+
+	return color.NRGBA{
+		R: uint8((r + n/2) / n),
+		G: uint8((g + n/2) / n),
+		B: uint8((bl + n/2) / n),
+		A: 255,
+	}
+}
+
+func channelValue(c color.NRGBA, channel int) uint8 {
+	switch channel {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 3:
+		return c.A
+	default:
+		return c.B
+	}
+}
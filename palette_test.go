@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPalette(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Palette(buf, ImageOptions{Colors: 4})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "application/json" {
+		t.Error("Invalid response MIME type")
+	}
+
+	var info PaletteInfo
+	if err := json.Unmarshal(img.Body, &info); err != nil {
+		t.Fatalf("Invalid JSON response: %s", err)
+	}
+
+	if len(info.Palette) == 0 || len(info.Palette) > 4 {
+		t.Fatalf("Invalid palette length: %d", len(info.Palette))
+	}
+	if info.Dominant != info.Palette[0] {
+		t.Errorf("Dominant color must be the most populated one: %#v", info.Dominant)
+	}
+	if len(info.Average.Hex) != 7 || info.Average.Hex[0] != '#' {
+		t.Errorf("Invalid average color: %#v", info.Average)
+	}
+
+	var total float64
+	for _, c := range info.Palette {
+		total += c.Percentage
+	}
+	if total < 99.9 || total > 100.1 {
+		t.Errorf("Invalid palette percentages total: %f", total)
+	}
+}
+
+func TestPaletteInvalidColors(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	if _, err := Palette(buf, ImageOptions{Colors: paletteMaxColors + 1}); err == nil {
+		t.Error("Expected an error for an out of range colors param")
+	}
+}
+
+func TestMedianCut(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	var pixels colorBox
+	for i := 0; i < 30; i++ {
+		pixels = append(pixels, red)
+	}
+	for i := 0; i < 10; i++ {
+		pixels = append(pixels, blue)
+	}
+
+	boxes := medianCut(pixels, 8)
+	if len(boxes) != 2 {
+		t.Fatalf("Invalid number of boxes: %d", len(boxes))
+	}
+	if boxes[0].mean() != red || len(boxes[0]) != 30 {
+		t.Errorf("Expected the most populated box to be red: %#v", boxes[0].mean())
+	}
+	if boxes[1].mean() != blue || len(boxes[1]) != 10 {
+		t.Errorf("Expected the least populated box to be blue: %#v", boxes[1].mean())
+	}
+}
@@ -18,45 +18,97 @@ var ErrUnsupportedValue = errors.New("unsupported value")
 type Coercion func(*ImageOptions, interface{}) error
 
 var paramTypeCoercions = map[string]Coercion{
-	"width":       coerceWidth,
-	"height":      coerceHeight,
-	"quality":     coerceQuality,
-	"top":         coerceTop,
-	"left":        coerceLeft,
-	"areawidth":   coerceAreaWidth,
-	"areaheight":  coerceAreaHeight,
-	"compression": coerceCompression,
-	"rotate":      coerceRotate,
-	"margin":      coerceMargin,
-	"factor":      coerceFactor,
-	"dpi":         coerceDPI,
-	"textwidth":   coerceTextWidth,
-	"opacity":     coerceOpacity,
-	"flip":        coerceFlip,
-	"flop":        coerceFlop,
-	"nocrop":      coerceNoCrop,
-	"noprofile":   coerceNoProfile,
-	"norotation":  coerceNoRotation,
-	"noreplicate": coerceNoReplicate,
-	"force":       coerceForce,
-	"embed":       coerceEmbed,
-	"stripmeta":   coerceStripMeta,
-	"text":        coerceText,
-	"image":       coerceImage,
-	"font":        coerceFont,
-	"type":        coerceImageType,
-	"color":       coerceColor,
-	"colorspace":  coerceColorSpace,
-	"gravity":     coerceGravity,
-	"background":  coerceBackground,
-	"extend":      coerceExtend,
-	"sigma":       coerceSigma,
-	"minampl":     coerceMinAmpl,
-	"operations":  coerceOperations,
-	"interlace":   coerceInterlace,
-	"aspectratio": coerceAspectRatio,
-	"palette":     coercePalette,
-	"speed":       coerceSpeed,
+	"width":         coerceWidth,
+	"height":        coerceHeight,
+	"quality":       coerceQuality,
+	"qualitytarget": coerceQualityTarget,
+	"top":           coerceTop,
+	"left":          coerceLeft,
+	"areawidth":     coerceAreaWidth,
+	"areaheight":    coerceAreaHeight,
+	"compression":   coerceCompression,
+	"rotate":        coerceRotate,
+	"margin":        coerceMargin,
+	"factor":        coerceFactor,
+	"dpi":           coerceDPI,
+	"textwidth":     coerceTextWidth,
+	"opacity":       coerceOpacity,
+	"flip":          coerceFlip,
+	"flop":          coerceFlop,
+	"nocrop":        coerceNoCrop,
+	"noprofile":     coerceNoProfile,
+	"norotation":    coerceNoRotation,
+	"noreplicate":   coerceNoReplicate,
+	"force":         coerceForce,
+	"embed":         coerceEmbed,
+	"stripmeta":     coerceStripMeta,
+	"text":          coerceText,
+	"image":         coerceImage,
+	"font":          coerceFont,
+	"type":          coerceImageType,
+	"color":         coerceColor,
+	"colorspace":    coerceColorSpace,
+	"gravity":       coerceGravity,
+	"background":    coerceBackground,
+	"extend":        coerceExtend,
+	"sigma":         coerceSigma,
+	"minampl":       coerceMinAmpl,
+	"operations":    coerceOperations,
+	"interlace":     coerceInterlace,
+	"aspectratio":   coerceAspectRatio,
+	"palette":       coercePalette,
+	"speed":         coerceSpeed,
+	"colors":        coerceColors,
+	"dither":        coerceDither,
+	"quantquality":  coerceQuantQuality,
+	"fx":            coerceFocalX,
+	"fy":            coerceFocalY,
+	"textangle":     coerceTextAngle,
+	"offsetx":       coerceOffsetX,
+	"offsety":       coerceOffsetY,
+	"strokewidth":   coerceStrokeWidth,
+	"strokecolor":   coerceStrokeColor,
+	"shadowx":       coerceShadowX,
+	"shadowy":       coerceShadowY,
+	"shadowblur":    coerceShadowBlur,
+	"shadowcolor":   coerceShadowColor,
+	"watermark":     coerceWatermark,
+	"scale":         coerceScale,
+	"replicate":     coerceReplicate,
+	"page":          coercePage,
+	"pages":         coercePages,
+	"density":       coerceDensity,
+	"columns":       coerceColumns,
+	"spacing":       coerceSpacing,
+	"layout":        coerceLayout,
+	"fit":           coerceFit,
+	"hash":          coerceHash,
+	"output":        coerceOutput,
+	"groups":        coerceGroups,
+	"keep":          coerceKeep,
+	"strip":         coerceStrip,
+	"outputprofile": coerceOutputProfile,
+	"dpr":           coerceDPR,
+	"maxbytes":      coerceMaxBytes,
+	"shrink":        coerceShrink,
+	"download":      coerceDownload,
+	"filename":      coerceFilename,
+	"filter":        coerceFilter,
+	"lut":           coerceLUT,
+	"intensity":     coerceIntensity,
+	"shadows":       coerceShadows,
+	"highlights":    coerceHighlights,
+	"regions":       coerceRegions,
+	"mode":          coerceMode,
+	"relative":      coerceRelative,
+	"blocksize":     coerceBlockSize,
+	"rotatecrop":    coerceRotateCrop,
+	"padding":       coercePadding,
+	"borderwidth":   coerceBorderWidth,
+	"bordercolor":   coerceBorderColor,
+	"darken":        coerceDarken,
+
+	"withoutenlargement": coerceWithoutEnlargement,
 }
 
 func coerceTypeInt(param interface{}) (int, error) {
@@ -132,10 +184,23 @@ func coerceWidth(io *ImageOptions, param interface{}) (err error) {
 }
 
 func coerceQuality(io *ImageOptions, param interface{}) (err error) {
+	if v, ok := param.(string); ok && strings.EqualFold(strings.TrimSpace(v), QualityAuto) {
+		io.AutoQuality = true
+		return nil
+	}
 	io.Quality, err = coerceTypeInt(param)
 	return err
 }
 
+func coerceQualityTarget(io *ImageOptions, param interface{}) (err error) {
+	io.QualityTarget, err = coerceTypeString(param)
+	io.QualityTarget = strings.ToLower(io.QualityTarget)
+	if _, ok := qualityTargets[io.QualityTarget]; err == nil && !ok {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
 func coerceTop(io *ImageOptions, param interface{}) (err error) {
 	io.Top, err = coerceTypeInt(param)
 	return err
@@ -162,8 +227,16 @@ func coerceCompression(io *ImageOptions, param interface{}) (err error) {
 }
 
 func coerceRotate(io *ImageOptions, param interface{}) (err error) {
-	io.Rotate, err = coerceTypeInt(param)
-	return err
+	// Negative angles rotate the image counterclockwise
+	if v, ok := param.(string); ok {
+		io.Rotate, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+	} else {
+		io.Rotate, err = coerceTypeFloat(param)
+	}
+	if err != nil || math.IsNaN(io.Rotate) || math.IsInf(io.Rotate, 0) {
+		return ErrUnsupportedValue
+	}
+	return nil
 }
 
 func coerceMargin(io *ImageOptions, param interface{}) (err error) {
@@ -287,6 +360,7 @@ func coerceColorSpace(io *ImageOptions, param interface{}) error {
 func coerceGravity(io *ImageOptions, param interface{}) error {
 	if v, ok := param.(string); ok {
 		io.Gravity = parseGravity(v)
+		io.IsDefinedField.Gravity = true
 		return nil
 	}
 
@@ -310,6 +384,7 @@ func coerceAspectRatio(io *ImageOptions, param interface{}) (err error) {
 func coerceExtend(io *ImageOptions, param interface{}) error {
 	if v, ok := param.(string); ok {
 		io.Extend = parseExtendMode(v)
+		io.IsDefinedField.Extend = true
 		return nil
 	}
 
@@ -346,8 +421,12 @@ func coerceInterlace(io *ImageOptions, param interface{}) (err error) {
 }
 
 func coercePalette(io *ImageOptions, param interface{}) (err error) {
-	io.Palette, err = coerceTypeBool(param)
 	io.IsDefinedField.Palette = true
+	if v, ok := param.(string); ok && strings.EqualFold(strings.TrimSpace(v), "auto") {
+		io.PaletteAuto = true
+		return nil
+	}
+	io.Palette, err = coerceTypeBool(param)
 	return err
 }
 
@@ -356,6 +435,377 @@ func coerceSpeed(io *ImageOptions, param interface{}) (err error) {
 	return err
 }
 
+func coerceColors(io *ImageOptions, param interface{}) (err error) {
+	io.Colors, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceDither(io *ImageOptions, param interface{}) (err error) {
+	io.Dither, err = coerceTypeFloat(param)
+	io.IsDefinedField.Dither = true
+	if err == nil && io.Dither > 1 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceQuantQuality(io *ImageOptions, param interface{}) (err error) {
+	io.QuantQuality, err = coerceTypeInt(param)
+	if err == nil && io.QuantQuality > 100 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceFocalX(io *ImageOptions, param interface{}) (err error) {
+	io.FocalX, err = coerceFocalPoint(param)
+	io.IsDefinedField.FocalX = true
+	return err
+}
+
+func coerceFocalY(io *ImageOptions, param interface{}) (err error) {
+	io.FocalY, err = coerceFocalPoint(param)
+	io.IsDefinedField.FocalY = true
+	return err
+}
+
+func coerceFocalPoint(param interface{}) (float64, error) {
+	v, err := coerceTypeFloat(param)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1 {
+		return 0, ErrUnsupportedValue
+	}
+	return v, nil
+}
+
+func coerceTextAngle(io *ImageOptions, param interface{}) (err error) {
+	io.TextAngle, err = coerceTypeFloat(param)
+	return err
+}
+
+func coerceOffsetX(io *ImageOptions, param interface{}) (err error) {
+	io.OffsetX, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceOffsetY(io *ImageOptions, param interface{}) (err error) {
+	io.OffsetY, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceStrokeWidth(io *ImageOptions, param interface{}) (err error) {
+	io.StrokeWidth, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceStrokeColor(io *ImageOptions, param interface{}) error {
+	if v, ok := param.(string); ok {
+		io.StrokeColor = parseColor(v)
+		return nil
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceShadowX(io *ImageOptions, param interface{}) (err error) {
+	io.ShadowX, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceShadowY(io *ImageOptions, param interface{}) (err error) {
+	io.ShadowY, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceShadowBlur(io *ImageOptions, param interface{}) (err error) {
+	io.ShadowBlur, err = coerceTypeFloat(param)
+	return err
+}
+
+func coerceShadowColor(io *ImageOptions, param interface{}) error {
+	if v, ok := param.(string); ok {
+		io.ShadowColor = parseColor(v)
+		return nil
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceWatermark(io *ImageOptions, param interface{}) (err error) {
+	io.Watermark, err = coerceTypeString(param)
+	return err
+}
+
+func coerceScale(io *ImageOptions, param interface{}) (err error) {
+	io.Scale, err = coerceTypeFloat(param)
+	if err == nil && io.Scale > 1 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceReplicate(io *ImageOptions, param interface{}) (err error) {
+	io.Replicate, err = coerceTypeBool(param)
+	return err
+}
+
+func coercePage(io *ImageOptions, param interface{}) (err error) {
+	io.Page, err = coerceTypeInt(param)
+	io.IsDefinedField.Page = true
+	if err == nil && io.Page < 0 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coercePages(io *ImageOptions, param interface{}) (err error) {
+	io.Pages, err = coerceTypeInt(param)
+	if err == nil && (io.Pages < 0 || io.Pages > maxSelectedPages) {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceDensity(io *ImageOptions, param interface{}) (err error) {
+	io.Density, err = coerceTypeFloat(param)
+	if err == nil && io.Density < 0 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceColumns(io *ImageOptions, param interface{}) (err error) {
+	io.Columns, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceSpacing(io *ImageOptions, param interface{}) (err error) {
+	io.Spacing, err = coerceTypeInt(param)
+	return err
+}
+
+func coerceLayout(io *ImageOptions, param interface{}) (err error) {
+	io.Layout, err = coerceTypeString(param)
+	return err
+}
+
+func coerceFit(io *ImageOptions, param interface{}) (err error) {
+	io.Fit, err = coerceTypeString(param)
+	if err == nil && !isValidFit(io.Fit) {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceHash(io *ImageOptions, param interface{}) (err error) {
+	io.Hash, err = coerceTypeBool(param)
+	return err
+}
+
+func coerceOutput(io *ImageOptions, param interface{}) (err error) {
+	io.Output, err = coerceTypeString(param)
+	if err == nil && io.Output != CompareOutputJSON && io.Output != CompareOutputDiff {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceGroups(io *ImageOptions, param interface{}) error {
+	groups, err := coerceTypeString(param)
+	if err != nil {
+		return err
+	}
+
+	io.Groups = []string{}
+	for _, group := range strings.Split(groups, ",") {
+		group = strings.TrimSpace(strings.ToLower(group))
+		if group == "" {
+			continue
+		}
+		if !isValidMetadataGroup(group) {
+			return ErrUnsupportedValue
+		}
+		io.Groups = append(io.Groups, group)
+	}
+	return nil
+}
+
+func coerceKeep(io *ImageOptions, param interface{}) error {
+	groups, err := coerceTypeString(param)
+	if err == nil {
+		io.Keep, err = parseMetadataPolicyGroups(groups)
+	}
+	return err
+}
+
+func coerceStrip(io *ImageOptions, param interface{}) error {
+	groups, err := coerceTypeString(param)
+	if err == nil {
+		io.Strip, err = parseMetadataPolicyGroups(groups)
+	}
+	return err
+}
+
+func coerceOutputProfile(io *ImageOptions, param interface{}) (err error) {
+	io.OutputProfile, err = coerceTypeString(param)
+	io.OutputProfile = strings.ToLower(io.OutputProfile)
+	if err == nil && !isValidOutputProfile(io.OutputProfile) {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceDPR(io *ImageOptions, param interface{}) (err error) {
+	io.DPR, err = coerceTypeFloat(param)
+	if err == nil && (io.DPR <= 0 || math.IsInf(io.DPR, 0)) {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceMaxBytes(io *ImageOptions, param interface{}) (err error) {
+	io.MaxBytes, err = coerceTypeInt(param)
+	if err == nil && io.MaxBytes <= 0 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceShrink(io *ImageOptions, param interface{}) (err error) {
+	io.Shrink, err = coerceTypeBool(param)
+	return err
+}
+
+func coerceDownload(io *ImageOptions, param interface{}) (err error) {
+	io.Download, err = coerceTypeBool(param)
+	return err
+}
+
+func coerceFilename(io *ImageOptions, param interface{}) (err error) {
+	io.Filename, err = coerceTypeString(param)
+	io.Filename = sanitizeFilename(io.Filename)
+	return err
+}
+
+func coerceFilter(io *ImageOptions, param interface{}) (err error) {
+	io.Filter, err = coerceTypeString(param)
+	io.Filter = strings.ToLower(io.Filter)
+	return err
+}
+
+func coerceLUT(io *ImageOptions, param interface{}) (err error) {
+	io.LUT, err = coerceTypeString(param)
+	io.LUT = strings.ToLower(io.LUT)
+	return err
+}
+
+func coerceIntensity(io *ImageOptions, param interface{}) (err error) {
+	io.Intensity, err = coerceTypeFloat(param)
+	if err == nil && io.Intensity > 1 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceShadows(io *ImageOptions, param interface{}) error {
+	if v, ok := param.(string); ok {
+		io.Shadows = parseColor(v)
+		return nil
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceHighlights(io *ImageOptions, param interface{}) error {
+	if v, ok := param.(string); ok {
+		io.Highlights = parseColor(v)
+		return nil
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceRegions(io *ImageOptions, param interface{}) (err error) {
+	io.Regions, err = parseRegions(param)
+	return err
+}
+
+func coerceMode(io *ImageOptions, param interface{}) (err error) {
+	io.Mode, err = coerceTypeString(param)
+	if err == nil && !isValidRedactMode(io.Mode) {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceRelative(io *ImageOptions, param interface{}) (err error) {
+	io.Relative, err = coerceTypeBool(param)
+	return err
+}
+
+func coerceBlockSize(io *ImageOptions, param interface{}) (err error) {
+	io.BlockSize, err = coerceTypeInt(param)
+	if err == nil && io.BlockSize < 1 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceRotateCrop(io *ImageOptions, param interface{}) (err error) {
+	io.RotateCrop, err = coerceTypeBool(param)
+	return err
+}
+
+func coercePadding(io *ImageOptions, param interface{}) (err error) {
+	switch v := param.(type) {
+	case string:
+		io.Padding, err = parsePadding(v)
+		return err
+	case float64:
+		io.Padding, err = parsePadding(strconv.FormatFloat(v, 'f', -1, 64))
+		return err
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceBorderWidth(io *ImageOptions, param interface{}) (err error) {
+	switch v := param.(type) {
+	case string:
+		io.BorderWidth, err = parsePadMargin(v)
+		return err
+	case float64:
+		io.BorderWidth, err = parsePadMargin(strconv.FormatFloat(v, 'f', -1, 64))
+		return err
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceBorderColor(io *ImageOptions, param interface{}) error {
+	if v, ok := param.(string); ok {
+		io.BorderColor = parseColor(v)
+		return nil
+	}
+
+	return ErrUnsupportedValue
+}
+
+func coerceDarken(io *ImageOptions, param interface{}) (err error) {
+	io.Darken, err = coerceTypeFloat(param)
+	if err == nil && io.Darken > 1 {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func coerceWithoutEnlargement(io *ImageOptions, param interface{}) (err error) {
+	io.NoEnlargement, err = coerceTypeBool(param)
+	return err
+}
+
 func buildParamsFromOperation(op PipelineOperation) (ImageOptions, error) {
 	var options ImageOptions
 
@@ -364,6 +814,9 @@ func buildParamsFromOperation(op PipelineOperation) (ImageOptions, error) {
 
 	for key, value := range op.Params {
 		fn, ok := paramTypeCoercions[key]
+		if !ok {
+			fn, ok = encoderParamCoercion(key)
+		}
 		if !ok {
 			continue
 		}
@@ -387,6 +840,9 @@ func buildParamsFromQuery(query url.Values) (ImageOptions, error) {
 	// Extract only known parameters
 	for key := range query {
 		fn, ok := paramTypeCoercions[key]
+		if !ok {
+			fn, ok = encoderParamCoercion(key)
+		}
 		if !ok {
 			continue
 		}
@@ -478,16 +934,23 @@ func parseExtendMode(val string) bimg.Extend {
 	if val == "lastpixel" {
 		return bimg.ExtendLast
 	}
+	if val == "blur" {
+		return ExtendBlur
+	}
 	return bimg.ExtendMirror
 }
 
 func parseGravity(val string) bimg.Gravity {
 	var m = map[string]bimg.Gravity{
-		"south": bimg.GravitySouth,
-		"north": bimg.GravityNorth,
-		"east":  bimg.GravityEast,
-		"west":  bimg.GravityWest,
-		"smart": bimg.GravitySmart,
+		"south":     bimg.GravitySouth,
+		"north":     bimg.GravityNorth,
+		"east":      bimg.GravityEast,
+		"west":      bimg.GravityWest,
+		"smart":     bimg.GravitySmart,
+		"northeast": GravityNorthEast,
+		"southeast": GravitySouthEast,
+		"southwest": GravitySouthWest,
+		"northwest": GravityNorthWest,
 	}
 
 	val = strings.TrimSpace(strings.ToLower(val))
@@ -173,6 +173,57 @@ func TestGravity(t *testing.T) {
 	}
 }
 
+func TestCompassGravity(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected bimg.Gravity
+	}{
+		{"north", bimg.GravityNorth},
+		{"northeast", GravityNorthEast},
+		{"SouthEast", GravitySouthEast},
+		{" southwest ", GravitySouthWest},
+		{"northwest", GravityNorthWest},
+		{"centre", bimg.GravityCentre},
+	}
+
+	for _, gravity := range cases {
+		if g := parseGravity(gravity.value); g != gravity.expected {
+			t.Errorf("Invalid gravity value: %d != %d", g, gravity.expected)
+		}
+	}
+}
+
+func TestFocalPointParams(t *testing.T) {
+	io, err := buildParamsFromQuery(url.Values{"fx": []string{"0.3"}, "fy": []string{"0"}})
+	if err != nil {
+		t.Fatalf("Failed reading params, %s", err)
+	}
+	if math.Abs(io.FocalX-0.3) > epsilon || io.FocalY != 0 {
+		t.Errorf("Invalid focal point: %f, %f", io.FocalX, io.FocalY)
+	}
+	if !io.IsDefinedField.FocalX || !io.IsDefinedField.FocalY {
+		t.Error("Expected focal point params to be defined")
+	}
+
+	if _, err := buildParamsFromQuery(url.Values{"fx": []string{"1.5"}}); err == nil {
+		t.Error("Expected an error for an out of range focal point")
+	}
+}
+
+func TestWatermarkImageParams(t *testing.T) {
+	io, err := buildParamsFromQuery(url.Values{"watermark": []string{"logo"}, "scale": []string{"0.25"}, "replicate": []string{"true"}})
+	if err != nil {
+		t.Fatalf("Failed reading params, %s", err)
+	}
+	if io.Watermark != "logo" || math.Abs(io.Scale-0.25) > epsilon || !io.Replicate {
+		t.Errorf("Invalid watermark image params: %s, %f, %t", io.Watermark, io.Scale, io.Replicate)
+	}
+
+	if _, err := buildParamsFromQuery(url.Values{"scale": []string{"2"}}); err == nil {
+		t.Error("Expected an error for an out of range scale")
+	}
+}
+
 func TestReadMapParams(t *testing.T) {
 	cases := []struct {
 		params   map[string]interface{}
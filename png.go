@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngXMPKeyword defines the keyword of the international text chunk holding the XMP packet.
+const pngXMPKeyword = "XML:com.adobe.xmp\x00"
+
+var errInvalidPNG = errors.New("invalid PNG image")
+
+// pngChunk represents a chunk of a PNG image.
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// readPNGChunks returns the chunks of a PNG image, up to the image end chunk.
+// Chunks checksums are not verified.
+func readPNGChunks(buf []byte) ([]pngChunk, error) {
+	if len(buf) < len(pngSignature) || string(buf[:len(pngSignature)]) != pngSignature {
+		return nil, errInvalidPNG
+	}
+
+	var chunks []pngChunk
+	offset := len(pngSignature)
+	for offset+8 <= len(buf) {
+		length := int(binary.BigEndian.Uint32(buf[offset:]))
+		if length < 0 || offset+12+length > len(buf) {
+			return nil, errInvalidPNG
+		}
+
+		chunk := pngChunk{Type: string(buf[offset+4 : offset+8]), Data: buf[offset+8 : offset+8+length]}
+		chunks = append(chunks, chunk)
+		offset += 12 + length
+
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+
+	if len(chunks) == 0 || chunks[0].Type != "IHDR" || len(chunks[0].Data) != 13 {
+		return nil, errInvalidPNG
+	}
+	return chunks, nil
+}
+
+// writePNGChunks returns a PNG image with the given chunks.
+func writePNGChunks(chunks []pngChunk) []byte {
+	buf := []byte(pngSignature)
+	for _, chunk := range chunks {
+		start := len(buf)
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[start:], uint32(len(chunk.Data)))
+		buf = append(buf, chunk.Type...)
+		buf = append(buf, chunk.Data...)
+		buf = append(buf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(buf[start+4:len(buf)-4]))
+	}
+	return buf
+}
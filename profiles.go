@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Output ICC profile names. sRGB and Display P3 are built into libvips,
+// while Adobe RGB requires an adobergb profile in the profiles directory.
+const (
+	ProfileSRGB     = "srgb"
+	ProfileP3       = "p3"
+	ProfileAdobeRGB = "adobergb"
+)
+
+// builtinProfiles defines the libvips built-in ICC profiles.
+var builtinProfiles = map[string]string{
+	ProfileSRGB: "srgb",
+	ProfileP3:   "p3",
+	"cmyk":      "cmyk",
+}
+
+// profileExtensions defines the file extensions of the ICC profiles read from the profiles directory.
+var profileExtensions = map[string]bool{".icc": true, ".icm": true}
+
+// profiles stores the paths of the ICC profiles of the profiles directory, by lower case file name without extension.
+var profiles = map[string]string{}
+
+// LoadProfiles registers the ICC profiles of the profiles directory, if present, based on the server options.
+func LoadProfiles(o ServerOptions) error {
+	if o.ProfilesDir == "" {
+		return nil
+	}
+
+	registry, err := readProfilesDirectory(o.ProfilesDir)
+	if err != nil {
+		return err
+	}
+	profiles = registry
+	return nil
+}
+
+// readProfilesDirectory returns the absolute paths of the valid ICC profiles of the given directory,
+// registered by their lower case file name without extension.
+func readProfilesDirectory(dir string) (map[string]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := make(map[string]string)
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || !profileExtensions[strings.ToLower(ext)] {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parseICC(buf); err != nil {
+			return nil, fmt.Errorf("invalid ICC profile %s: %s", file.Name(), err)
+		}
+
+		registry[strings.ToLower(strings.TrimSuffix(file.Name(), ext))] = path
+	}
+	return registry, nil
+}
+
+// outputProfilePath returns the path or libvips built-in name of the given output profile,
+// preferring the profiles directory over the built-in profiles.
+func outputProfilePath(name string) (string, bool) {
+	if path, ok := profiles[name]; ok {
+		return path, true
+	}
+	if name == "cmyk" {
+		// Only used as the default input profile of CMYK images
+		return "", false
+	}
+	path, ok := builtinProfiles[name]
+	return path, ok
+}
+
+// applyColorProfile converts the colours of the image to the requested output profile,
+// sRGB by default, via its embedded ICC profile. Images without an embedded profile
+// are considered sRGB, or generic CMYK if they're CMYK images.
+// The colour space of CMYK and 16-bit images is preserved up to the ICC transform,
+// which is then done from the original image data.
+func applyColorProfile(opts *bimg.Options, meta bimg.ImageMetadata, o ImageOptions) error {
+	if o.Colorspace == bimg.InterpretationBW {
+		return nil
+	}
+
+	name := o.OutputProfile
+	if name == "" {
+		name = ProfileSRGB
+	}
+	path, ok := outputProfilePath(name)
+	if !ok {
+		return NewError(fmt.Sprintf("Unsupported output profile: %s", name), http.StatusBadRequest)
+	}
+
+	switch {
+	case meta.Space == "cmyk":
+		opts.Interpretation = bimg.InterpretationCMYK
+		if !meta.Profile {
+			opts.InputICC = builtinProfiles["cmyk"]
+		}
+	case meta.Profile && meta.Space == "rgb16":
+		opts.Interpretation = bimg.InterpretationRGB16
+	case meta.Profile && meta.Space == "grey16":
+		opts.Interpretation = bimg.InterpretationGREY16
+	case !meta.Profile && name != ProfileSRGB:
+		opts.InputICC = builtinProfiles[ProfileSRGB]
+	case !meta.Profile:
+		// Already sRGB
+		return nil
+	}
+
+	opts.OutputICC = path
+
+	// The profile is removed once transformed, if required
+	opts.NoProfile = false
+	return nil
+}
+
+// isValidOutputProfile returns true if the output profile is built-in or present in the profiles directory.
+func isValidOutputProfile(name string) bool {
+	_, ok := outputProfilePath(name)
+	return ok
+}
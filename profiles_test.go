@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestReadProfilesDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imaginary-profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "AdobeRGB.icc"), newTestICC("Adobe RGB (1998)"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a profile"), 0644)
+
+	registry, err := readProfilesDirectory(dir)
+	if err != nil {
+		t.Fatalf("Cannot read profiles directory: %s", err)
+	}
+	if len(registry) != 1 || filepath.Base(registry[ProfileAdobeRGB]) != "AdobeRGB.icc" {
+		t.Errorf("Invalid profiles registry: %#v", registry)
+	}
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "broken.icm"), []byte("not a profile"), 0644)
+	if _, err := readProfilesDirectory(dir); err == nil {
+		t.Error("Expected an error for an invalid ICC profile")
+	}
+}
+
+func TestOutputProfileParam(t *testing.T) {
+	defer func(registry map[string]string) { profiles = registry }(profiles)
+	profiles = map[string]string{}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"outputprofile": {"adobergb"}}); err == nil {
+		t.Error("Expected an error for a profile not present in the profiles directory")
+	}
+
+	profiles = map[string]string{ProfileAdobeRGB: "/profiles/AdobeRGB.icc"}
+	for _, name := range []string{"sRGB", "p3", "AdobeRGB"} {
+		if _, err := buildParamsFromQuery(map[string][]string{"outputprofile": {name}}); err != nil {
+			t.Errorf("Unexpected error for output profile %s: %s", name, err)
+		}
+	}
+	if _, err := buildParamsFromQuery(map[string][]string{"outputprofile": {"cmyk"}}); err == nil {
+		t.Error("Expected an error for a CMYK output profile")
+	}
+}
+
+func TestApplyColorProfile(t *testing.T) {
+	defer func(registry map[string]string) { profiles = registry }(profiles)
+	profiles = map[string]string{ProfileAdobeRGB: "/profiles/AdobeRGB.icc"}
+
+	cases := []struct {
+		name     string
+		meta     bimg.ImageMetadata
+		options  ImageOptions
+		expected bimg.Options
+	}{
+		{"untagged sRGB", bimg.ImageMetadata{Space: "srgb"}, ImageOptions{}, bimg.Options{}},
+		{"tagged sRGB", bimg.ImageMetadata{Space: "srgb", Profile: true}, ImageOptions{}, bimg.Options{OutputICC: "srgb"}},
+		{"untagged to P3", bimg.ImageMetadata{Space: "srgb"}, ImageOptions{OutputProfile: ProfileP3}, bimg.Options{InputICC: "srgb", OutputICC: "p3"}},
+		{"untagged CMYK", bimg.ImageMetadata{Space: "cmyk"}, ImageOptions{}, bimg.Options{InputICC: "cmyk", OutputICC: "srgb", Interpretation: bimg.InterpretationCMYK}},
+		{"tagged CMYK", bimg.ImageMetadata{Space: "cmyk", Profile: true}, ImageOptions{OutputProfile: ProfileAdobeRGB}, bimg.Options{OutputICC: "/profiles/AdobeRGB.icc", Interpretation: bimg.InterpretationCMYK}},
+		{"tagged 16-bit", bimg.ImageMetadata{Space: "rgb16", Profile: true}, ImageOptions{NoProfile: true}, bimg.Options{OutputICC: "srgb", Interpretation: bimg.InterpretationRGB16}},
+		{"black and white", bimg.ImageMetadata{Space: "cmyk"}, ImageOptions{Colorspace: bimg.InterpretationBW}, bimg.Options{}},
+	}
+
+	for _, c := range cases {
+		var opts bimg.Options
+		if err := applyColorProfile(&opts, c.meta, c.options); err != nil {
+			t.Fatalf("Unexpected error for %s: %s", c.name, err)
+		}
+		if opts.InputICC != c.expected.InputICC || opts.OutputICC != c.expected.OutputICC ||
+			opts.Interpretation != c.expected.Interpretation || opts.NoProfile {
+			t.Errorf("Invalid colour management options for %s: %#v", c.name, opts)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"math"
+	"net/http"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// quantizeMaxColors defines the max number of colours of the palette, including the transparent one.
+	quantizeMaxColors = 256
+	// quantizeSamples defines the max number of pixels sampled to compute the palette.
+	quantizeSamples = 1 << 16
+	// quantizeDefaultDither defines the default dithering amount.
+	quantizeDefaultDither = 1.0
+)
+
+// pngMetadataChunks defines the PNG chunks preserved in the quantised images.
+var pngMetadataChunks = map[string]bool{
+	"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true, "pHYs": true,
+	"eXIf": true, "iTXt": true, "tEXt": true, "zTXt": true, "tIME": true,
+}
+
+// Quantization defines how the output images are quantised to a palette.
+type Quantization struct {
+	// Colors defines the max number of colours of the palette.
+	Colors int
+	// Dither defines the Floyd-Steinberg dithering amount, between 0 and 1.
+	Dither float64
+	// MinQuality defines the min quality of the quantised PNG images, kept as true colour images otherwise.
+	MinQuality int
+	// Auto only quantises PNG images with up to Colors unique colours, losslessly.
+	Auto bool
+}
+
+// shouldQuantize returns true if the output image is quantised by imaginary: GIF images,
+// which bimg can't encode, and PNG images requiring palette options not supported by bimg.
+func shouldQuantize(outputType bimg.ImageType, o ImageOptions) bool {
+	switch outputType {
+	case bimg.GIF:
+		return true
+	case bimg.PNG:
+		return o.PaletteAuto || o.Palette && (o.Colors > 0 || o.IsDefinedField.Dither || o.QuantQuality > 0)
+	}
+	return false
+}
+
+// quantization returns the quantisation options of the given image options.
+func quantization(o ImageOptions) (Quantization, error) {
+	q := Quantization{Colors: o.Colors, Dither: quantizeDefaultDither, MinQuality: o.QuantQuality, Auto: o.PaletteAuto}
+	if q.Colors == 0 {
+		q.Colors = quantizeMaxColors
+	}
+	if q.Colors < 2 || q.Colors > quantizeMaxColors {
+		return q, NewError(fmt.Sprintf("Invalid colors param: must be between 2 and %d", quantizeMaxColors), http.StatusBadRequest)
+	}
+	if o.IsDefinedField.Dither {
+		q.Dither = o.Dither
+	}
+	return q, nil
+}
+
+// quantizeImage converts the given PNG image to a paletted PNG or GIF image. Images with up to the max
+// number of colours are converted losslessly, while any other is quantised via median cut.
+// PNG images are returned unchanged if they can't be quantised according to the quantisation options.
+func quantizeImage(buf []byte, outputType bimg.ImageType, q Quantization, compression int) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	// GIF images only support fully transparent pixels
+	binaryAlpha := outputType == bimg.GIF
+
+	palette := exactPalette(img, q.Colors, binaryAlpha)
+	exact := palette != nil
+	if !exact {
+		if q.Auto && outputType == bimg.PNG {
+			return buf, nil
+		}
+		palette = medianCutPalette(img, q.Colors, binaryAlpha)
+	}
+
+	dither := q.Dither
+	if exact {
+		dither = 0
+	}
+	paletted := mapPalette(img, palette, dither, binaryAlpha)
+
+	if outputType == bimg.GIF {
+		var out bytes.Buffer
+		err := gif.Encode(&out, paletted, nil)
+		return out.Bytes(), err
+	}
+
+	if !exact && q.MinQuality > 0 && quantizationQuality(img, paletted) < q.MinQuality {
+		return buf, nil
+	}
+
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: pngCompressionLevel(compression)}
+	if err := encoder.Encode(&out, paletted); err != nil {
+		return nil, err
+	}
+	return copyPNGMetadata(buf, out.Bytes())
+}
+
+// quantizeColor returns the colour of the pixel, with the alpha reduced to fully opaque
+// or fully transparent if required. Fully transparent pixels are always transparent black.
+func quantizeColor(img image.Image, x, y int, binaryAlpha bool) color.NRGBA {
+	c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+	if binaryAlpha {
+		if c.A < paletteAlphaThreshold {
+			return color.NRGBA{}
+		}
+		c.A = 255
+	}
+	if c.A == 0 {
+		return color.NRGBA{}
+	}
+	return c
+}
+
+// exactPalette returns the unique colours of the image, or nil if there are more than n.
+func exactPalette(img image.Image, n int, binaryAlpha bool) color.Palette {
+	bounds := img.Bounds()
+	unique := make(map[color.NRGBA]bool, n)
+	var palette color.Palette
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := quantizeColor(img, x, y, binaryAlpha)
+			if unique[c] {
+				continue
+			}
+			if len(unique) == n {
+				return nil
+			}
+			unique[c] = true
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+// medianCutPalette returns a palette of up to n colours computed via median cut, splitting the colours
+// by alpha too if the image is semi-transparent. A transparent colour is added if the image has any.
+func medianCutPalette(img image.Image, n int, binaryAlpha bool) color.Palette {
+	bounds := img.Bounds()
+	pixels := make(colorBox, 0, bounds.Dx()*bounds.Dy())
+	transparent, channels := false, 3
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := quantizeColor(img, x, y, binaryAlpha)
+			if c.A == 0 {
+				transparent = true
+				continue
+			}
+			if c.A < 255 {
+				channels = 4
+			}
+			pixels = append(pixels, c)
+		}
+	}
+
+	samples := pixels
+	if len(pixels) > quantizeSamples {
+		step := len(pixels) / quantizeSamples
+		samples = make(colorBox, 0, quantizeSamples+1)
+		for i := 0; i < len(pixels); i += step {
+			samples = append(samples, pixels[i])
+		}
+	}
+
+	if transparent {
+		n--
+	}
+	var palette color.Palette
+	if len(samples) > 0 {
+		for _, box := range splitColorBoxes(samples, n, channels) {
+			c := box.mean()
+			c.A = box.meanAlpha()
+			palette = append(palette, c)
+		}
+	}
+	if transparent {
+		palette = append(palette, color.NRGBA{})
+	}
+	return palette
+}
+
+// mapPalette converts the image to a paletted image, diffusing the given amount of
+// the quantisation error to the neighbour pixels via Floyd-Steinberg dithering.
+func mapPalette(img image.Image, palette color.Palette, dither float64, binaryAlpha bool) *image.Paletted {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	out := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+
+	colors := make([][4]float64, len(palette))
+	for i, c := range palette {
+		n := c.(color.NRGBA)
+		colors[i] = [4]float64{float64(n.R), float64(n.G), float64(n.B), float64(n.A)}
+	}
+
+	// Quantisation errors of the current and next rows, with a pixel of padding on each side
+	current := make([][4]float64, width+2)
+	next := make([][4]float64, width+2)
+
+	// Palette lookups are cached if the colours aren't dithered
+	cache := make(map[color.NRGBA]int)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := quantizeColor(img, bounds.Min.X+x, bounds.Min.Y+y, binaryAlpha)
+			if dither == 0 {
+				index, ok := cache[c]
+				if !ok {
+					index = nearestColor(colors, [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)})
+					cache[c] = index
+				}
+				out.Pix[y*out.Stride+x] = uint8(index)
+				continue
+			}
+
+			value := [4]float64{float64(c.R), float64(c.G), float64(c.B), float64(c.A)}
+			if c.A > 0 {
+				for i := range value {
+					value[i] = math.Max(0, math.Min(255, value[i]+current[x+1][i]))
+				}
+			}
+
+			index := nearestColor(colors, value)
+			out.Pix[y*out.Stride+x] = uint8(index)
+			if c.A == 0 {
+				continue
+			}
+
+			for i := range value {
+				diff := (value[i] - colors[index][i]) * dither
+				current[x+2][i] += diff * 7 / 16
+				next[x][i] += diff * 3 / 16
+				next[x+1][i] += diff * 5 / 16
+				next[x+2][i] += diff * 1 / 16
+			}
+		}
+		current, next = next, current
+		for i := range next {
+			next[i] = [4]float64{}
+		}
+	}
+
+	return out
+}
+
+// nearestColor returns the index of the palette colour closest to the given RGBA value.
+func nearestColor(colors [][4]float64, value [4]float64) int {
+	index, best := 0, math.MaxFloat64
+	for i, c := range colors {
+		var distance float64
+		for j := range c {
+			diff := c[j] - value[j]
+			distance += diff * diff
+		}
+		if distance < best {
+			index, best = i, distance
+		}
+	}
+	return index
+}
+
+// quantizationQuality returns the quality of the quantised image, between 0 and 100,
+// derived from its PSNR: 0 up to 20 dB and 100 from 50 dB.
+func quantizationQuality(img image.Image, paletted *image.Paletted) int {
+	bounds := img.Bounds()
+	var mse float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			a := quantizeColor(img, bounds.Min.X+x, bounds.Min.Y+y, false)
+			b := paletted.Palette[paletted.Pix[y*paletted.Stride+x]].(color.NRGBA)
+			for _, diff := range []float64{
+				float64(a.R) - float64(b.R), float64(a.G) - float64(b.G),
+				float64(a.B) - float64(b.B), float64(a.A) - float64(b.A),
+			} {
+				mse += diff * diff
+			}
+		}
+	}
+	mse /= float64(bounds.Dx() * bounds.Dy() * 4)
+	if mse == 0 {
+		return 100
+	}
+
+	psnr := 10 * math.Log10(255*255/mse)
+	return int(math.Max(0, math.Min(100, math.Round((psnr-20)*100/30))))
+}
+
+// copyPNGMetadata copies the metadata chunks of the source PNG image to the given PNG image.
+func copyPNGMetadata(src, dst []byte) ([]byte, error) {
+	srcChunks, err := readPNGChunks(src)
+	if err != nil {
+		return nil, err
+	}
+	dstChunks, err := readPNGChunks(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metadata chunks are placed right after the header chunk
+	chunks := []pngChunk{dstChunks[0]}
+	for _, chunk := range srcChunks {
+		if pngMetadataChunks[chunk.Type] {
+			chunks = append(chunks, chunk)
+		}
+	}
+	chunks = append(chunks, dstChunks[1:]...)
+	return writePNGChunks(chunks), nil
+}
+
+// pngCompressionLevel maps the zlib compression level, between 0 and 9, to a Go PNG compression level.
+// The zero value is the bimg default compression level.
+func pngCompressionLevel(compression int) png.CompressionLevel {
+	switch {
+	case compression == 0:
+		return png.DefaultCompression
+	case compression <= 3:
+		return png.BestSpeed
+	case compression <= 6:
+		return png.DefaultCompression
+	default:
+		return png.BestCompression
+	}
+}
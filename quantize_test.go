@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+// newTestIcon returns a PNG icon with three opaque colours, a semi-transparent one and transparent pixels.
+func newTestIcon() []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 128, 0, 255}, {0, 0, 255, 255}, {0, 0, 255, 100}, {}}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, colors[(x+y)%len(colors)])
+		}
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// newTestGradient returns a true colour PNG gradient.
+func newTestGradient() []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), uint8((x + y) * 2), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestQuantizeLossless(t *testing.T) {
+	icon := newTestIcon()
+	out, err := quantizeImage(icon, bimg.PNG, Quantization{Colors: 8, Dither: 1, Auto: true}, 0)
+	if err != nil {
+		t.Fatalf("Cannot quantize image: %s", err)
+	}
+
+	img, _ := png.Decode(bytes.NewReader(out))
+	paletted, ok := img.(*image.Paletted)
+	if !ok || len(paletted.Palette) != 5 {
+		t.Fatalf("Expected a paletted image with 5 colours: %T", img)
+	}
+
+	src, _ := png.Decode(bytes.NewReader(icon))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if quantizeColor(src, x, y, false) != quantizeColor(paletted, x, y, false) {
+				t.Fatalf("Invalid colour at %d,%d: %v", x, y, paletted.At(x, y))
+			}
+		}
+	}
+}
+
+func TestQuantizeAuto(t *testing.T) {
+	gradient := newTestGradient()
+	out, err := quantizeImage(gradient, bimg.PNG, Quantization{Colors: 256, Dither: 1, Auto: true}, 0)
+	if err != nil || !bytes.Equal(out, gradient) {
+		t.Errorf("Expected a true colour image with more than 256 colours: %v", err)
+	}
+}
+
+func TestQuantizeMedianCut(t *testing.T) {
+	gradient := newTestGradient()
+	for _, dither := range []float64{0, 1} {
+		out, err := quantizeImage(gradient, bimg.PNG, Quantization{Colors: 16, Dither: dither}, 0)
+		if err != nil {
+			t.Fatalf("Cannot quantize image: %s", err)
+		}
+		img, _ := png.Decode(bytes.NewReader(out))
+		if paletted, ok := img.(*image.Paletted); !ok || len(paletted.Palette) > 16 {
+			t.Errorf("Expected a paletted image with up to 16 colours: %T", img)
+		}
+	}
+
+	out, _ := quantizeImage(gradient, bimg.PNG, Quantization{Colors: 2, Dither: 1, MinQuality: 90}, 0)
+	if !bytes.Equal(out, gradient) {
+		t.Error("Expected a true colour image below the min quality")
+	}
+}
+
+func TestQuantizeGIF(t *testing.T) {
+	out, err := quantizeImage(newTestIcon(), bimg.GIF, Quantization{Colors: 256, Dither: 1}, 0)
+	if err != nil {
+		t.Fatalf("Cannot quantize image: %s", err)
+	}
+
+	img, err := gif.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("Invalid GIF image: %s", err)
+	}
+	if _, _, _, a := img.At(4, 0).RGBA(); a != 0 {
+		t.Error("Expected a transparent pixel")
+	}
+	if _, _, _, a := img.At(3, 0).RGBA(); a != 0 {
+		t.Error("Expected semi-transparent pixels to be transparent")
+	}
+	if r, _, _, a := img.At(0, 0).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("Expected an opaque red pixel: %v", img.At(0, 0))
+	}
+}
+
+func TestQuantizeMetadata(t *testing.T) {
+	chunks, _ := readPNGChunks(newTestIcon())
+	text := pngChunk{Type: "tEXt", Data: []byte("Copyright\x00Jane Doe")}
+	icon := writePNGChunks(append([]pngChunk{chunks[0], text}, chunks[1:]...))
+
+	out, err := quantizeImage(icon, bimg.PNG, Quantization{Colors: 256}, 9)
+	if err != nil {
+		t.Fatalf("Cannot quantize image: %s", err)
+	}
+	if !bytes.Contains(out, text.Data) {
+		t.Error("PNG metadata not preserved")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("Invalid PNG image: %s", err)
+	}
+}
+
+func TestQuantizationParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{"palette": {"auto"}, "colors": {"64"}, "dither": {"0"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+	q, err := quantization(opts)
+	if err != nil || !q.Auto || q.Colors != 64 || q.Dither != 0 {
+		t.Errorf("Invalid quantization: %#v, %v", q, err)
+	}
+	if !shouldQuantize(bimg.PNG, opts) || shouldQuantize(bimg.JPEG, opts) {
+		t.Error("Invalid quantization output types")
+	}
+
+	opts, _ = buildParamsFromQuery(map[string][]string{"palette": {"true"}})
+	if shouldQuantize(bimg.PNG, opts) || !shouldQuantize(bimg.GIF, opts) {
+		t.Error("Expected the palette param alone to be handled by bimg")
+	}
+
+	if _, err := quantization(ImageOptions{Colors: 300}); err == nil {
+		t.Error("Expected an error for 300 colors")
+	}
+	for key, value := range map[string]string{"dither": "2", "quantquality": "101"} {
+		if _, err := buildParamsFromQuery(map[string][]string{key: {value}}); err == nil {
+			t.Errorf("Expected an error for %s=%s", key, value)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"github.com/h2non/bimg"
+)
+
+// rasterMaxPixels defines the max resolution in megapixels of the images rasterised by imaginary,
+// such as the padded images.
+var rasterMaxPixels = 18.0
+
+// SetRasterLimits defines the max resolution of the rasterised images based on the server options.
+func SetRasterLimits(o ServerOptions) {
+	rasterMaxPixels = o.MaxAllowedPixels
+}
+
+// checkRasterResolution returns an error if the given image size exceeds the max resolution
+// of the rasterised images.
+func checkRasterResolution(width, height int) error {
+	if float64(width)*float64(height)/1000000 > rasterMaxPixels {
+		return ErrResolutionTooBig
+	}
+	return nil
+}
+
+// rasterize decodes the given image buffer via libvips into an in-memory
+// sRGB raster, auto-rotated based on EXIF orientation.
+// If size is greater than zero, the image is downsampled to fit within a
+// size x size box, preserving its aspect ratio.
+func rasterize(buf []byte, size int) (image.Image, error) {
+	opts := bimg.Options{
+		Type:        bimg.PNG,
+		Compression: 1,
+		NoProfile:   true,
+	}
+
+	if size > 0 {
+		meta, err := bimg.Metadata(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height := meta.Size.Width, meta.Size.Height
+		if meta.Orientation > 4 {
+			// width/height will be switched with auto rotation
+			width, height = height, width
+		}
+
+		if width > size || height > size {
+			if width >= height {
+				opts.Width = size
+			} else {
+				opts.Height = size
+			}
+		}
+	}
+
+	out, err := bimg.Resize(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return png.Decode(bytes.NewReader(out))
+}
+
+// resizeRaster resizes the given raster to the exact width and height via libvips,
+// returning it unchanged if it already has the requested size.
+func resizeRaster(img image.Image, width, height int) (image.Image, error) {
+	if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+		return img, nil
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	out, err := bimg.Resize(buf.Bytes(), bimg.Options{
+		Type:         bimg.PNG,
+		Compression:  1,
+		Width:        width,
+		Height:       height,
+		Force:        true,
+		NoAutoRotate: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return png.Decode(bytes.NewReader(out))
+}
+
+// decodeRaster decodes the image losslessly into a raster, auto-rotated based on EXIF orientation
+// unless noRotation is true, along with the PNG image it's decoded from, which keeps the colour
+// profile and metadata of the image.
+func decodeRaster(buf []byte, noRotation bool) ([]byte, *image.NRGBA, error) {
+	base, err := bimg.Resize(buf, bimg.Options{Type: bimg.PNG, Compression: 1, NoAutoRotate: noRotation})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src, err := png.Decode(bytes.NewReader(base))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	return base, img, nil
+}
+
+// processRaster encodes the raster with the colour profile and metadata of the base PNG image
+// it was decoded from, and processes it with the given options, leaving the encoding to the final pass.
+func processRaster(base []byte, img image.Image, opts bimg.Options, o ImageOptions) (Image, error) {
+	var out bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&out, img); err != nil {
+		return Image{}, err
+	}
+
+	body, err := copyPNGMetadata(base, out.Bytes())
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Process(body, opts, o)
+}
+
+// rasterOutputType returns the output image type of the processed rasters,
+// being the source image type by default.
+func rasterOutputType(buf []byte, o ImageOptions) bimg.ImageType {
+	if imageType := ImageType(o.Type); imageType != bimg.UNKNOWN {
+		return imageType
+	}
+	return composeDefaultType(buf)
+}
+
+// fillColor returns the background colour of the areas added to the image, with an optional alpha
+// component. It's transparent by default, or white for JPEG output images.
+func fillColor(o ImageOptions, outputType bimg.ImageType) color.NRGBA {
+	switch {
+	case len(o.Background) > 3:
+		return color.NRGBA{R: o.Background[0], G: o.Background[1], B: o.Background[2], A: o.Background[3]}
+	case len(o.Background) > 2:
+		return color.NRGBA{R: o.Background[0], G: o.Background[1], B: o.Background[2], A: 255}
+	case outputType == bimg.JPEG:
+		return color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	return color.NRGBA{}
+}
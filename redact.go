@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+// Redaction modes of the redact operation.
+const (
+	RedactPixelate = "pixelate"
+	RedactBlur     = "blur"
+	RedactFill     = "fill"
+)
+
+const (
+	// redactMaxRegions defines the max number of regions redacted per image.
+	redactMaxRegions = 100
+	// redactMaxSigma defines the max gaussian blur sigma of the redacted regions.
+	redactMaxSigma = 100
+)
+
+// RedactRegion represents a rectangle of the image to redact, in pixels
+// or relative to the image size if Relative is true.
+type RedactRegion struct {
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Width    float64 `json:"width"`
+	Height   float64 `json:"height"`
+	Mode     string  `json:"mode,omitempty"`
+	Relative bool    `json:"relative,omitempty"`
+}
+
+// Redact obscures the given regions of the image via pixelation, gaussian blur or solid fill,
+// leaving the rest of the image unmodified.
+func Redact(buf []byte, o ImageOptions) (Image, error) {
+	if len(o.Regions) == 0 {
+		return Image{}, NewError("Missing required param: regions", http.StatusBadRequest)
+	}
+
+	if o.Sigma < 0 || o.Sigma > redactMaxSigma {
+		return Image{}, NewError(fmt.Sprintf("Invalid sigma param: must be between 0 and %d", redactMaxSigma), http.StatusBadRequest)
+	}
+	if o.BlockSize < 0 {
+		return Image{}, NewError("Invalid blocksize param: must be greater than 0", http.StatusBadRequest)
+	}
+
+	mode := o.Mode
+	if mode == "" {
+		mode = RedactPixelate
+	}
+
+	base, img, err := decodeRaster(buf, o.NoRotation)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// Embedded thumbnails would show the redacted regions, so they're always removed,
+	// along with the EXIF thumbnail image directory
+	if base, err = filterMetadata(base, MetadataPolicy{Strip: []string{metadataThumbnails}}); err != nil {
+		return Image{}, err
+	}
+
+	for i, region := range o.Regions {
+		rect, err := regionBounds(region, o.Relative, img.Bounds())
+		if err != nil {
+			return Image{}, NewError(fmt.Sprintf("Invalid region %d: %s", i, err), http.StatusBadRequest)
+		}
+
+		regionMode := region.Mode
+		if regionMode == "" {
+			regionMode = mode
+		}
+		switch regionMode {
+		case RedactPixelate:
+			pixelate(img, rect, o.BlockSize)
+		case RedactBlur:
+			blurRegion(img, rect, o.Sigma)
+		case RedactFill:
+			fill := color.NRGBA{A: 255}
+			if len(o.Color) > 2 {
+				fill = color.NRGBA{R: o.Color[0], G: o.Color[1], B: o.Color[2], A: 255}
+			}
+			draw.Draw(img, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+		}
+	}
+
+	opts := BimgOptions(o)
+	opts.GaussianBlur = bimg.GaussianBlur{}
+	opts.Type = rasterOutputType(buf, o)
+	return processRaster(base, img, opts, o)
+}
+
+// regionBounds returns the pixel bounds of the region within the image bounds.
+func regionBounds(region RedactRegion, relative bool, bounds image.Rectangle) (image.Rectangle, error) {
+	x, y, width, height := region.X, region.Y, region.Width, region.Height
+	if relative || region.Relative {
+		if x > 1 || y > 1 || width > 1 || height > 1 {
+			return image.Rectangle{}, fmt.Errorf("relative values must be between 0 and 1")
+		}
+		x, width = x*float64(bounds.Dx()), width*float64(bounds.Dx())
+		y, height = y*float64(bounds.Dy()), height*float64(bounds.Dy())
+	}
+
+	// Round outwards, so the whole region is always redacted
+	rect := image.Rect(int(math.Floor(x)), int(math.Floor(y)), int(math.Ceil(x+width)), int(math.Ceil(y+height)))
+	rect = rect.Intersect(bounds)
+	if rect.Empty() {
+		return rect, fmt.Errorf("region outside of the image")
+	}
+	return rect, nil
+}
+
+// pixelate replaces each block of the region by its average colour. The block size defaults
+// to an eighth of the smallest region dimension, and is at least 4 pixels.
+func pixelate(img *image.NRGBA, rect image.Rectangle, size int) {
+	if size == 0 {
+		size = int(math.Max(4, math.Ceil(math.Min(float64(rect.Dx()), float64(rect.Dy()))/8)))
+	}
+
+	for by := rect.Min.Y; by < rect.Max.Y; by += size {
+		for bx := rect.Min.X; bx < rect.Max.X; bx += size {
+			block := image.Rect(bx, by, bx+size, by+size).Intersect(rect)
+
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					c := img.NRGBAAt(x, y)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+				}
+			}
+
+			n := block.Dx() * block.Dy()
+			mean := color.NRGBA{
+				R: uint8((sum[0] + n/2) / n),
+				G: uint8((sum[1] + n/2) / n),
+				B: uint8((sum[2] + n/2) / n),
+				A: uint8((sum[3] + n/2) / n),
+			}
+			draw.Draw(img, block, image.NewUniform(mean), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// blurRegion applies a gaussian blur to the region, only reading its own pixels.
+// The sigma defaults to a quarter of the smallest region dimension, between 4 and the max sigma.
+func blurRegion(img *image.NRGBA, rect image.Rectangle, sigma float64) {
+	if sigma == 0 {
+		sigma = math.Max(4, math.Min(redactMaxSigma, math.Min(float64(rect.Dx()), float64(rect.Dy()))/4))
+	}
+
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, radius*2+1)
+
+	var sum float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	// The region edges are extended, so the pixels around the region don't bleed into it
+	convolve := func(src *image.NRGBA, dx, dy int) *image.NRGBA {
+		out := image.NewNRGBA(rect)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				var v [4]float64
+				for i, k := range kernel {
+					px := clampInt(x+(i-radius)*dx, rect.Min.X, rect.Max.X-1)
+					py := clampInt(y+(i-radius)*dy, rect.Min.Y, rect.Max.Y-1)
+					c := src.NRGBAAt(px, py)
+					v[0] += k * float64(c.R)
+					v[1] += k * float64(c.G)
+					v[2] += k * float64(c.B)
+					v[3] += k * float64(c.A)
+				}
+				out.SetNRGBA(x, y, color.NRGBA{
+					R: uint8(math.Round(v[0])),
+					G: uint8(math.Round(v[1])),
+					B: uint8(math.Round(v[2])),
+					A: uint8(math.Round(v[3])),
+				})
+			}
+		}
+		return out
+	}
+
+	blurred := convolve(convolve(img, 1, 0), 0, 1)
+	draw.Draw(img, rect, blurred, rect.Min, draw.Src)
+}
+
+// parseRegions parses the regions param, being either a JSON array of regions or a semicolon
+// separated list of regions defined as x,y,width,height, with an optional redaction mode.
+// Example: 10,20,100,50;0,0,40,40,blur
+func parseRegions(param interface{}) ([]RedactRegion, error) {
+	var regions []RedactRegion
+	switch v := param.(type) {
+	case string:
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "[") {
+			if err := json.Unmarshal([]byte(v), &regions); err != nil {
+				return nil, err
+			}
+			break
+		}
+		for _, def := range strings.Split(v, ";") {
+			parts := strings.Split(strings.TrimSpace(def), ",")
+			if len(parts) != 4 && len(parts) != 5 {
+				return nil, ErrUnsupportedValue
+			}
+
+			var values [4]float64
+			for i := range values {
+				value, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+				if err != nil {
+					return nil, ErrUnsupportedValue
+				}
+				values[i] = value
+			}
+
+			region := RedactRegion{X: values[0], Y: values[1], Width: values[2], Height: values[3]}
+			if len(parts) == 5 {
+				region.Mode = strings.TrimSpace(parts[4])
+			}
+			regions = append(regions, region)
+		}
+	case []interface{}:
+		// Regions of the pipeline operation params
+		data, _ := json.Marshal(v)
+		if err := json.Unmarshal(data, &regions); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedValue
+	}
+
+	if len(regions) > redactMaxRegions {
+		return nil, ErrUnsupportedValue
+	}
+	for _, region := range regions {
+		for _, value := range []float64{region.X, region.Y, region.Width, region.Height} {
+			if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, ErrUnsupportedValue
+			}
+		}
+		if region.Width == 0 || region.Height == 0 || (region.Mode != "" && !isValidRedactMode(region.Mode)) {
+			return nil, ErrUnsupportedValue
+		}
+	}
+	return regions, nil
+}
+
+func isValidRedactMode(mode string) bool {
+	return mode == RedactPixelate || mode == RedactBlur || mode == RedactFill
+}
+
+func clampInt(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
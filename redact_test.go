@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// newTestCheckerboard returns an image with a checkerboard of black and white pixels.
+func newTestCheckerboard(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x+y)%2 == 0 {
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestRedact(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"regions": {"0.1,0.1,0.2,0.2;0.5,0.5,0.2,0.2,fill"}, "relative": {"true"}})
+	img, err := Redact(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	if _, err := Redact(buf, ImageOptions{}); err == nil {
+		t.Error("Expected an error for missing regions")
+	}
+}
+
+func TestRedactRegions(t *testing.T) {
+	img := newTestCheckerboard(16, 16)
+	rect := image.Rect(4, 4, 12, 12)
+
+	pixelate(img, rect, 4)
+	if c := img.NRGBAAt(4, 4); c != (color.NRGBA{128, 128, 128, 255}) {
+		t.Errorf("Invalid pixelated colour: %v", c)
+	}
+	if img.NRGBAAt(3, 4) != (color.NRGBA{0, 0, 0, 255}) || img.NRGBAAt(12, 12) != (color.NRGBA{255, 255, 255, 255}) {
+		t.Error("Pixels outside of the region must not be modified")
+	}
+
+	img = newTestCheckerboard(16, 16)
+	blurRegion(img, rect, 2)
+	for _, p := range []image.Point{{4, 4}, {7, 8}, {11, 11}} {
+		if c := img.NRGBAAt(p.X, p.Y); c.R < 64 || c.R > 192 {
+			t.Errorf("Invalid blurred colour at %v: %v", p, c)
+		}
+	}
+	if img.NRGBAAt(12, 11) != (color.NRGBA{0, 0, 0, 255}) {
+		t.Error("Pixels outside of the region must not be modified")
+	}
+}
+
+func TestRegionBounds(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	cases := []struct {
+		region   RedactRegion
+		relative bool
+		expected image.Rectangle
+	}{
+		{RedactRegion{X: 10, Y: 20, Width: 30, Height: 40}, false, image.Rect(10, 20, 40, 60)},
+		{RedactRegion{X: 10.5, Y: 20.5, Width: 30, Height: 40}, false, image.Rect(10, 20, 41, 61)},
+		{RedactRegion{X: 180, Y: 90, Width: 50, Height: 50}, false, image.Rect(180, 90, 200, 100)},
+		{RedactRegion{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.25}, true, image.Rect(50, 50, 150, 75)},
+		{RedactRegion{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.25, Relative: true}, false, image.Rect(50, 50, 150, 75)},
+	}
+	for _, tc := range cases {
+		rect, err := regionBounds(tc.region, tc.relative, bounds)
+		if err != nil || rect != tc.expected {
+			t.Errorf("Invalid region bounds: %v != %v, %v", rect, tc.expected, err)
+		}
+	}
+
+	if _, err := regionBounds(RedactRegion{X: 300, Y: 0, Width: 10, Height: 10}, false, bounds); err == nil {
+		t.Error("Expected an error for a region outside of the image")
+	}
+	if _, err := regionBounds(RedactRegion{X: 0, Y: 0, Width: 2, Height: 1}, true, bounds); err == nil {
+		t.Error("Expected an error for relative values greater than 1")
+	}
+}
+
+func TestRedactParams(t *testing.T) {
+	opts, err := buildParamsFromQuery(map[string][]string{"regions": {"10,20,30,40;0,0,5,5,blur"}, "mode": {"fill"}})
+	if err != nil {
+		t.Fatalf("Failed reading params: %s", err)
+	}
+	if len(opts.Regions) != 2 || opts.Regions[0] != (RedactRegion{X: 10, Y: 20, Width: 30, Height: 40}) ||
+		opts.Regions[1].Mode != RedactBlur || opts.Mode != RedactFill {
+		t.Errorf("Invalid regions: %#v", opts.Regions)
+	}
+
+	opts, err = buildParamsFromQuery(map[string][]string{"regions": {`[{"x":0.1,"y":0.2,"width":0.3,"height":0.4,"relative":true}]`}})
+	if err != nil || len(opts.Regions) != 1 || !opts.Regions[0].Relative {
+		t.Errorf("Invalid JSON regions: %#v, %v", opts.Regions, err)
+	}
+
+	operation := PipelineOperation{Params: map[string]interface{}{
+		"regions": []interface{}{map[string]interface{}{"x": 1.0, "y": 2.0, "width": 3.0, "height": 4.0, "mode": "pixelate"}},
+	}}
+	opts, err = buildParamsFromOperation(operation)
+	if err != nil || len(opts.Regions) != 1 || opts.Regions[0].Height != 4 || opts.Regions[0].Mode != RedactPixelate {
+		t.Errorf("Invalid operation regions: %#v, %v", opts.Regions, err)
+	}
+
+	for _, regions := range []string{"10,20,30", "10,20,0,40", "-10,20,30,40", "10,20,30,40,smudge", "NaN,0,1,1", `[{"x":"1"}]`} {
+		if _, err := buildParamsFromQuery(map[string][]string{"regions": {regions}}); err == nil {
+			t.Errorf("Expected an error for regions=%s", regions)
+		}
+	}
+	if _, err := buildParamsFromQuery(map[string][]string{"mode": {"smudge"}}); err == nil {
+		t.Error("Expected an error for an unsupported mode")
+	}
+
+	// Pipeline numbers are not coerced to absolute values such as the query params
+	for _, blocksize := range []float64{-1, 0} {
+		operation := PipelineOperation{Params: map[string]interface{}{"regions": "0,0,10,10", "blocksize": blocksize}}
+		if _, err := buildParamsFromOperation(operation); err == nil {
+			t.Errorf("Expected an error for blocksize=%v", blocksize)
+		}
+	}
+	for _, sigma := range []float64{-1, 1e6} {
+		o := ImageOptions{Regions: []RedactRegion{{Width: 10, Height: 10}}, Mode: RedactBlur, Sigma: sigma}
+		if _, err := Redact(nil, o); err == nil {
+			t.Errorf("Expected an error for sigma=%v", sigma)
+		}
+	}
+}
+
+// testThumbnail defines the data of the embedded test thumbnails.
+const testThumbnail = "\xff\xd8UNREDACTED THUMBNAIL\xff\xd9"
+
+// newTestThumbnailEXIF returns EXIF data with a thumbnail image directory.
+func newTestThumbnailEXIF() []byte {
+	buf := newTestEXIF([]testTIFFField{asciiField(0x010f, "Imaginary")}, nil, nil)
+
+	// Link the thumbnail directory from IFD0, holding a single field
+	offset := len(buf)
+	binary.BigEndian.PutUint32(buf[8+2+12:], uint32(offset))
+
+	ifd1 := make([]byte, 2+2*12+4)
+	binary.BigEndian.PutUint16(ifd1[0:], 2)
+	for i, field := range [][2]uint32{{0x0201, uint32(offset + len(ifd1))}, {0x0202, uint32(len(testThumbnail))}} {
+		entry := ifd1[2+i*12:]
+		binary.BigEndian.PutUint16(entry[0:], uint16(field[0]))
+		binary.BigEndian.PutUint16(entry[2:], tiffLong)
+		binary.BigEndian.PutUint32(entry[4:], 1)
+		binary.BigEndian.PutUint32(entry[8:], field[1])
+	}
+	return append(append(buf, ifd1...), testThumbnail...)
+}
+
+func TestRedactThumbnails(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	segments, start, _ := readJPEGSegments(buf)
+	segments = append([]jpegSegment{{Marker: jpegAPP1, Data: append([]byte(jpegEXIFSignature), newTestThumbnailEXIF()...)}}, segments...)
+	buf = writeJPEGSegments(segments, buf[start:])
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"regions": {"0,0,100,100"}})
+	img, err := Redact(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot redact image: %s", err)
+	}
+	if bytes.Contains(img.Body, []byte(testThumbnail)) {
+		t.Error("The EXIF thumbnail must be removed from redacted images")
+	}
+}
+
+func TestFilterThumbnails(t *testing.T) {
+	xmp := strings.Replace(testGPSXMP, "<exif:GPSLongitude>",
+		"<xmp:Thumbnails><rdf:Alt><rdf:li>UNREDACTED THUMBNAIL</rdf:li></rdf:Alt></xmp:Thumbnails><exif:GPSLongitude>", 1)
+	png := writePNGChunks([]pngChunk{
+		{Type: "IHDR", Data: []byte{0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0}},
+		{Type: "eXIf", Data: newTestThumbnailEXIF()},
+		{Type: "iTXt", Data: append([]byte(pngXMPKeyword+"\x00\x00\x00\x00"), xmp...)},
+		{Type: "IEND"},
+	})
+
+	out, err := filterMetadata(png, MetadataPolicy{Strip: []string{metadataThumbnails}})
+	if err != nil {
+		t.Fatalf("Cannot filter metadata: %s", err)
+	}
+	if bytes.Contains(out, []byte("UNREDACTED THUMBNAIL")) {
+		t.Error("The EXIF and XMP thumbnails must be removed")
+	}
+
+	var info ImageInfo
+	addMetadataGroups(&info, readMetadata(out), nil)
+	if info.EXIF["Make"] != "Imaginary" || info.XMP["xmp:Rating"] != "4" {
+		t.Errorf("Metadata not kept: %#v, %#v", info.EXIF, info.XMP)
+	}
+
+	if _, err := buildParamsFromQuery(map[string][]string{"strip": {metadataThumbnails}}); err == nil {
+		t.Error("The thumbnails metadata group must not be supported by the strip param")
+	}
+}
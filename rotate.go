@@ -0,0 +1,145 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// normalizeAngle returns the clockwise rotation angle between 0 and 360 degrees.
+func normalizeAngle(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	return angle
+}
+
+// isRightAngle returns true if the angle is a multiple of 90 degrees, supported by bimg.
+func isRightAngle(angle float64) bool {
+	return math.Mod(angle, 90) == 0
+}
+
+// rotateImage rotates the image clockwise by an arbitrary angle, once auto rotated based on
+// its EXIF orientation. The corners are filled with the background colour, being transparent by
+// default, or white for JPEG output images. The rotated image is optionally cropped to the
+// largest rectangle inscribed in the rotated image, removing the corners.
+func rotateImage(buf []byte, angle float64, o ImageOptions) (Image, error) {
+	base, img, err := decodeRaster(buf, o.NoRotation)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// The image is already rotated once processed
+	o.Rotate = 0
+	opts := BimgOptions(o)
+	opts.Type = rasterOutputType(buf, o)
+
+	rotated, err := rotateRaster(img, angle, fillColor(o, opts.Type), o.RotateCrop)
+	if err != nil {
+		return Image{}, err
+	}
+	return processRaster(base, rotated, opts, o)
+}
+
+// rotateRaster rotates the image clockwise by the given angle via bilinear interpolation.
+// The output image is the bounding box of the rotated image or, if crop is true,
+// the largest rectangle inscribed in the rotated image.
+func rotateRaster(img *image.NRGBA, angle float64, background color.NRGBA, crop bool) (*image.NRGBA, error) {
+	width, height := float64(img.Bounds().Dx()), float64(img.Bounds().Dy())
+	radians := angle * math.Pi / 180
+	sin, cos := math.Sin(radians), math.Cos(radians)
+
+	outWidth := math.Abs(width*cos) + math.Abs(height*sin)
+	outHeight := math.Abs(width*sin) + math.Abs(height*cos)
+	if crop {
+		outWidth, outHeight = inscribedRectangle(width, height, radians)
+	}
+	// Round to avoid off by one sizes due to floating point errors
+	w := int(math.Max(1, math.Floor(outWidth+1e-6)))
+	h := int(math.Max(1, math.Floor(outHeight+1e-6)))
+	if err := checkRasterResolution(w, h); err != nil {
+		return nil, err
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	// Colours are interpolated with premultiplied alpha, so transparent pixels don't darken the edges
+	bg := premultiply(background)
+	sample := func(x, y int) [4]float64 {
+		if x < 0 || y < 0 || x >= img.Bounds().Dx() || y >= img.Bounds().Dy() {
+			return bg
+		}
+		return premultiply(img.NRGBAAt(x, y))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Map the centre of the output pixel to the source image, rotating it counterclockwise
+			dx, dy := float64(x)+0.5-float64(w)/2, float64(y)+0.5-float64(h)/2
+			sx := dx*cos + dy*sin + width/2 - 0.5
+			sy := -dx*sin + dy*cos + height/2 - 0.5
+
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+
+			var c [4]float64
+			for i, corner := range [4][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				weight := (1 - fx) * (1 - fy)
+				switch i {
+				case 1:
+					weight = fx * (1 - fy)
+				case 2:
+					weight = (1 - fx) * fy
+				case 3:
+					weight = fx * fy
+				}
+				if weight == 0 {
+					continue
+				}
+				p := sample(x0+corner[0], y0+corner[1])
+				for j := range c {
+					c[j] += p[j] * weight
+				}
+			}
+			out.SetNRGBA(x, y, unpremultiply(c))
+		}
+	}
+
+	return out, nil
+}
+
+// inscribedRectangle returns the size of the largest axis-aligned rectangle
+// inscribed in a width x height rectangle rotated by the given angle in radians.
+func inscribedRectangle(width, height, radians float64) (float64, float64) {
+	sin, cos := math.Abs(math.Sin(radians)), math.Abs(math.Cos(radians))
+	long, short := math.Max(width, height), math.Min(width, height)
+
+	// Half constrained case: two crop corners touch the longer side
+	if short <= 2*sin*cos*long || math.Abs(sin-cos) < 1e-10 {
+		x := short / 2
+		if width >= height {
+			return x / sin, x / cos
+		}
+		return x / cos, x / sin
+	}
+
+	// Fully constrained case: the crop touches all four sides
+	cos2 := cos*cos - sin*sin
+	return (width*cos - height*sin) / cos2, (height*cos - width*sin) / cos2
+}
+
+func premultiply(c color.NRGBA) [4]float64 {
+	a := float64(c.A) / 255
+	return [4]float64{float64(c.R) * a, float64(c.G) * a, float64(c.B) * a, float64(c.A)}
+}
+
+func unpremultiply(c [4]float64) color.NRGBA {
+	if c[3] < 0.5 {
+		return color.NRGBA{}
+	}
+	a := c[3] / 255
+	channel := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(255, v/a))))
+	}
+	return color.NRGBA{R: channel(c[0]), G: channel(c[1]), B: channel(c[2]), A: uint8(math.Round(math.Min(255, c[3])))}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestRotateArbitraryAngle(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Rotate(buf, ImageOptions{Rotate: 3.5, RotateCrop: true})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/jpeg" {
+		t.Error("Invalid image MIME type")
+	}
+
+	size, _ := bimg.Size(img.Body)
+	source, _ := bimg.Size(buf)
+	if size.Width >= source.Width || size.Height >= source.Height {
+		t.Errorf("Expected a cropped image: %dx%d", size.Width, size.Height)
+	}
+}
+
+func TestRotateRaster(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+
+	out, _ := rotateRaster(src, 90, color.NRGBA{}, false)
+	if out.Bounds().Dx() != 20 || out.Bounds().Dy() != 40 {
+		t.Errorf("Invalid rotated size: %v", out.Bounds())
+	}
+
+	out, _ = rotateRaster(src, 30, color.NRGBA{}, false)
+	width, height := 40*math.Cos(math.Pi/6)+20*0.5, 40*0.5+20*math.Cos(math.Pi/6)
+	if out.Bounds().Dx() != int(width) || out.Bounds().Dy() != int(height) {
+		t.Errorf("Invalid rotated size: %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(0, 0); c.A != 0 {
+		t.Errorf("Expected a transparent corner: %v", c)
+	}
+	if c := out.NRGBAAt(out.Bounds().Dx()/2, out.Bounds().Dy()/2); c != (color.NRGBA{255, 255, 255, 255}) {
+		t.Errorf("Invalid centre colour: %v", c)
+	}
+
+	out, _ = rotateRaster(src, 30, color.NRGBA{R: 255, A: 255}, false)
+	if c := out.NRGBAAt(0, 0); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("Expected a background corner: %v", c)
+	}
+
+	out, _ = rotateRaster(src, 30, color.NRGBA{}, true)
+	for _, p := range []image.Point{{0, 0}, {out.Bounds().Dx() - 1, 0}, {0, out.Bounds().Dy() - 1}, {out.Bounds().Dx() - 1, out.Bounds().Dy() - 1}} {
+		if c := out.NRGBAAt(p.X, p.Y); c.A < 250 {
+			t.Errorf("Expected an opaque corner at %v: %v", p, c)
+		}
+	}
+
+	defer func(max float64) { rasterMaxPixels = max }(rasterMaxPixels)
+	rasterMaxPixels = 800e-6
+	if _, err := rotateRaster(src, 30, color.NRGBA{}, false); err != ErrResolutionTooBig {
+		t.Errorf("Expected a resolution error, got: %v", err)
+	}
+	if _, err := rotateRaster(src, 30, color.NRGBA{}, true); err != nil {
+		t.Errorf("Unexpected error for a cropped image: %v", err)
+	}
+}
+
+func TestArbitraryAngleOutsideRotate(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+	for _, operation := range []Operation{Resize, Crop, Flip} {
+		_, err := operation(buf, ImageOptions{Width: 100, Height: 100, Rotate: 45})
+		if e, ok := err.(Error); !ok || e.Code != 400 {
+			t.Errorf("Expected a bad request error, got: %v", err)
+		}
+	}
+}
+
+func TestInscribedRectangle(t *testing.T) {
+	// A square rotated by 45 degrees inscribes a square of half its diagonal
+	width, height := inscribedRectangle(100, 100, math.Pi/4)
+	if math.Abs(width-100/math.Sqrt2) > 1e-6 || math.Abs(height-100/math.Sqrt2) > 1e-6 {
+		t.Errorf("Invalid inscribed rectangle: %fx%f", width, height)
+	}
+
+	width, height = inscribedRectangle(400, 300, 3.5*math.Pi/180)
+	if width >= 400 || height >= 300 || width < 370 || height < 270 {
+		t.Errorf("Invalid inscribed rectangle: %fx%f", width, height)
+	}
+}
+
+func TestRotateParams(t *testing.T) {
+	for value, expected := range map[string]float64{"3.5": 3.5, "-3.5": 356.5, "90": 90, "-90": 270, "450": 90} {
+		opts, err := buildParamsFromQuery(map[string][]string{"rotate": {value}})
+		if err != nil {
+			t.Fatalf("Failed reading params: %s", err)
+		}
+		if angle := normalizeAngle(opts.Rotate); math.Abs(angle-expected) > 1e-9 {
+			t.Errorf("Invalid rotation angle for %s: %f", value, angle)
+		}
+	}
+
+	opts, _ := buildParamsFromQuery(map[string][]string{"rotate": {"-90"}})
+	if BimgOptions(opts).Rotate != bimg.D270 {
+		t.Errorf("Invalid bimg rotation angle: %d", BimgOptions(opts).Rotate)
+	}
+	for _, value := range []string{"NaN", "Inf", "right"} {
+		if _, err := buildParamsFromQuery(map[string][]string{"rotate": {value}}); err == nil {
+			t.Errorf("Expected an error for rotate=%s", value)
+		}
+	}
+}
@@ -45,6 +45,16 @@ type ServerOptions struct {
 	AllowedOrigins     []*url.URL
 	LogLevel           string
 	ReturnSize         bool
+	WatermarksDir      string
+	WatermarkCacheTTL  int
+	MaxAnimationFrames int
+	MaxAnimationPixels float64
+	MetadataPolicy     MetadataPolicy
+	ProfilesDir        string
+	LUTsDir            string
+	EnableClientHints  bool
+	MaxDPR             float64
+	EncoderDefaults    EncoderOptions
 }
 
 // Endpoints represents a list of endpoint names to disable.
@@ -52,8 +62,7 @@ type Endpoints []string
 
 // IsValid validates if a given HTTP request endpoint is valid or not.
 func (e Endpoints) IsValid(r *http.Request) bool {
-	parts := strings.Split(r.URL.Path, "/")
-	endpoint := parts[len(parts)-1]
+	endpoint := endpointName(r)
 	for _, name := range e {
 		if endpoint == name {
 			return false
@@ -62,6 +71,12 @@ func (e Endpoints) IsValid(r *http.Request) bool {
 	return true
 }
 
+// endpointName returns the name of the HTTP request endpoint, being the last element of its path.
+func endpointName(r *http.Request) string {
+	parts := strings.Split(r.URL.Path, "/")
+	return parts[len(parts)-1]
+}
+
 func Server(o ServerOptions) {
 	addr := o.Address + ":" + strconv.Itoa(o.Port)
 	handler := NewLog(NewServerMux(o), os.Stdout, o.LogLevel)
@@ -133,8 +148,18 @@ func NewServerMux(o ServerOptions) http.Handler {
 	mux.Handle(join(o, "/watermark"), image(Watermark))
 	mux.Handle(join(o, "/watermarkimage"), image(WatermarkImage))
 	mux.Handle(join(o, "/info"), image(Info))
+	mux.Handle(join(o, "/palette"), image(Palette))
+	mux.Handle(join(o, "/stats"), image(Stats))
 	mux.Handle(join(o, "/blur"), image(GaussianBlur))
+	mux.Handle(join(o, "/filter"), image(Filter))
+	mux.Handle(join(o, "/redact"), image(Redact))
+	mux.Handle(join(o, "/pad"), image(Pad))
+	mux.Handle(join(o, "/border"), image(Border))
 	mux.Handle(join(o, "/pipeline"), image(Pipeline))
+	mux.Handle(join(o, "/compose"), imageMiddleware(composeController(o), o))
+	mux.Handle(join(o, "/hash"), image(Hash))
+	mux.Handle(join(o, "/hash/compare"), imageMiddleware(hashCompareController(o), o))
+	mux.Handle(join(o, "/compare"), imageMiddleware(compareController(o), o))
 
 	return mux
 }
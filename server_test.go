@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -405,6 +408,188 @@ func TestMountInvalidPath(t *testing.T) {
 	}
 }
 
+func TestPagesChecks(t *testing.T) {
+	ts := testServer(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := ioutil.ReadAll(r.Body)
+		imageHandler(w, r, buf, Resize, ServerOptions{MaxAllowedPixels: 0.00005})
+	})
+	defer ts.Close()
+
+	// The output type and the source resolution are checked before selecting the pages
+	cases := []struct {
+		query  string
+		status int
+		error  Error
+	}{
+		{"?width=5&page=10&type=unknown", 400, ErrOutputFormat},
+		{"?width=5&page=10", 422, ErrResolutionTooBig},
+	}
+
+	for _, c := range cases {
+		res, err := http.Post(ts.URL+c.query, "image/gif", bytes.NewReader(newTestGIF(0)))
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+
+		body, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != c.status || !strings.Contains(string(body), c.error.Message) {
+			t.Errorf("Invalid response for %s: %s, %s", c.query, res.Status, body)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	opts := ServerOptions{MaxAllowedPixels: 18.0}
+	LoadSources(opts)
+	ts := testServer(composeController(opts))
+	defer ts.Close()
+
+	body, contentType := multipartFiles(t, "imaginary.jpg", "large.jpg", "test.png")
+	res, err := http.Post(ts.URL+"?width=100&height=100&type=png", contentType, body)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bimg.DetermineImageTypeName(image) != "png" {
+		t.Fatalf("Invalid image type")
+	}
+
+	cases := []struct {
+		query  string
+		files  []string
+		server ServerOptions
+		status int
+	}{
+		{"?type=unknown", []string{"imaginary.jpg"}, opts, 400},
+		{"", []string{"1024bytes"}, opts, 406},
+		{"", nil, opts, 400},
+		{"", []string{"imaginary.jpg", "large.jpg"}, ServerOptions{MaxAllowedPixels: 0.1}, 422},
+		{"?layout=invalid", []string{"imaginary.jpg"}, opts, 400},
+	}
+
+	for _, c := range cases {
+		ts := testServer(composeController(c.server))
+		body, contentType := multipartFiles(t, c.files...)
+		res, err := http.Post(ts.URL+c.query, contentType, body)
+		ts.Close()
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != c.status || res.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Invalid response for %s %v: %s, %s", c.query, c.files, res.Status, res.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	opts := ServerOptions{MaxAllowedPixels: 18.0}
+	LoadSources(opts)
+	ts := testServer(compareController(opts))
+	defer ts.Close()
+
+	body, contentType := multipartFiles(t, "imaginary.jpg", "imaginary.jpg")
+	res, err := http.Post(ts.URL, contentType, body)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	var info CompareInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatalf("Cannot decode the response: %s", err)
+	}
+	if info.SSIM != 1 || info.MAE != 0 {
+		t.Errorf("Invalid metrics of identical images: %+v", info)
+	}
+
+	// A single image is compared with the output of the pipeline operations
+	operations := `[{"operation": "blur", "params": {"sigma": 5}}]`
+	res, err = http.Post(ts.URL+"?output=diff&operations="+url.QueryEscape(operations), "image/jpeg", readFile("imaginary.jpg"))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 || res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Invalid diff response: %s, %s", res.Status, res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Post(ts.URL, "image/jpeg", readFile("imaginary.jpg"))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 400 || res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Invalid response for a single image: %s, %s", res.Status, res.Header.Get("Content-Type"))
+	}
+}
+
+func TestHashCompare(t *testing.T) {
+	opts := ServerOptions{MaxAllowedPixels: 18.0}
+	LoadSources(opts)
+	ts := testServer(hashCompareController(opts))
+	defer ts.Close()
+
+	body, contentType := multipartFiles(t, "imaginary.jpg", "imaginary.jpg")
+	res, err := http.Post(ts.URL, contentType, body)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	var comparison HashComparison
+	if err := json.NewDecoder(res.Body).Decode(&comparison); err != nil {
+		t.Fatalf("Cannot decode the response: %s", err)
+	}
+	if len(comparison.Hashes) != 2 || comparison.Distance != (HashDistance{}) {
+		t.Errorf("Invalid hashes of identical images: %+v", comparison)
+	}
+
+	for _, files := range [][]string{{"imaginary.jpg"}, {"imaginary.jpg", "large.jpg", "test.png"}, {"imaginary.jpg", "1024bytes"}} {
+		body, contentType := multipartFiles(t, files...)
+		res, err := http.Post(ts.URL, contentType, body)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode < 400 || res.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Invalid response for %v: %s, %s", files, res.Status, res.Header.Get("Content-Type"))
+		}
+	}
+}
+
+// multipartFiles returns a multipart form body with the given test files, along with its content type.
+func multipartFiles(t *testing.T, files ...string) (io.Reader, string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(path.Join("testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, _ := form.CreateFormFile(formFieldName, file)
+		_, _ = part.Write(buf)
+	}
+	_ = form.Close()
+	return &body, form.FormDataContentType()
+}
+
 func controller(op Operation) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		buf, _ := ioutil.ReadAll(r.Body)
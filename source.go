@@ -26,6 +26,11 @@ type ImageSource interface {
 	GetImage(*http.Request) ([]byte, error)
 }
 
+// MultiImageSource is implemented by the image sources able to read several images per request.
+type MultiImageSource interface {
+	GetImages(*http.Request) ([][]byte, error)
+}
+
 func RegisterSource(sourceType ImageSourceType, factory ImageSourceFactoryFunction) {
 	imageSourceFactoryMap[sourceType] = factory
 }
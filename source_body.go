@@ -30,6 +30,41 @@ func (s *BodyImageSource) GetImage(r *http.Request) ([]byte, error) {
 	return readRawBody(r)
 }
 
+// GetImages reads every file of the multipart form, or the raw body as a single image.
+func (s *BodyImageSource) GetImages(r *http.Request) ([][]byte, error) {
+	if !isFormBody(r) {
+		buf, err := readRawBody(r)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{buf}, nil
+	}
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return nil, err
+	}
+
+	var images [][]byte
+	for _, header := range r.MultipartForm.File[formFieldName] {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		buf, err := ioutil.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(buf) == 0 {
+			return nil, ErrEmptyBody
+		}
+		images = append(images, buf)
+	}
+
+	return images, nil
+}
+
 func isFormBody(r *http.Request) bool {
 	return strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/")
 }
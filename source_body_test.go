@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -49,6 +51,30 @@ func TestBodyImageSource(t *testing.T) {
 	}
 }
 
+func TestBodyImageSourceMultipleFiles(t *testing.T) {
+	buf, _ := ioutil.ReadFile(fixtureFile)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, name := range []string{"one.jpg", "two.jpg"} {
+		part, _ := form.CreateFormFile(formFieldName, name)
+		_, _ = part.Write(buf)
+	}
+	_ = form.Close()
+
+	r, _ := http.NewRequest(http.MethodPost, "http://foo/bar", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	source := NewBodyImageSource(&SourceConfig{}).(MultiImageSource)
+	images, err := source.GetImages(r)
+	if err != nil {
+		t.Fatalf("Error while reading the body: %s", err)
+	}
+	if len(images) != 2 || len(images[1]) != len(buf) {
+		t.Errorf("Invalid images: %d", len(images))
+	}
+}
+
 func testReadBody(t *testing.T) {
 	var body []byte
 	var err error
@@ -5,7 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -45,9 +45,35 @@ func (s *FileSystemImageSource) GetImage(r *http.Request) ([]byte, error) {
 	return s.read(file)
 }
 
+// GetImages reads the images of every file query param.
+func (s *FileSystemImageSource) GetImages(r *http.Request) ([][]byte, error) {
+	var images [][]byte
+	for _, file := range r.URL.Query()["file"] {
+		file, err := url.QueryUnescape(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unescape file param: %w", err)
+		}
+
+		file, err = s.buildPath(file)
+		if err != nil {
+			return nil, err
+		}
+
+		buf, err := s.read(file)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, buf)
+	}
+	return images, nil
+}
+
 func (s *FileSystemImageSource) buildPath(file string) (string, error) {
-	file = path.Clean(path.Join(s.Config.MountPath, file))
-	if !strings.HasPrefix(file, s.Config.MountPath) {
+	// The file must be within the mount directory, and not within a sibling one sharing its prefix
+	mountPath := filepath.Clean(s.Config.MountPath)
+	file = filepath.Join(mountPath, file)
+	rel, err := filepath.Rel(mountPath, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
 		return "", ErrInvalidFilePath
 	}
 	return file, nil
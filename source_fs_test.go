@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -36,3 +37,59 @@ func TestFileSystemImageSource(t *testing.T) {
 		t.Error("Invalid response body")
 	}
 }
+
+func TestFileSystemImageSourceMultipleFiles(t *testing.T) {
+	source := NewFileSystemImageSource(&SourceConfig{MountPath: "testdata"}).(MultiImageSource)
+
+	r, _ := http.NewRequest(http.MethodGet, "http://foo/bar?file=large.jpg&file=medium.jpg", nil)
+	images, err := source.GetImages(r)
+	if err != nil {
+		t.Fatalf("Error while reading the images: %s", err)
+	}
+	if len(images) != 2 {
+		t.Errorf("Invalid number of images: %d", len(images))
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "http://foo/bar?file=large.jpg&file=../server.go", nil)
+	if _, err := source.GetImages(r); err != ErrInvalidFilePath {
+		t.Errorf("Expected an invalid file path error, got: %v", err)
+	}
+}
+
+func TestFileSystemImageSourceSiblingDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imaginary")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mount, sibling := filepath.Join(dir, "mount"), filepath.Join(dir, "mount-private")
+	for _, d := range []string{mount, sibling} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Cannot create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "image.jpg"), []byte("image"), 0644); err != nil {
+			t.Fatalf("Cannot write file: %s", err)
+		}
+	}
+
+	// The mount path may be defined with a trailing separator
+	for _, mountPath := range []string{mount, mount + string(filepath.Separator)} {
+		source := NewFileSystemImageSource(&SourceConfig{MountPath: mountPath})
+
+		r, _ := http.NewRequest(http.MethodGet, "http://foo/bar?file=image.jpg", nil)
+		if _, err := source.GetImage(r); err != nil {
+			t.Errorf("Cannot read the image from %s: %s", mountPath, err)
+		}
+
+		r, _ = http.NewRequest(http.MethodGet, "http://foo/bar?file=image.jpg&file=../mount-private/image.jpg", nil)
+		if _, err := source.(MultiImageSource).GetImages(r); err != ErrInvalidFilePath {
+			t.Errorf("Expected an invalid file path error for a sibling directory of %s, got: %v", mountPath, err)
+		}
+
+		r, _ = http.NewRequest(http.MethodGet, "http://foo/bar?file=../mount-private/image.jpg", nil)
+		if _, err := source.GetImage(r); err != ErrInvalidFilePath {
+			t.Errorf("Expected an invalid file path error for a sibling directory of %s, got: %v", mountPath, err)
+		}
+	}
+}
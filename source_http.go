@@ -29,6 +29,27 @@ func (s *HTTPImageSource) GetImage(req *http.Request) ([]byte, error) {
 	if err != nil {
 		return nil, ErrInvalidImageURL
 	}
+	return s.getURLImage(u, req)
+}
+
+// GetImages fetches the images of every url query param.
+func (s *HTTPImageSource) GetImages(req *http.Request) ([][]byte, error) {
+	var images [][]byte
+	for _, raw := range req.URL.Query()[URLQueryKey] {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, ErrInvalidImageURL
+		}
+		buf, err := s.getURLImage(u, req)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, buf)
+	}
+	return images, nil
+}
+
+func (s *HTTPImageSource) getURLImage(u *url.URL, req *http.Request) ([]byte, error) {
 	if shouldRestrictOrigin(u, s.Config.AllowedOrigins) {
 		return nil, fmt.Errorf("not allowed remote URL origin: %s%s", u.Host, u.Path)
 	}
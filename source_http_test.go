@@ -43,6 +43,31 @@ func TestHttpImageSource(t *testing.T) {
 	}
 }
 
+func TestHttpImageSourceMultipleURLs(t *testing.T) {
+	buf, _ := ioutil.ReadFile(fixtureImage)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(buf)
+	}))
+	defer ts.Close()
+
+	origin, _ := url.Parse(ts.URL + "/allowed")
+	source := NewHTTPImageSource(&SourceConfig{AllowedOrigins: []*url.URL{origin}}).(MultiImageSource)
+
+	r, _ := http.NewRequest(http.MethodGet, "http://foo/bar?url="+ts.URL+"/allowed/1.jpg&url="+ts.URL+"/allowed/2.jpg", nil)
+	images, err := source.GetImages(r)
+	if err != nil {
+		t.Fatalf("Error while reading the images: %s", err)
+	}
+	if len(images) != 2 || len(images[1]) != len(buf) {
+		t.Errorf("Invalid images: %d", len(images))
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "http://foo/bar?url="+ts.URL+"/allowed/1.jpg&url="+ts.URL+"/private/2.jpg", nil)
+	if _, err := source.GetImages(r); err == nil {
+		t.Error("Expected a not allowed origin error")
+	}
+}
+
 func TestHttpImageSourceAllowedOrigin(t *testing.T) {
 	buf, _ := ioutil.ReadFile(fixtureImage)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+)
+
+const (
+	// statsSampleSize defines the max width/height of the downsampled image used to compute statistics.
+	statsSampleSize = 512
+	// statsHistogramBuckets defines the number of buckets of the luminance histogram.
+	statsHistogramBuckets = 16
+	// statsUniformStdDev defines the max standard deviation of every band of a uniform image.
+	statsUniformStdDev = 2.0
+)
+
+// StatsBand represents the statistics of an image band, between 0 and 255.
+type StatsBand struct {
+	Name   string  `json:"name,omitempty"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+}
+
+// StatsLuminance represents the luminance statistics and histogram of an image.
+type StatsLuminance struct {
+	StatsBand
+	Histogram []float64 `json:"histogram"`
+}
+
+// StatsInfo represents the statistics of an image.
+type StatsInfo struct {
+	Bands       []StatsBand    `json:"bands"`
+	Luminance   StatsLuminance `json:"luminance"`
+	Uniform     bool           `json:"uniform"`
+	Transparent float64        `json:"transparent"`
+}
+
+// bandAccumulator accumulates the values of an image band.
+type bandAccumulator struct {
+	name          string
+	min, max      float64
+	sum, sumSq, n float64
+}
+
+func Stats(buf []byte, o ImageOptions) (Image, error) {
+	// We're not handling an image here, but we reused the struct.
+	image := Image{Mime: "application/json"}
+
+	img, err := rasterize(buf, statsSampleSize)
+	if err != nil {
+		return image, NewError("Cannot retrieve image statistics: "+err.Error(), http.StatusBadRequest)
+	}
+
+	body, _ := json.Marshal(imageStats(img))
+	image.Body = body
+
+	return image, nil
+}
+
+// imageStats returns the per band statistics, the luminance histogram and the fraction of transparent pixels
+// of the image. Fully transparent pixels are only accounted in the alpha band.
+func imageStats(img image.Image) StatsInfo {
+	bounds := img.Bounds()
+	gray := isGrayModel(img.ColorModel())
+
+	var bands []*bandAccumulator
+	if gray {
+		bands = []*bandAccumulator{newBandAccumulator("gray")}
+	} else {
+		bands = []*bandAccumulator{newBandAccumulator("red"), newBandAccumulator("green"), newBandAccumulator("blue")}
+	}
+	alpha := newBandAccumulator("alpha")
+	luminance := newBandAccumulator("")
+	histogram := make([]float64, statsHistogramBuckets)
+
+	transparent, total := 0, bounds.Dx()*bounds.Dy()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			alpha.add(float64(c.A))
+			if c.A == 0 {
+				transparent++
+				continue
+			}
+
+			if gray {
+				bands[0].add(float64(c.R))
+			} else {
+				bands[0].add(float64(c.R))
+				bands[1].add(float64(c.G))
+				bands[2].add(float64(c.B))
+			}
+
+			// Rec. 709 luma
+			l := 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+			luminance.add(l)
+			histogram[int(math.Min(l*statsHistogramBuckets/256, statsHistogramBuckets-1))]++
+		}
+	}
+
+	if alpha.min < 255 {
+		bands = append(bands, alpha)
+	}
+
+	opaque := total - transparent
+	for i := range histogram {
+		if opaque > 0 {
+			histogram[i] = toFixed(histogram[i]/float64(opaque), 4)
+		}
+	}
+
+	info := StatsInfo{
+		Luminance: StatsLuminance{StatsBand: luminance.stats(), Histogram: histogram},
+		Uniform:   true,
+	}
+	if total > 0 {
+		info.Transparent = toFixed(float64(transparent)/float64(total), 4)
+	}
+	for _, band := range bands {
+		stats := band.stats()
+		if stats.StdDev > statsUniformStdDev {
+			info.Uniform = false
+		}
+		info.Bands = append(info.Bands, stats)
+	}
+
+	return info
+}
+
+// isGrayModel returns true if the colour model has a single grey band.
+func isGrayModel(model color.Model) bool {
+	return model == color.GrayModel || model == color.Gray16Model
+}
+
+func newBandAccumulator(name string) *bandAccumulator {
+	return &bandAccumulator{name: name, min: math.MaxFloat64, max: -math.MaxFloat64}
+}
+
+func (b *bandAccumulator) add(value float64) {
+	b.min = math.Min(b.min, value)
+	b.max = math.Max(b.max, value)
+	b.sum += value
+	b.sumSq += value * value
+	b.n++
+}
+
+// stats returns the band statistics, being zero if the band has no values.
+func (b *bandAccumulator) stats() StatsBand {
+	stats := StatsBand{Name: b.name}
+	if b.n == 0 {
+		return stats
+	}
+
+	mean := b.sum / b.n
+	variance := math.Max(0, b.sumSq/b.n-mean*mean)
+	stats.Min = toFixed(b.min, 2)
+	stats.Max = toFixed(b.max, 2)
+	stats.Mean = toFixed(mean, 2)
+	stats.StdDev = toFixed(math.Sqrt(variance), 2)
+	return stats
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("imaginary.jpg"))
+
+	img, err := Stats(buf, ImageOptions{})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "application/json" {
+		t.Error("Invalid response MIME type")
+	}
+
+	var info StatsInfo
+	if err := json.Unmarshal(img.Body, &info); err != nil {
+		t.Fatalf("Invalid JSON response: %s", err)
+	}
+	if len(info.Bands) != 3 || info.Bands[0].Name != "red" {
+		t.Fatalf("Invalid image bands: %#v", info.Bands)
+	}
+	if len(info.Luminance.Histogram) != statsHistogramBuckets || info.Uniform || info.Transparent != 0 {
+		t.Errorf("Invalid image statistics: %#v", info)
+	}
+}
+
+func TestImageStats(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			switch {
+			case y == 0:
+				img.SetNRGBA(x, y, color.NRGBA{})
+			case x < 2:
+				img.SetNRGBA(x, y, color.NRGBA{0, 0, 0, 255})
+			default:
+				img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+
+	info := imageStats(img)
+	if len(info.Bands) != 4 || info.Bands[3].Name != "alpha" {
+		t.Fatalf("Invalid image bands: %#v", info.Bands)
+	}
+	red := info.Bands[0]
+	if red.Min != 0 || red.Max != 255 || red.Mean != 127.5 || red.StdDev != 127.5 {
+		t.Errorf("Invalid red band statistics: %#v", red)
+	}
+	if info.Transparent != 0.25 || info.Uniform {
+		t.Errorf("Invalid image statistics: %#v", info)
+	}
+	histogram := info.Luminance.Histogram
+	if histogram[0] != 0.5 || histogram[statsHistogramBuckets-1] != 0.5 {
+		t.Errorf("Invalid luminance histogram: %v", histogram)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range gray.Pix {
+		gray.Pix[i] = 200 + uint8(i%2)
+	}
+	info = imageStats(gray)
+	if len(info.Bands) != 1 || info.Bands[0].Name != "gray" || !info.Uniform {
+		t.Errorf("Expected a uniform grey image: %#v", info)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	svgTagPattern    = regexp.MustCompile(`(?is)<svg\b[^>]*>`)
+	svgAttrPattern   = regexp.MustCompile(`(?is)\s(width|height|viewBox)\s*=\s*("[^"]*"|'[^']*')`)
+	svgLengthPattern = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)\s*(px|pt|pc|mm|cm|in)?\s*$`)
+)
+
+// svgUnits defines the size in pixels of the SVG absolute length units.
+var svgUnits = map[string]float64{
+	"":   1,
+	"px": 1,
+	"pt": 96.0 / 72,
+	"pc": 16,
+	"mm": 96 / 25.4,
+	"cm": 96 / 2.54,
+	"in": 96,
+}
+
+var errUnscalableSVG = errors.New("cannot determine the SVG image size")
+
+// scaleSVG scales the size of the SVG image root element by the given factor,
+// so libvips rasterises it at a higher resolution instead of upscaling it afterwards.
+func scaleSVG(buf []byte, factor float64) ([]byte, error) {
+	loc := svgTagPattern.FindIndex(buf)
+	if loc == nil {
+		return nil, errUnscalableSVG
+	}
+	tag := string(buf[loc[0]:loc[1]])
+
+	attrs := make(map[string]string)
+	for _, match := range svgAttrPattern.FindAllStringSubmatch(tag, -1) {
+		attrs[match[1]] = strings.Trim(match[2], `"'`)
+	}
+
+	width, widthOk := parseSVGLength(attrs["width"])
+	height, heightOk := parseSVGLength(attrs["height"])
+
+	viewBox := strings.Fields(strings.Replace(attrs["viewBox"], ",", " ", -1))
+	if len(viewBox) == 4 {
+		vw, _ := strconv.ParseFloat(viewBox[2], 64)
+		vh, _ := strconv.ParseFloat(viewBox[3], 64)
+		if vw > 0 && vh > 0 {
+			switch {
+			case !widthOk && !heightOk:
+				width, height = vw, vh
+			case !widthOk:
+				width = height * vw / vh
+			case !heightOk:
+				height = width * vh / vw
+			}
+			widthOk, heightOk = true, true
+		}
+	}
+
+	if !widthOk || !heightOk || width <= 0 || height <= 0 {
+		return nil, errUnscalableSVG
+	}
+
+	// Drop the current size, keeping the rest of the attributes
+	scaled := svgAttrPattern.ReplaceAllStringFunc(tag, func(attr string) string {
+		if strings.Contains(strings.ToLower(strings.SplitN(attr, "=", 2)[0]), "viewbox") {
+			return attr
+		}
+		return ""
+	})
+
+	size := fmt.Sprintf(` width="%s" height="%s"`, formatSVGLength(width*factor), formatSVGLength(height*factor))
+	if len(viewBox) != 4 {
+		// Without a view box the user units are pixels of the original size
+		size += fmt.Sprintf(` viewBox="0 0 %s %s"`, formatSVGLength(width), formatSVGLength(height))
+	}
+	scaled = scaled[:4] + size + scaled[4:]
+
+	out := make([]byte, 0, len(buf)+len(size))
+	out = append(out, buf[:loc[0]]...)
+	out = append(out, scaled...)
+	out = append(out, buf[loc[1]:]...)
+	return out, nil
+}
+
+// parseSVGLength returns the given absolute SVG length in pixels.
+func parseSVGLength(val string) (float64, bool) {
+	match := svgLengthPattern.FindStringSubmatch(val)
+	if match == nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * svgUnits[strings.ToLower(match[2])], true
+}
+
+func formatSVGLength(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// tiffMaxPages defines the max number of image file directories read from a TIFF image.
+const tiffMaxPages = 10000
+
+var errInvalidTIFF = errors.New("invalid TIFF image")
+
+// TIFF field types.
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffSByte     = 6
+	tiffUndefined = 7
+	tiffSShort    = 8
+	tiffSLong     = 9
+	tiffSRational = 10
+	tiffFloat     = 11
+	tiffDouble    = 12
+)
+
+// tiffTypeSizes defines the size in bytes of every TIFF field type.
+var tiffTypeSizes = map[uint16]int{
+	tiffByte: 1, tiffASCII: 1, tiffShort: 2, tiffLong: 4, tiffRational: 8, tiffSByte: 1,
+	tiffUndefined: 1, tiffSShort: 2, tiffSLong: 4, tiffSRational: 8, tiffFloat: 4, tiffDouble: 8,
+}
+
+// tiffEntry represents a field of a TIFF image file directory.
+type tiffEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count int
+	Value []byte
+}
+
+// tiffPageOffsets returns the byte order and the offsets of the image file directories,
+// one per page, of a TIFF image. BigTIFF images are not supported.
+func tiffPageOffsets(buf []byte) (binary.ByteOrder, []int, error) {
+	if len(buf) < 8 {
+		return nil, nil, errInvalidTIFF
+	}
+
+	order := tiffByteOrder(buf)
+	if order == nil {
+		return nil, nil, errInvalidTIFF
+	}
+
+	var offsets []int
+	visited := make(map[int]bool)
+
+	offset := int(order.Uint32(buf[4:8]))
+	for offset != 0 {
+		if visited[offset] || len(offsets) == tiffMaxPages || offset+2 > len(buf) {
+			return nil, nil, errInvalidTIFF
+		}
+		visited[offset] = true
+
+		entries := int(order.Uint16(buf[offset:]))
+		next := offset + 2 + entries*12
+		if next+4 > len(buf) {
+			return nil, nil, errInvalidTIFF
+		}
+
+		offsets = append(offsets, offset)
+		offset = int(order.Uint32(buf[next:]))
+	}
+
+	return order, offsets, nil
+}
+
+// extractTIFFPage returns a copy of the TIFF image having the given page as its only page.
+// Pages are linked directories, so it's enough to point the header to the page directory
+// and to unlink it from the next one.
+func extractTIFFPage(buf []byte, page int) ([]byte, error) {
+	order, offsets, err := tiffPageOffsets(buf)
+	if err != nil {
+		return nil, err
+	}
+	if page >= len(offsets) {
+		return nil, ErrPageOutOfRange
+	}
+
+	out := make([]byte, len(buf))
+	copy(out, buf)
+
+	offset := offsets[page]
+	order.PutUint32(out[4:8], uint32(offset))
+	order.PutUint32(out[offset+2+int(order.Uint16(out[offset:]))*12:], 0)
+
+	return out, nil
+}
+
+// tiffByteOrder returns the byte order of the TIFF header, or nil if it's not a TIFF header.
+func tiffByteOrder(buf []byte) binary.ByteOrder {
+	if len(buf) < 8 {
+		return nil
+	}
+	switch string(buf[0:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// readTIFFDirectory returns the fields of the image file directory at the given offset.
+// Fields of unknown types or whose value is out of bounds are ignored.
+func readTIFFDirectory(buf []byte, order binary.ByteOrder, offset int) ([]tiffEntry, error) {
+	if offset < 8 || offset+2 > len(buf) {
+		return nil, errInvalidTIFF
+	}
+
+	count := int(order.Uint16(buf[offset:]))
+	if offset+2+count*12 > len(buf) {
+		return nil, errInvalidTIFF
+	}
+
+	entries := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		field := buf[offset+2+i*12:]
+		entry := tiffEntry{
+			Tag:   order.Uint16(field[0:]),
+			Type:  order.Uint16(field[2:]),
+			Count: int(order.Uint32(field[4:])),
+		}
+
+		size, ok := tiffTypeSizes[entry.Type]
+		if !ok || entry.Count < 0 || entry.Count > len(buf) {
+			continue
+		}
+
+		length := size * entry.Count
+		if length <= 4 {
+			entry.Value = field[8 : 8+length]
+		} else {
+			start := int(order.Uint32(field[8:]))
+			if start < 0 || start+length > len(buf) {
+				continue
+			}
+			entry.Value = buf[start : start+length]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// tiffDirectorySize returns the size of an image file directory with the given fields,
+// including the values not fitting in the fields.
+func tiffDirectorySize(entries []tiffEntry) int {
+	size := 2 + len(entries)*12 + 4
+	for _, entry := range entries {
+		if len(entry.Value) > 4 {
+			size += len(entry.Value) + len(entry.Value)%2
+		}
+	}
+	return size
+}
+
+// appendTIFFDirectory appends an image file directory with the given fields, sorted by tag,
+// followed by the values not fitting in the fields. The next directory offset is always zero.
+func appendTIFFDirectory(buf []byte, order binary.ByteOrder, entries []tiffEntry) []byte {
+	entries = append([]tiffEntry{}, entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+
+	start := len(buf)
+	dataOffset := start + 2 + len(entries)*12 + 4
+	buf = append(buf, make([]byte, tiffDirectorySize(entries))...)
+
+	order.PutUint16(buf[start:], uint16(len(entries)))
+	for i, entry := range entries {
+		field := buf[start+2+i*12:]
+		order.PutUint16(field[0:], entry.Tag)
+		order.PutUint16(field[2:], entry.Type)
+		order.PutUint32(field[4:], uint32(entry.Count))
+		if len(entry.Value) <= 4 {
+			copy(field[8:12], entry.Value)
+			continue
+		}
+		order.PutUint32(field[8:], uint32(dataOffset))
+		copy(buf[dataOffset:], entry.Value)
+		dataOffset += len(entry.Value) + len(entry.Value)%2
+	}
+	return buf
+}
+
+// tiffEntryInt returns the first value of an integer field.
+func tiffEntryInt(e tiffEntry, order binary.ByteOrder) (int, bool) {
+	if e.Count == 0 {
+		return 0, false
+	}
+	switch e.Type {
+	case tiffByte, tiffUndefined:
+		return int(e.Value[0]), true
+	case tiffSByte:
+		return int(int8(e.Value[0])), true
+	case tiffShort:
+		return int(order.Uint16(e.Value)), true
+	case tiffSShort:
+		return int(int16(order.Uint16(e.Value))), true
+	case tiffLong:
+		return int(order.Uint32(e.Value)), true
+	case tiffSLong:
+		return int(int32(order.Uint32(e.Value))), true
+	}
+	return 0, false
+}
+
+// tiffEntryFloats returns the values of a numeric field as floats.
+// Rationals with a zero denominator are returned as zero.
+func tiffEntryFloats(e tiffEntry, order binary.ByteOrder) []float64 {
+	values := make([]float64, 0, e.Count)
+	for i := 0; i < e.Count; i++ {
+		var v float64
+		switch e.Type {
+		case tiffByte, tiffUndefined:
+			v = float64(e.Value[i])
+		case tiffSByte:
+			v = float64(int8(e.Value[i]))
+		case tiffShort:
+			v = float64(order.Uint16(e.Value[i*2:]))
+		case tiffSShort:
+			v = float64(int16(order.Uint16(e.Value[i*2:])))
+		case tiffLong:
+			v = float64(order.Uint32(e.Value[i*4:]))
+		case tiffSLong:
+			v = float64(int32(order.Uint32(e.Value[i*4:])))
+		case tiffRational, tiffSRational:
+			num, den := order.Uint32(e.Value[i*8:]), order.Uint32(e.Value[i*8+4:])
+			if e.Type == tiffSRational {
+				if den != 0 {
+					v = float64(int32(num)) / float64(int32(den))
+				}
+			} else if den != 0 {
+				v = float64(num) / float64(den)
+			}
+		case tiffFloat:
+			v = float64(math.Float32frombits(order.Uint32(e.Value[i*4:])))
+		case tiffDouble:
+			v = math.Float64frombits(order.Uint64(e.Value[i*8:]))
+		default:
+			return nil
+		}
+		values = append(values, v)
+	}
+	return values
+}
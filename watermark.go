@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// textMaskPadding defines the offset bimg renders the watermark text at.
+	textMaskPadding = 100
+	// maxStrokeWidth defines the max allowed text watermark stroke width in pixels.
+	maxStrokeWidth = 50
+	// maxShadowBlur defines the max allowed text watermark shadow blur sigma.
+	maxShadowBlur = 50
+	// maxTextWidth defines the max allowed text watermark width in pixels.
+	maxTextWidth = 2048
+)
+
+// pangoMarkupTags defines the supported Pango markup tags.
+// See: https://docs.gtk.org/Pango/pango_markup.html
+var pangoMarkupTags = map[string]bool{
+	"markup": true,
+	"span":   true,
+	"b":      true,
+	"big":    true,
+	"i":      true,
+	"s":      true,
+	"sub":    true,
+	"sup":    true,
+	"small":  true,
+	"tt":     true,
+	"u":      true,
+}
+
+// validateMarkup validates the watermark text as Pango markup, which is always interpreted by libvips.
+func validateMarkup(text string) error {
+	decoder := xml.NewDecoder(strings.NewReader("<markup>" + text + "</markup>"))
+	decoder.Strict = true
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return NewError("Invalid text markup: "+err.Error(), http.StatusBadRequest)
+		}
+		if el, ok := token.(xml.StartElement); ok && !pangoMarkupTags[el.Name.Local] {
+			return NewError("Invalid text markup: unsupported tag "+el.Name.Local, http.StatusBadRequest)
+		}
+	}
+}
+
+// validateTextWatermark validates the text watermark params.
+func validateTextWatermark(o ImageOptions) error {
+	if err := validateMarkup(o.Text); err != nil {
+		return err
+	}
+	if o.TextAngle < 0 || o.TextAngle > 360 {
+		return NewError("Invalid textangle param: must be between 0 and 360", http.StatusBadRequest)
+	}
+	if o.StrokeWidth < 0 || o.StrokeWidth > maxStrokeWidth {
+		return NewError(fmt.Sprintf("Invalid strokewidth param: must be between 0 and %d", maxStrokeWidth), http.StatusBadRequest)
+	}
+	if o.ShadowBlur < 0 || o.ShadowBlur > maxShadowBlur {
+		return NewError(fmt.Sprintf("Invalid shadowblur param: must be between 0 and %d", maxShadowBlur), http.StatusBadRequest)
+	}
+	if o.TextWidth < 0 || o.TextWidth > maxTextWidth {
+		return NewError(fmt.Sprintf("Invalid textwidth param: must be between 0 and %d", maxTextWidth), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// shouldLayoutTextWatermark returns true if the text watermark requires
+// positioning or effects not natively supported by bimg.
+func shouldLayoutTextWatermark(o ImageOptions) bool {
+	return o.IsDefinedField.Gravity || o.OffsetX != 0 || o.OffsetY != 0 ||
+		o.TextAngle != 0 || o.StrokeWidth > 0 || hasTextShadow(o) || len(o.Color) > 3
+}
+
+func hasTextShadow(o ImageOptions) bool {
+	return len(o.ShadowColor) > 2 || o.ShadowX != 0 || o.ShadowY != 0 || o.ShadowBlur > 0
+}
+
+// watermarkWithTextLayout renders the text watermark as an image and draws it
+// onto the given image at the requested position.
+func watermarkWithTextLayout(buf []byte, opts bimg.Options, o ImageOptions) (Image, error) {
+	buf, opts, size, err := watermarkBase(buf, opts)
+	if err != nil {
+		return Image{}, err
+	}
+
+	textWidth := o.TextWidth
+	if textWidth == 0 {
+		textWidth = int(math.Min(float64(size.Width/6), maxTextWidth))
+	}
+
+	mask, err := renderTextMask(o, textWidth)
+	if err != nil {
+		return Image{}, err
+	}
+
+	tile := composeTextWatermark(mask, o)
+
+	var watermark image.Image = tile
+	left, top := 0, 0
+	if o.IsDefinedField.Gravity || o.NoReplicate {
+		left, top = gravityPosition(o.Gravity, size.Width, size.Height, tile.Bounds().Dx(), tile.Bounds().Dy(), o.OffsetX, o.OffsetY)
+	} else {
+		margin := o.Margin
+		if margin == 0 {
+			margin = textWidth
+		}
+		watermark = replicateWatermark(tile, size.Width, size.Height, margin)
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, watermark); err != nil {
+		return Image{}, err
+	}
+
+	opacity := o.Opacity
+	if opacity == 0 {
+		opacity = 0.25
+	}
+
+	opts.WatermarkImage = bimg.WatermarkImage{
+		Left:    left,
+		Top:     top,
+		Buf:     out.Bytes(),
+		Opacity: float32(math.Min(float64(opacity), 1)),
+	}
+
+	return Process(buf, opts, o)
+}
+
+// watermarkWithImageLayout scales the watermark image relative to the given image
+// and draws it at the requested position or tiled all over it.
+func watermarkWithImageLayout(buf []byte, watermark []byte, opts bimg.Options, o ImageOptions) (Image, error) {
+	buf, opts, size, err := watermarkBase(buf, opts)
+	if err != nil {
+		return Image{}, err
+	}
+
+	if o.Scale > 0 {
+		width := int(math.Max(math.Round(float64(size.Width)*o.Scale), 1))
+		watermark, err = bimg.Resize(watermark, bimg.Options{Type: bimg.PNG, Width: width, Enlarge: true})
+		if err != nil {
+			return Image{}, NewError("Cannot scale watermark image: "+err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	tile, err := rasterize(watermark, 0)
+	if err != nil {
+		return Image{}, NewError("Cannot read watermark image: "+err.Error(), http.StatusBadRequest)
+	}
+
+	var img image.Image = tile
+	left, top := o.Left, o.Top
+	if o.Replicate {
+		img = replicateWatermark(tile, size.Width, size.Height, o.Margin)
+		left, top = 0, 0
+	} else if o.IsDefinedField.Gravity {
+		left, top = gravityPosition(o.Gravity, size.Width, size.Height, tile.Bounds().Dx(), tile.Bounds().Dy(), o.OffsetX, o.OffsetY)
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return Image{}, err
+	}
+
+	opts.WatermarkImage = bimg.WatermarkImage{
+		Left:    left,
+		Top:     top,
+		Buf:     out.Bytes(),
+		Opacity: o.Opacity,
+	}
+
+	return Process(buf, opts, o)
+}
+
+// watermarkBase applies first the geometry transformations requested along with the watermark,
+// if any, so the watermark can be placed relative to the final image dimensions.
+// It returns the image to draw the watermark onto, the options to process it and its size.
+func watermarkBase(buf []byte, opts bimg.Options) ([]byte, bimg.Options, bimg.ImageSize, error) {
+	if opts.Width == 0 && opts.Height == 0 && opts.Rotate == 0 {
+		meta, err := bimg.Metadata(buf)
+		if err != nil {
+			return nil, opts, bimg.ImageSize{}, err
+		}
+
+		size := meta.Size
+		if !opts.NoAutoRotate && meta.Orientation > 4 {
+			// width/height will be switched with auto rotation
+			size.Width, size.Height = size.Height, size.Width
+		}
+
+		return buf, opts, size, nil
+	}
+
+	imageType := opts.Type
+	if imageType == bimg.UNKNOWN {
+		imageType = bimg.DetermineImageType(buf)
+	}
+
+	// Transform the image losslessly, leaving the encoding to the final pass
+	base := opts
+	base.Type = bimg.PNG
+	base.Watermark = bimg.Watermark{}
+	base.WatermarkImage = bimg.WatermarkImage{}
+
+	var out []byte
+	if imageType == bimg.GIF || imageType == bimg.WEBP {
+		// Keep the frames of animated images
+		animBase := base
+		animBase.Type = bimg.WEBP
+		animBase.Lossless = true
+		anim, ok, err := processAnimation(buf, animBase, nil)
+		if err != nil {
+			return nil, opts, bimg.ImageSize{}, err
+		}
+		if ok {
+			out = anim.Body
+		}
+	}
+
+	if out == nil {
+		var err error
+		if out, err = bimg.Resize(buf, base); err != nil {
+			return nil, opts, bimg.ImageSize{}, err
+		}
+	}
+
+	size, err := bimg.Size(out)
+	if err != nil {
+		return nil, opts, bimg.ImageSize{}, err
+	}
+
+	final := bimg.Options{
+		Type:          imageType,
+		Quality:       opts.Quality,
+		Compression:   opts.Compression,
+		Interlace:     opts.Interlace,
+		StripMetadata: opts.StripMetadata,
+		NoProfile:     opts.NoProfile,
+		Palette:       opts.Palette,
+		Speed:         opts.Speed,
+		NoAutoRotate:  true,
+	}
+
+	return out, final, size, nil
+}
+
+// renderTextMask renders the watermark text via libvips as an alpha mask cropped to the text bounds.
+func renderTextMask(o ImageOptions, textWidth int) (*image.Alpha, error) {
+	// The text is rendered in white onto a black canvas large enough to hold it
+	canvas := image.NewGray(image.Rect(0, 0, textWidth+textMaskPadding*2, textWidth*4+textMaskPadding*2))
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+
+	font := o.Font
+	if font == "" {
+		font = bimg.WatermarkFont
+	}
+
+	out, err := bimg.Resize(buf.Bytes(), bimg.Options{
+		Type: bimg.PNG,
+		Watermark: bimg.Watermark{
+			Text:        o.Text,
+			Font:        font,
+			Width:       textWidth,
+			DPI:         o.DPI,
+			Margin:      textMaskPadding,
+			Opacity:     1,
+			NoReplicate: true,
+			Background:  bimg.Color{R: 255, G: 255, B: 255},
+		},
+	})
+	if err != nil {
+		return nil, NewError("Cannot render watermark text: "+err.Error(), http.StatusBadRequest)
+	}
+
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	mask := image.NewAlpha(bounds)
+	text := image.Rectangle{}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			if v == 0 {
+				continue
+			}
+			mask.SetAlpha(x, y, color.Alpha{A: v})
+			text = text.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+
+	if text.Empty() {
+		return nil, NewError("Cannot render watermark text: empty text", http.StatusBadRequest)
+	}
+
+	return cropMask(mask, text), nil
+}
+
+// composeTextWatermark draws the text mask with its colour, stroke and shadow, rotated by the requested angle.
+func composeTextWatermark(mask *image.Alpha, o ImageOptions) *image.NRGBA {
+	if o.TextAngle != 0 {
+		mask = rotateMask(mask, o.TextAngle)
+	}
+
+	margin := o.StrokeWidth + int(math.Ceil(o.ShadowBlur*3))
+	text := expandMask(mask, margin)
+	bounds := text.Bounds()
+
+	outline := text
+	if o.StrokeWidth > 0 {
+		outline = dilateMask(text, o.StrokeWidth)
+	}
+
+	var shadow *image.Alpha
+	if hasTextShadow(o) {
+		shadow = outline
+		if o.ShadowBlur > 0 {
+			shadow = blurMask(shadow, o.ShadowBlur)
+		}
+		shadow = translateMask(shadow, o.ShadowX, o.ShadowY)
+		bounds = bounds.Union(shadow.Bounds())
+	}
+
+	out := image.NewNRGBA(bounds)
+	if shadow != nil {
+		draw.DrawMask(out, bounds, image.NewUniform(toNRGBA(o.ShadowColor)), image.Point{}, shadow, bounds.Min, draw.Over)
+	}
+	if o.StrokeWidth > 0 {
+		draw.DrawMask(out, bounds, image.NewUniform(toNRGBA(o.StrokeColor)), image.Point{}, outline, bounds.Min, draw.Over)
+	}
+	draw.DrawMask(out, bounds, image.NewUniform(toNRGBA(o.Color)), image.Point{}, text, bounds.Min, draw.Over)
+
+	// Move the watermark bounds origin to zero
+	out.Rect = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	return out
+}
+
+// gravityPosition calculates the top-left position of an area of the given size placed
+// within the image according to the gravity, being the offsets relative to the anchored edges.
+func gravityPosition(gravity bimg.Gravity, imageWidth, imageHeight, width, height, offsetX, offsetY int) (left, top int) {
+	left = (imageWidth-width)/2 + offsetX
+	top = (imageHeight-height)/2 + offsetY
+
+	switch gravity {
+	case bimg.GravityNorth, GravityNorthEast, GravityNorthWest:
+		top = offsetY
+	case bimg.GravitySouth, GravitySouthEast, GravitySouthWest:
+		top = imageHeight - height - offsetY
+	}
+
+	switch gravity {
+	case bimg.GravityWest, GravityNorthWest, GravitySouthWest:
+		left = offsetX
+	case bimg.GravityEast, GravityNorthEast, GravitySouthEast:
+		left = imageWidth - width - offsetX
+	}
+
+	return left, top
+}
+
+// replicateWatermark tiles the watermark all over an image of the given size, separated by the margin.
+func replicateWatermark(tile image.Image, width, height, margin int) *image.NRGBA {
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	stepX := tile.Bounds().Dx() + margin
+	stepY := tile.Bounds().Dy() + margin
+
+	for y := 0; y < height; y += stepY {
+		for x := 0; x < width; x += stepX {
+			r := image.Rect(x, y, x+tile.Bounds().Dx(), y+tile.Bounds().Dy())
+			draw.Draw(out, r, tile, tile.Bounds().Min, draw.Over)
+		}
+	}
+
+	return out
+}
+
+// toNRGBA returns the colour of the given RGB or RGBA components. Defaults to opaque black.
+func toNRGBA(c []uint8) color.NRGBA {
+	out := color.NRGBA{A: 255}
+	if len(c) > 2 {
+		out.R, out.G, out.B = c[0], c[1], c[2]
+	}
+	if len(c) > 3 {
+		out.A = c[3]
+	}
+	return out
+}
+
+func cropMask(mask *image.Alpha, r image.Rectangle) *image.Alpha {
+	out := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), mask, r.Min, draw.Src)
+	return out
+}
+
+// expandMask returns a copy of the mask with the given transparent margin around it.
+func expandMask(mask *image.Alpha, margin int) *image.Alpha {
+	bounds := mask.Bounds()
+	out := image.NewAlpha(image.Rect(0, 0, bounds.Dx()+margin*2, bounds.Dy()+margin*2))
+	draw.Draw(out, bounds.Sub(bounds.Min).Add(image.Pt(margin, margin)), mask, bounds.Min, draw.Src)
+	return out
+}
+
+// translateMask moves the mask bounds by the given offsets, sharing its pixels.
+func translateMask(mask *image.Alpha, x, y int) *image.Alpha {
+	out := *mask
+	out.Rect = mask.Rect.Add(image.Pt(x, y))
+	return &out
+}
+
+// rotateMask rotates the mask clockwise by the given angle in degrees, using bilinear interpolation.
+func rotateMask(mask *image.Alpha, angle float64) *image.Alpha {
+	rad := angle * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+
+	w, h := float64(mask.Bounds().Dx()), float64(mask.Bounds().Dy())
+	// Tolerate floating point errors for right angles
+	outW := int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin) - 1e-9))
+	outH := int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos) - 1e-9))
+	out := image.NewAlpha(image.Rect(0, 0, outW, outH))
+
+	cx, cy := w/2, h/2
+	ocx, ocy := float64(outW)/2, float64(outH)/2
+
+	for y := 0; y < outH; y++ {
+		for x := 0; x < outW; x++ {
+			// Map the output pixel centre back to the source
+			dx, dy := float64(x)+0.5-ocx, float64(y)+0.5-ocy
+			sx := dx*cos + dy*sin + cx - 0.5
+			sy := -dx*sin + dy*cos + cy - 0.5
+			out.Pix[y*out.Stride+x] = bilinearAlpha(mask, sx, sy)
+		}
+	}
+
+	return out
+}
+
+func bilinearAlpha(mask *image.Alpha, x, y float64) uint8 {
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	at := func(x, y int) float64 {
+		if !(image.Point{X: x, Y: y}.In(mask.Rect)) {
+			return 0
+		}
+		return float64(mask.AlphaAt(x, y).A)
+	}
+
+	top := at(x0, y0)*(1-fx) + at(x0+1, y0)*fx
+	bottom := at(x0, y0+1)*(1-fx) + at(x0+1, y0+1)*fx
+	return uint8(math.Round(top*(1-fy) + bottom*fy))
+}
+
+// dilateMask grows the mask shapes by the given radius, used to draw the text stroke.
+func dilateMask(mask *image.Alpha, radius int) *image.Alpha {
+	bounds := mask.Bounds()
+	out := image.NewAlpha(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var max uint8
+			for dy := -radius; dy <= radius && max < 255; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if dx*dx+dy*dy > radius*radius {
+						continue
+					}
+					p := image.Pt(x+dx, y+dy)
+					if !p.In(bounds) {
+						continue
+					}
+					if a := mask.AlphaAt(p.X, p.Y).A; a > max {
+						max = a
+					}
+				}
+			}
+			out.SetAlpha(x, y, color.Alpha{A: max})
+		}
+	}
+
+	return out
+}
+
+// blurMask applies a gaussian blur with the given sigma to the mask, used to draw the text shadow.
+func blurMask(mask *image.Alpha, sigma float64) *image.Alpha {
+	radius := int(math.Ceil(sigma * 3))
+	kernel := make([]float64, radius*2+1)
+
+	var sum float64
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	bounds := mask.Bounds()
+	convolve := func(src *image.Alpha, dx, dy int) *image.Alpha {
+		out := image.NewAlpha(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				var v float64
+				for i, k := range kernel {
+					p := image.Pt(x+(i-radius)*dx, y+(i-radius)*dy)
+					if p.In(bounds) {
+						v += k * float64(src.AlphaAt(p.X, p.Y).A)
+					}
+				}
+				out.SetAlpha(x, y, color.Alpha{A: uint8(math.Round(v))})
+			}
+		}
+		return out
+	}
+
+	return convolve(convolve(mask, 1, 0), 0, 1)
+}
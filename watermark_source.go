@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+const (
+	// watermarkDefaultMaxSize defines the max size in bytes of remote watermark images,
+	// unless the -max-allowed-size flag is defined.
+	watermarkDefaultMaxSize = 1e6
+	// watermarkFetchTimeout defines the timeout to fetch remote watermark images.
+	watermarkFetchTimeout = 10 * time.Second
+	// watermarkCacheMaxEntries defines the max number of remote watermark images cached in memory.
+	watermarkCacheMaxEntries = 100
+)
+
+// WatermarkConfig represents the watermark image sources configuration.
+type WatermarkConfig struct {
+	MountPath      string
+	AllowedOrigins []*url.URL
+	MaxAllowedSize int
+	CacheTTL       time.Duration
+	Registry       map[string][]byte
+}
+
+// WatermarkLoader loads the watermark images from a named registry of preloaded images,
+// the mount directory or a remote HTTP server, caching the remote ones.
+type WatermarkLoader struct {
+	Config WatermarkConfig
+	client *http.Client
+	cache  *watermarkCache
+}
+
+type watermarkCacheEntry struct {
+	buf     []byte
+	expires time.Time
+}
+
+type watermarkCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]watermarkCacheEntry
+}
+
+// watermarks is the watermark loader used by the WatermarkImage operation.
+var watermarks = NewWatermarkLoader(WatermarkConfig{})
+
+func NewWatermarkLoader(config WatermarkConfig) *WatermarkLoader {
+	if config.MaxAllowedSize <= 0 {
+		config.MaxAllowedSize = watermarkDefaultMaxSize
+	}
+
+	return &WatermarkLoader{
+		Config: config,
+		client: &http.Client{Timeout: watermarkFetchTimeout},
+		cache:  &watermarkCache{ttl: config.CacheTTL, entries: make(map[string]watermarkCacheEntry)},
+	}
+}
+
+// LoadWatermarks configures the watermark image sources based on the server options,
+// preloading the images of the watermarks directory, if present.
+func LoadWatermarks(o ServerOptions) error {
+	config := WatermarkConfig{
+		MountPath:      o.Mount,
+		AllowedOrigins: o.AllowedOrigins,
+		MaxAllowedSize: o.MaxAllowedSize,
+		CacheTTL:       time.Duration(o.WatermarkCacheTTL) * time.Second,
+	}
+
+	if o.WatermarksDir != "" {
+		registry, err := readWatermarksDirectory(o.WatermarksDir)
+		if err != nil {
+			return err
+		}
+		config.Registry = registry
+	}
+
+	watermarks = NewWatermarkLoader(config)
+	return nil
+}
+
+// readWatermarksDirectory reads the supported images of the given directory,
+// registered by their file name without extension.
+func readWatermarksDirectory(dir string) (map[string][]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := make(map[string][]byte)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !bimg.IsImageTypeSupportedByVips(bimg.DetermineImageType(buf)).Load {
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		registry[name] = buf
+	}
+
+	if len(registry) == 0 {
+		return nil, fmt.Errorf("no supported images found in directory: %s", dir)
+	}
+
+	return registry, nil
+}
+
+// Load returns the watermark image requested by the given options.
+// Registered watermarks are requested by name, while the image param accepts
+// either a remote HTTP URL or a file path relative to the mount directory.
+func (l *WatermarkLoader) Load(o ImageOptions) ([]byte, error) {
+	if o.Watermark != "" {
+		buf, ok := l.Config.Registry[o.Watermark]
+		if !ok {
+			return nil, NewError("Unknown watermark name: "+o.Watermark, http.StatusBadRequest)
+		}
+		return buf, nil
+	}
+
+	u, err := url.Parse(o.Image)
+	if err != nil {
+		return nil, NewError("Invalid watermark image URL: "+o.Image, http.StatusBadRequest)
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return l.fetch(u)
+	}
+	if u.Scheme == "" && l.Config.MountPath != "" {
+		return l.read(o.Image)
+	}
+
+	return nil, NewError("Invalid watermark image URL: "+o.Image, http.StatusBadRequest)
+}
+
+func (l *WatermarkLoader) read(file string) ([]byte, error) {
+	// The file must be within the mount directory, and not within a sibling one sharing its prefix
+	mountPath := filepath.Clean(l.Config.MountPath)
+	file = filepath.Join(mountPath, file)
+	rel, err := filepath.Rel(mountPath, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, ErrInvalidFilePath
+	}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, ErrInvalidFilePath
+	}
+	return buf, nil
+}
+
+func (l *WatermarkLoader) fetch(u *url.URL) ([]byte, error) {
+	if shouldRestrictOrigin(u, l.Config.AllowedOrigins) {
+		return nil, NewError(fmt.Sprintf("Not allowed watermark image URL origin: %s%s", u.Host, u.Path), http.StatusForbidden)
+	}
+
+	key := u.String()
+	if buf, ok := l.cache.get(key); ok {
+		return buf, nil
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, key, nil)
+	req.Header.Set("User-Agent", "imaginary/"+Version)
+
+	res, err := l.client.Do(req)
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("Unable to retrieve watermark image. %s", key), http.StatusBadRequest)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, NewError(fmt.Sprintf("Unable to retrieve watermark image: (status=%d) (url=%s)", res.StatusCode, key), http.StatusBadRequest)
+	}
+
+	contentLength, _ := strconv.Atoi(res.Header.Get("Content-Length"))
+	if contentLength > l.Config.MaxAllowedSize {
+		return nil, NewError(fmt.Sprintf("Watermark image Content-Length %d exceeds maximum allowed %d bytes", contentLength, l.Config.MaxAllowedSize), http.StatusBadRequest)
+	}
+
+	// Read an extra byte to detect bodies exceeding the max allowed size
+	buf, err := ioutil.ReadAll(io.LimitReader(res.Body, int64(l.Config.MaxAllowedSize)+1))
+	if err != nil || len(buf) == 0 {
+		return nil, NewError("Unable to read watermark image", http.StatusBadRequest)
+	}
+	if len(buf) > l.Config.MaxAllowedSize {
+		return nil, NewError(fmt.Sprintf("Watermark image exceeds maximum allowed %d bytes", l.Config.MaxAllowedSize), http.StatusBadRequest)
+	}
+
+	l.cache.set(key, buf)
+	return buf, nil
+}
+
+func (c *watermarkCache) get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.buf, true
+}
+
+func (c *watermarkCache) set(key string, buf []byte) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= watermarkCacheMaxEntries {
+		// Evict the expired entries, or the one closest to expire otherwise
+		var oldest string
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= watermarkCacheMaxEntries {
+			delete(c.entries, oldest)
+		}
+	}
+
+	c.entries[key] = watermarkCacheEntry{buf: buf, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatermarkLoaderRemoteCache(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/test.png")
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(buf)
+	}))
+	defer ts.Close()
+
+	loader := NewWatermarkLoader(WatermarkConfig{CacheTTL: time.Minute, MaxAllowedSize: len(buf)})
+	for i := 0; i < 3; i++ {
+		body, err := loader.Load(ImageOptions{Image: ts.URL + "/logo.png"})
+		if err != nil {
+			t.Fatalf("Cannot load the watermark image: %s", err)
+		}
+		if len(body) != len(buf) {
+			t.Fatal("Invalid watermark image body")
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected the watermark image to be fetched once, got %d requests", requests)
+	}
+}
+
+func TestWatermarkLoaderRemoteRestrictions(t *testing.T) {
+	buf, _ := ioutil.ReadFile("testdata/test.png")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(buf)
+	}))
+	defer ts.Close()
+
+	origin, _ := url.Parse(ts.URL + "/logos/")
+	loader := NewWatermarkLoader(WatermarkConfig{AllowedOrigins: []*url.URL{origin}})
+
+	if _, err := loader.Load(ImageOptions{Image: ts.URL + "/logos/logo.png"}); err != nil {
+		t.Errorf("Expected an allowed watermark image origin: %s", err)
+	}
+	if _, err := loader.Load(ImageOptions{Image: ts.URL + "/private/logo.png"}); err == nil {
+		t.Error("Expected a not allowed watermark image origin error")
+	}
+
+	loader = NewWatermarkLoader(WatermarkConfig{MaxAllowedSize: len(buf) - 1})
+	if _, err := loader.Load(ImageOptions{Image: ts.URL + "/logos/logo.png"}); err == nil {
+		t.Error("Expected a watermark image max allowed size error")
+	}
+}
+
+func TestWatermarkLoaderMountAndRegistry(t *testing.T) {
+	loader := NewWatermarkLoader(WatermarkConfig{
+		MountPath: "testdata",
+		Registry:  map[string][]byte{"logo": []byte("logo")},
+	})
+
+	if body, err := loader.Load(ImageOptions{Image: "test.png"}); err != nil || len(body) == 0 {
+		t.Errorf("Cannot load the watermark image from the mount directory: %s", err)
+	}
+	if _, err := loader.Load(ImageOptions{Image: "../params.go"}); err != ErrInvalidFilePath {
+		t.Errorf("Expected an invalid file path error, got: %v", err)
+	}
+	if body, err := loader.Load(ImageOptions{Watermark: "logo"}); err != nil || string(body) != "logo" {
+		t.Errorf("Cannot load the registered watermark image: %s", err)
+	}
+	if _, err := loader.Load(ImageOptions{Watermark: "missing"}); err == nil {
+		t.Error("Expected an unknown watermark name error")
+	}
+
+	loader = NewWatermarkLoader(WatermarkConfig{})
+	if _, err := loader.Load(ImageOptions{Image: "test.png"}); err == nil {
+		t.Error("Expected an error loading files without a mount directory")
+	}
+}
+
+func TestWatermarkLoaderMountSibling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imaginary")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mount, sibling := filepath.Join(dir, "mount"), filepath.Join(dir, "mount-private")
+	for _, d := range []string{mount, sibling} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("Cannot create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, "logo.png"), []byte("logo"), 0644); err != nil {
+			t.Fatalf("Cannot write file: %s", err)
+		}
+	}
+
+	// The mount path may be defined with a trailing separator
+	for _, mountPath := range []string{mount, mount + string(filepath.Separator)} {
+		loader := NewWatermarkLoader(WatermarkConfig{MountPath: mountPath})
+		if _, err := loader.Load(ImageOptions{Image: "logo.png"}); err != nil {
+			t.Errorf("Cannot load the watermark image from %s: %s", mountPath, err)
+		}
+		if _, err := loader.Load(ImageOptions{Image: "../mount-private/logo.png"}); err != ErrInvalidFilePath {
+			t.Errorf("Expected an invalid file path error for a sibling directory of %s, got: %v", mountPath, err)
+		}
+	}
+}
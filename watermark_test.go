@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/h2non/bimg"
+)
+
+func TestValidateMarkup(t *testing.T) {
+	cases := []struct {
+		text  string
+		valid bool
+	}{
+		{"Hello world", true},
+		{"first line\nsecond line", true},
+		{"<b>bold</b> and <i>italic</i>", true},
+		{`<span foreground="red" size="x-large">Hello</span>`, true},
+		{"שלום עולם", true},
+		{"Tom &amp; Jerry", true},
+		{"Tom & Jerry", false},
+		{"<b>unclosed", false},
+		{"<script>alert(1)</script>", false},
+	}
+
+	for _, tc := range cases {
+		err := validateMarkup(tc.text)
+		if (err == nil) != tc.valid {
+			t.Errorf("Invalid markup validation for %q: %v", tc.text, err)
+		}
+		if err != nil {
+			if _, ok := err.(Error); !ok {
+				t.Errorf("Expected markup validation error to be an Error: %#v", err)
+			}
+		}
+	}
+}
+
+func TestValidateTextWatermark(t *testing.T) {
+	if err := validateTextWatermark(ImageOptions{Text: "a", TextWidth: maxTextWidth}); err != nil {
+		t.Errorf("Unexpected error for textwidth=%d: %s", maxTextWidth, err)
+	}
+	for _, o := range []ImageOptions{
+		{Text: "a", TextWidth: 100000},
+		{Text: "a", TextWidth: -1},
+		{Text: "a", StrokeWidth: maxStrokeWidth + 1},
+		{Text: "a", ShadowBlur: maxShadowBlur + 1},
+		{Text: "a", TextAngle: -45},
+		{Text: "a", ShadowBlur: -1},
+		{Text: "a", StrokeWidth: -1},
+	} {
+		err := validateTextWatermark(o)
+		if e, ok := err.(Error); !ok || e.HTTPCode() != 400 {
+			t.Errorf("Expected a bad request error for %+v: %v", o, err)
+		}
+	}
+}
+
+func TestGravityPosition(t *testing.T) {
+	cases := []struct {
+		gravity   bimg.Gravity
+		left, top int
+	}{
+		{bimg.GravityCentre, 55, 40},
+		{bimg.GravityNorth, 55, 5},
+		{bimg.GravitySouth, 55, 65},
+		{bimg.GravityEast, 80, 40},
+		{bimg.GravityWest, 10, 40},
+		{GravityNorthEast, 80, 5},
+		{GravitySouthEast, 80, 65},
+		{GravitySouthWest, 10, 65},
+		{GravityNorthWest, 10, 5},
+	}
+
+	for _, tc := range cases {
+		left, top := gravityPosition(tc.gravity, 100, 80, 10, 10, 10, 5)
+		if left != tc.left || top != tc.top {
+			t.Errorf("Invalid position for gravity %d: %d,%d != %d,%d", tc.gravity, left, top, tc.left, tc.top)
+		}
+	}
+}
+
+func TestComposeTextWatermark(t *testing.T) {
+	mask := image.NewAlpha(image.Rect(0, 0, 20, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 20; x++ {
+			mask.SetAlpha(x, y, color.Alpha{A: 255})
+		}
+	}
+
+	out := composeTextWatermark(mask, ImageOptions{Color: []uint8{255, 0, 0, 128}})
+	if out.Bounds() != image.Rect(0, 0, 20, 10) {
+		t.Fatalf("Invalid watermark bounds: %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(10, 5); c != (color.NRGBA{R: 255, A: 128}) {
+		t.Errorf("Invalid text color: %#v", c)
+	}
+
+	out = composeTextWatermark(mask, ImageOptions{StrokeWidth: 2, StrokeColor: []uint8{0, 0, 255}, Color: []uint8{255, 0, 0}})
+	if out.Bounds() != image.Rect(0, 0, 24, 14) {
+		t.Fatalf("Invalid watermark bounds with stroke: %v", out.Bounds())
+	}
+	if c := out.NRGBAAt(12, 7); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("Invalid text color: %#v", c)
+	}
+	if c := out.NRGBAAt(1, 7); c != (color.NRGBA{B: 255, A: 255}) {
+		t.Errorf("Invalid stroke color: %#v", c)
+	}
+
+	out = composeTextWatermark(mask, ImageOptions{ShadowX: 3, ShadowY: 4})
+	if out.Bounds() != image.Rect(0, 0, 23, 14) {
+		t.Fatalf("Invalid watermark bounds with shadow: %v", out.Bounds())
+	}
+
+	out = composeTextWatermark(mask, ImageOptions{TextAngle: 90})
+	if out.Bounds() != image.Rect(0, 0, 10, 20) {
+		t.Fatalf("Invalid rotated watermark bounds: %v", out.Bounds())
+	}
+}
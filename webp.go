@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// WebP container flags and chunk identifiers.
+// See: https://developers.google.com/speed/webp/docs/riff_container
+const (
+	webpAnimationFlag = 0x02
+	webpXMPFlag       = 0x04
+	webpEXIFFlag      = 0x08
+	webpAlphaFlag     = 0x10
+	webpICCFlag       = 0x20
+	webpNoBlendFlag   = 0x02
+	webpDisposeFlag   = 0x01
+)
+
+var errInvalidWebP = errors.New("invalid WebP container")
+
+// riffChunk represents a chunk of a RIFF container.
+type riffChunk struct {
+	ID   string
+	Data []byte
+}
+
+// webpFrame represents a frame of an animated WebP image.
+type webpFrame struct {
+	X, Y          int
+	Width, Height int
+	Duration      int
+	Blend         bool
+	Dispose       bool
+	Chunks        []riffChunk
+}
+
+// webpAnimation represents the frames of an animated WebP image, not yet decoded.
+type webpAnimation struct {
+	Width     int
+	Height    int
+	LoopCount int
+	Frames    []webpFrame
+}
+
+// readWebPChunks returns the chunks of a WebP RIFF container.
+func readWebPChunks(buf []byte) ([]riffChunk, error) {
+	if len(buf) < 12 || string(buf[0:4]) != "RIFF" || string(buf[8:12]) != "WEBP" {
+		return nil, errInvalidWebP
+	}
+	return readChunks(buf[12:])
+}
+
+func readChunks(buf []byte) ([]riffChunk, error) {
+	var chunks []riffChunk
+	for len(buf) >= 8 {
+		size := int(binary.LittleEndian.Uint32(buf[4:8]))
+		if size < 0 || size > len(buf)-8 {
+			return nil, errInvalidWebP
+		}
+		chunks = append(chunks, riffChunk{ID: string(buf[0:4]), Data: buf[8 : 8+size]})
+
+		// Chunks are padded to an even size
+		next := 8 + size + size%2
+		if next > len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return chunks, nil
+}
+
+// writeWebPChunks returns a WebP RIFF container with the given chunks.
+func writeWebPChunks(chunks []riffChunk) []byte {
+	body := writeChunks(chunks)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(4+len(body)))
+	buf.WriteString("WEBP")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func writeChunks(chunks []riffChunk) []byte {
+	var buf bytes.Buffer
+	for _, chunk := range chunks {
+		buf.WriteString(chunk.ID)
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(chunk.Data)))
+		buf.Write(chunk.Data)
+		if len(chunk.Data)%2 == 1 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// parseWebPAnimation parses the frames of an animated WebP image.
+// It returns nil if the image is not animated.
+func parseWebPAnimation(buf []byte) (*webpAnimation, error) {
+	chunks, err := readWebPChunks(buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].ID != "VP8X" || len(chunks[0].Data) < 10 || chunks[0].Data[0]&webpAnimationFlag == 0 {
+		return nil, nil
+	}
+
+	header := chunks[0].Data
+	anim := &webpAnimation{
+		Width:  readUint24(header[4:]) + 1,
+		Height: readUint24(header[7:]) + 1,
+	}
+
+	for _, chunk := range chunks[1:] {
+		switch chunk.ID {
+		case "ANIM":
+			if len(chunk.Data) < 6 {
+				return nil, errInvalidWebP
+			}
+			anim.LoopCount = int(binary.LittleEndian.Uint16(chunk.Data[4:6]))
+		case "ANMF":
+			if len(chunk.Data) < 16 {
+				return nil, errInvalidWebP
+			}
+			d := chunk.Data
+			frameChunks, err := readChunks(d[16:])
+			if err != nil {
+				return nil, err
+			}
+			anim.Frames = append(anim.Frames, webpFrame{
+				X:        readUint24(d[0:]) * 2,
+				Y:        readUint24(d[3:]) * 2,
+				Width:    readUint24(d[6:]) + 1,
+				Height:   readUint24(d[9:]) + 1,
+				Duration: readUint24(d[12:]),
+				Blend:    d[15]&webpNoBlendFlag == 0,
+				Dispose:  d[15]&webpDisposeFlag != 0,
+				Chunks:   frameChunks,
+			})
+		}
+	}
+
+	if len(anim.Frames) == 0 {
+		return nil, errInvalidWebP
+	}
+
+	return anim, nil
+}
+
+// stillWebP returns the frame as a standalone WebP image.
+func (f webpFrame) stillWebP() []byte {
+	var chunks []riffChunk
+	for _, chunk := range f.Chunks {
+		if chunk.ID == "ALPH" {
+			chunks = append(chunks, riffChunk{ID: "VP8X", Data: webpHeader(webpAlphaFlag, f.Width, f.Height)})
+			break
+		}
+	}
+	for _, chunk := range f.Chunks {
+		if isWebPImageChunk(chunk.ID) {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return writeWebPChunks(chunks)
+}
+
+// muxWebPAnimation returns an animated WebP image with the given still WebP frames,
+// each one covering the whole canvas and displayed for the given duration in milliseconds.
+func muxWebPAnimation(frames [][]byte, width, height int, durations []int, loopCount int) ([]byte, error) {
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loopCount))
+
+	chunks := []riffChunk{
+		{ID: "VP8X", Data: webpHeader(webpAnimationFlag|webpAlphaFlag, width, height)},
+		{ID: "ANIM", Data: anim},
+	}
+
+	for i, frame := range frames {
+		frameChunks, err := readWebPChunks(frame)
+		if err != nil {
+			return nil, err
+		}
+
+		var image []riffChunk
+		for _, chunk := range frameChunks {
+			if isWebPImageChunk(chunk.ID) {
+				image = append(image, chunk)
+			}
+		}
+
+		data := make([]byte, 16)
+		putUint24(data[6:], width-1)
+		putUint24(data[9:], height-1)
+		putUint24(data[12:], durations[i])
+		data[15] = webpNoBlendFlag
+		data = append(data, writeChunks(image)...)
+
+		chunks = append(chunks, riffChunk{ID: "ANMF", Data: data})
+	}
+
+	return writeWebPChunks(chunks), nil
+}
+
+func isWebPImageChunk(id string) bool {
+	return id == "ALPH" || id == "VP8 " || id == "VP8L"
+}
+
+func webpHeader(flags byte, width, height int) []byte {
+	header := make([]byte, 10)
+	header[0] = flags
+	putUint24(header[4:], width-1)
+	putUint24(header[7:], height-1)
+	return header
+}
+
+func readUint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
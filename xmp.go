@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// xmpAttrPattern matches the prefixed attributes of an XML start tag.
+var xmpAttrPattern = regexp.MustCompile(`\s+([\w.-]+:[\w.-]+)\s*=\s*("[^"]*"|'[^']*')`)
+
+// xmpCopyrightProperties defines the properties of the copyright metadata policy group,
+// along with every XMP Rights Management property.
+var xmpCopyrightProperties = map[string]bool{
+	"dc:creator":                      true,
+	"dc:rights":                       true,
+	"photoshop:AuthorsPosition":       true,
+	"photoshop:Credit":                true,
+	"photoshop:Source":                true,
+	"Iptc4xmpCore:CreatorContactInfo": true,
+}
+
+// xmpSerialProperties defines the properties of the serials metadata policy group.
+var xmpSerialProperties = map[string]bool{
+	"aux:SerialNumber":        true,
+	"aux:LensSerialNumber":    true,
+	"exifEX:BodySerialNumber": true,
+	"exifEX:LensSerialNumber": true,
+}
+
+// xmpThumbnailProperties defines the properties of the embedded thumbnails.
+var xmpThumbnailProperties = map[string]bool{
+	"xmp:Thumbnails": true,
+	"xap:Thumbnails": true,
+}
+
+// xmlNode represents an element of a generic XML tree, named as prefix:name.
+type xmlNode struct {
+	Name     string
+	Attrs    map[string]string
+	Children []*xmlNode
+	Text     string
+}
+
+// parseXMP parses the given XMP packet, returning the RDF properties by prefixed name.
+// Arrays are returned as lists, alternative languages as their default value and
+// structures as nested properties.
+func parseXMP(buf []byte) (map[string]interface{}, error) {
+	root, err := parseXMLTree(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	var walk func(node *xmlNode)
+	walk = func(node *xmlNode) {
+		for _, child := range node.Children {
+			if child.Name == "rdf:Description" {
+				for name, value := range xmpProperties(child) {
+					fields[name] = value
+				}
+				continue
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return fields, nil
+}
+
+// parseXMLTree builds the tree of the given XML document, keeping the namespace prefixes.
+func parseXMLTree(buf []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	decoder.Strict = false
+
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Name: xmlName(t.Name), Attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				node.Attrs[xmlName(attr.Name)] = attr.Value
+			}
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.Text += string(t)
+		}
+	}
+
+	return root, nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// xmpProperties returns the properties of an RDF description or structure,
+// defined either as attributes or as child elements.
+func xmpProperties(node *xmlNode) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for name, value := range node.Attrs {
+		if isXMPProperty(name) {
+			properties[name] = value
+		}
+	}
+	for _, child := range node.Children {
+		if child.Name == "rdf:Description" {
+			for name, value := range xmpProperties(child) {
+				properties[name] = value
+			}
+			continue
+		}
+		if value := xmpValue(child); value != nil {
+			properties[child.Name] = value
+		}
+	}
+	return properties
+}
+
+// xmpValue returns the value of an XMP property element.
+func xmpValue(node *xmlNode) interface{} {
+	if resource, ok := node.Attrs["rdf:resource"]; ok {
+		return resource
+	}
+
+	for _, child := range node.Children {
+		switch child.Name {
+		case "rdf:Bag", "rdf:Seq":
+			var values []interface{}
+			for _, item := range child.Children {
+				if value := xmpValue(item); value != nil {
+					values = append(values, value)
+				}
+			}
+			return values
+		case "rdf:Alt":
+			var value interface{}
+			for _, item := range child.Children {
+				if value == nil || item.Attrs["xml:lang"] == "x-default" {
+					value = xmpValue(item)
+				}
+			}
+			return value
+		}
+	}
+
+	if len(node.Children) > 0 || node.Attrs["rdf:parseType"] == "Resource" {
+		return xmpProperties(node)
+	}
+	for name := range node.Attrs {
+		if isXMPProperty(name) {
+			return xmpProperties(node)
+		}
+	}
+
+	if text := strings.TrimSpace(node.Text); text != "" {
+		return text
+	}
+	return nil
+}
+
+// filterXMP returns the given XMP packet without the properties not kept by the policy,
+// or nil if none is kept. Properties are removed from the packet text as is,
+// preserving the rest of the document.
+func filterXMP(buf []byte, policy MetadataPolicy) []byte {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	decoder.Strict = false
+
+	// Byte ranges of the removed properties, in document order
+	var removed [][2]int
+	kept := 0
+
+	var stack []string
+	for {
+		start := int(decoder.InputOffset())
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := xmlName(t.Name)
+			end := int(decoder.InputOffset())
+
+			// Only the top level descriptions properties are filtered, along with their whole value
+			if isXMPDescription(stack) {
+				if !policy.keeps(xmpPropertyGroups(name)...) {
+					if err := skipXMLElement(decoder); err != nil {
+						return nil
+					}
+					removed = append(removed, [2]int{start, int(decoder.InputOffset())})
+					continue
+				}
+				kept++
+			}
+
+			stack = append(stack, name)
+			if name == "rdf:Description" && isXMPDescription(stack) {
+				for _, match := range xmpAttrPattern.FindAllSubmatchIndex(buf[start:end], -1) {
+					attr := string(buf[start+match[2] : start+match[3]])
+					if !isXMPProperty(attr) {
+						continue
+					}
+					if policy.keeps(xmpPropertyGroups(attr)...) {
+						kept++
+					} else {
+						removed = append(removed, [2]int{start + match[0], start + match[1]})
+					}
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	if kept == 0 {
+		return nil
+	}
+
+	out := make([]byte, 0, len(buf))
+	offset := 0
+	for _, r := range removed {
+		out = append(out, buf[offset:r[0]]...)
+		offset = r[1]
+	}
+	return append(out, buf[offset:]...)
+}
+
+// isXMPDescription returns true if the innermost element of the stack is a top level RDF description.
+func isXMPDescription(stack []string) bool {
+	return len(stack) >= 2 && stack[len(stack)-1] == "rdf:Description" && stack[len(stack)-2] == "rdf:RDF"
+}
+
+// xmpPropertyGroups returns the metadata policy groups of a property, from the most specific one.
+func xmpPropertyGroups(name string) []string {
+	switch {
+	case strings.HasPrefix(name, "exif:GPS"):
+		return []string{MetadataGPS, MetadataXMP}
+	case xmpThumbnailProperties[name]:
+		return []string{metadataThumbnails, MetadataXMP}
+	case xmpSerialProperties[name]:
+		return []string{MetadataSerials, MetadataXMP}
+	case xmpCopyrightProperties[name] || strings.HasPrefix(name, "xmpRights:"):
+		return []string{MetadataCopyright, MetadataXMP}
+	}
+	return []string{MetadataXMP}
+}
+
+// skipXMLElement reads the tokens up to the end of the current element.
+func skipXMLElement(decoder *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// isXMPProperty returns false for the XML and RDF syntax attributes.
+func isXMPProperty(name string) bool {
+	return strings.Contains(name, ":") &&
+		!strings.HasPrefix(name, "xmlns:") &&
+		!strings.HasPrefix(name, "rdf:") &&
+		!strings.HasPrefix(name, "xml:")
+}